@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// An account hash is the Keccak-256 digest of an Ethereum address, a 256-bit
+// integer that does not always fit in the scalar field of the circuits. Its
+// canonical encoding is the digest explicitly reduced modulo the scalar field
+// order r, the value a witness would otherwise wrap it to silently. Native
+// helpers only accept canonical account hashes, so that what they compute is
+// always what the circuits see.
+//
+// Two addresses collide when their digests differ by a multiple of r. With r
+// about 0.45*2^256 on BLS12-381 (0.19*2^256 on BN254), every reduced value has
+// at most 3 (6) preimages among the digests, so finding a collision is a
+// birthday search over about 2^254 (2^253) values, some 2^127 Keccak
+// evaluations: no easier than breaking the curve itself. In-circuit, the
+// digest bytes recomposed as a field element give the same reduction for free.
+
+// ErrNonCanonicalAccountHash is returned for an account hash that is not
+// reduced modulo the scalar field
+var ErrNonCanonicalAccountHash = errors.New("account hash is not a canonical field element")
+
+// AccountHash returns the canonical account hash of an Ethereum address in the
+// BLS12-381 scalar field
+func AccountHash(address string) *big.Int {
+	return AccountHashOnCurve(ecc.BLS12_381, address)
+}
+
+// AccountHashOnCurve returns the canonical account hash of an Ethereum address
+// for a circuit compiled on curve
+func AccountHashOnCurve(curve ecc.ID, address string) *big.Int {
+	digest := hashToBigInt(hashEthereumAddress(address))
+	return digest.Mod(digest, curve.ScalarField())
+}
+
+// accountHashOfAddressOnCurve returns the canonical account hash of an
+// Ethereum address, or an error naming the address if it is malformed, where
+// AccountHashOnCurve would exit
+func accountHashOfAddressOnCurve(curve ecc.ID, address string) (*big.Int, error) {
+	if _, err := AddressBytes(address); err != nil {
+		return nil, err
+	}
+	return AccountHashOnCurve(curve, address), nil
+}
+
+// checkAccountHash rejects an account hash that a witness would wrap around
+// the scalar field
+func checkAccountHash(accountHash, field *big.Int) error {
+	if accountHash == nil {
+		return fmt.Errorf("%w: missing", ErrNonCanonicalAccountHash)
+	}
+	if accountHash.Sign() < 0 || accountHash.Cmp(field) >= 0 {
+		return fmt.Errorf("%w: %x", ErrNonCanonicalAccountHash, accountHash)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// addressWithDigestAbove draws random addresses until the Keccak-256 digest of
+// one of them is at least bound
+func addressWithDigestAbove(bound *big.Int) (string, *big.Int) {
+	for {
+		address := randomEthereumAddress()
+		digest := hashToBigInt(hashEthereumAddress(address))
+		if digest.Cmp(bound) >= 0 {
+			return address, digest
+		}
+	}
+}
+
+func TestAccountHashIsCanonical(t *testing.T) {
+
+	for _, curve := range []ecc.ID{ecc.BLS12_381, ecc.BN254} {
+		field := curve.ScalarField()
+		for _, multiple := range []int64{1, 2} {
+			bound := new(big.Int).Mul(field, big.NewInt(multiple))
+			address, digest := addressWithDigestAbove(bound)
+			accountHash := AccountHashOnCurve(curve, address)
+			if accountHash.Cmp(field) >= 0 {
+				t.Fatalf("Account hash %x is not reduced on %v", accountHash, curve)
+			}
+			if new(big.Int).Mod(digest, field).Cmp(accountHash) != 0 {
+				t.Fatalf("Account hash %x is not the digest %x modulo r on %v", accountHash, digest, curve)
+			}
+		}
+	}
+}
+
+func TestNativeHelpersRejectNonCanonicalAccountHashes(t *testing.T) {
+
+	_, digest := addressWithDigestAbove(ecc.BLS12_381.ScalarField())
+	balance, blinding := big.NewInt(100), big.NewInt(42)
+
+	for _, scheme := range []CommitmentScheme{PedersenScheme, MiMCScheme} {
+		if _, err := PrecomputeCommitment(scheme, balance, blinding, digest); !errors.Is(err, ErrNonCanonicalAccountHash) {
+			t.Fatalf("Expected ErrNonCanonicalAccountHash from %v, got %v", scheme, err)
+		}
+	}
+	if _, _, _, err := OpenAggregatedPedersenCommitment([]*big.Int{balance}, []*big.Int{blinding}, []*big.Int{digest}); !errors.Is(err, ErrNonCanonicalAccountHash) {
+		t.Fatalf("Expected ErrNonCanonicalAccountHash from the opening, got %v", err)
+	}
+	leaf := MerkleSumLeaf{AccountHash: digest, Salt: blinding, Balance: balance}
+	if _, err := NewMerkleSumTree([]MerkleSumLeaf{leaf}); !errors.Is(err, ErrNonCanonicalAccountHash) {
+		t.Fatalf("Expected ErrNonCanonicalAccountHash from the tree, got %v", err)
+	}
+}
+
+func TestCircuitsAgreeOnAccountHashesAboveTheModulus(t *testing.T) {
+
+	field := ecc.BLS12_381.ScalarField()
+	accounts := make([]AccountBalance, 3)
+	for i := range accounts {
+		address, _ := addressWithDigestAbove(field)
+		accounts[i] = AccountBalance{Address: address, Balance: big.NewInt(int64(100 * (i + 1)))}
+	}
+
+	t.Run("AddressBoundBalanceCircuit", func(t *testing.T) {
+		blinding, err := RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to generate blinding: %v", err)
+		}
+		assignment, err := NewAddressBoundBalanceAssignment(MiMCScheme, accounts[0].Address, accounts[0].Balance, blinding, testEpoch, testSnapshotDigest)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		circuit := AddressBoundBalanceCircuit{IndividualBalanceCircuit: *NewIndividualBalanceCircuit(MiMCScheme)}
+		if err := test.IsSolved(&circuit, assignment, field); err != nil {
+			t.Fatalf("Expected the in-circuit Keccak to match the native account hash: %v", err)
+		}
+	})
+
+	t.Run("MerkleSumTree", func(t *testing.T) {
+		leaves := make([]MerkleSumLeaf, len(accounts))
+		for i, account := range accounts {
+			leaf, err := NewMerkleSumLeaf(account.Address, account.Balance)
+			if err != nil {
+				t.Fatalf("Failed to build leaf: %v", err)
+			}
+			leaves[i] = leaf
+		}
+		tree, err := NewMerkleSumTree(leaves)
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+		if err := test.IsSolved(&MerkleSumTreeCircuit{
+			AccountHashes: make([]frontend.Variable, len(tree.Leaves)),
+			Salts:         make([]frontend.Variable, len(tree.Leaves)),
+			Balances:      make([]frontend.Variable, len(tree.Leaves)),
+		}, tree.Assignment(), field); err != nil {
+			t.Fatalf("Expected the circuit to be solved: %v", err)
+		}
+		proof, err := tree.InclusionProof(0)
+		if err != nil {
+			t.Fatalf("Failed to build inclusion proof: %v", err)
+		}
+		if belongs, err := proof.BelongsTo(accounts[0].Address); err != nil || !belongs {
+			t.Fatalf("Expected the inclusion proof to belong to the address (%v)", err)
+		}
+		if err := VerifyInclusionProof(proof, tree.Root()); err != nil {
+			t.Fatalf("Failed to verify inclusion proof: %v", err)
+		}
+	})
+
+	t.Run("SumAggregationCircuit", func(t *testing.T) {
+		assignment, err := NewSumAggregationAssignment(accounts, len(accounts), testEpoch, testSnapshotDigest)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		if err := test.IsSolved(NewSumAggregationCircuit(len(accounts)), assignment, field); err != nil {
+			t.Fatalf("Expected the circuit to be solved: %v", err)
+		}
+	})
+}
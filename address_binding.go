@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/sha3"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+// ADDRESS_BYTES is the length of an Ethereum address
+const ADDRESS_BYTES = 20
+
+// AddressBoundBalanceCircuit is IndividualBalanceCircuit taking the address of
+// the account privately and deriving AccountHash from it with an in-circuit
+// Keccak-256, so that the commitment provably binds to a real Ethereum address
+// rather than to an arbitrary field element. Its public inputs are the same, so
+// its proofs are aggregated like those of IndividualBalanceCircuit. Keccak is
+// expensive in a circuit: about 190k constraints over BLS12-381.
+type AddressBoundBalanceCircuit struct {
+	IndividualBalanceCircuit
+	Address [ADDRESS_BYTES]uints.U8 `gnark:"address,secret"`
+}
+
+func (circuit *AddressBoundBalanceCircuit) Define(api frontend.API) error {
+	accountHash, err := keccakAccountHash(api, circuit.Address[:])
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(accountHash, circuit.AccountHash)
+	return circuit.IndividualBalanceCircuit.Define(api)
+}
+
+// keccakAccountHash computes in-circuit the canonical account hash of an
+// address: its Keccak-256 digest read as a big-endian integer, which the field
+// arithmetic reduces like AccountHash does natively
+func keccakAccountHash(api frontend.API, address []uints.U8) (frontend.Variable, error) {
+	bytes, err := uints.New[uints.U64](api)
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := sha3.NewLegacyKeccak256(api)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every byte of the address must actually be a byte
+	input := make([]uints.U8, len(address))
+	for i := range address {
+		input[i] = bytes.ByteValueOf(address[i].Val)
+	}
+	hasher.Write(input)
+
+	accountHash := frontend.Variable(0)
+	for _, b := range hasher.Sum() {
+		accountHash = api.Add(api.Mul(accountHash, 256), b.Val)
+	}
+	return accountHash, nil
+}
+
+// AddressBytes decodes an Ethereum address into the Address assignment of
+// AddressBoundBalanceCircuit
+func AddressBytes(address string) ([ADDRESS_BYTES]uints.U8, error) {
+	var assignment [ADDRESS_BYTES]uints.U8
+	decoded, err := hex.DecodeString(strings.TrimPrefix(address, "0x"))
+	if err != nil {
+		return assignment, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if len(decoded) != ADDRESS_BYTES {
+		return assignment, fmt.Errorf("address %s is %d bytes long, expected %d", address, len(decoded), ADDRESS_BYTES)
+	}
+	copy(assignment[:], uints.NewU8Array(decoded))
+	return assignment, nil
+}
+
+// canonicalAddress returns the lowercase 0x-prefixed form of an Ethereum
+// address, so that the spellings of one address compare equal
+func canonicalAddress(address string) (string, error) {
+	if _, err := AddressBytes(address); err != nil {
+		return "", err
+	}
+	return "0x" + strings.ToLower(strings.TrimPrefix(address, "0x")), nil
+}
+
+// NewAddressBoundBalanceAssignment builds the witness assignment of
+// AddressBoundBalanceCircuit for the account of an Ethereum address
+func NewAddressBoundBalanceAssignment(scheme CommitmentScheme, address string, balance, blinding *big.Int, epoch uint64, snapshotDigest *big.Int) (*AddressBoundBalanceCircuit, error) {
+	addressBytes, err := AddressBytes(address)
+	if err != nil {
+		return nil, err
+	}
+	accountHash := AccountHash(address)
+	commitment, err := PrecomputeCommitment(scheme, balance, blinding, accountHash)
+	if err != nil {
+		return nil, err
+	}
+	return &AddressBoundBalanceCircuit{
+		IndividualBalanceCircuit: IndividualBalanceCircuit{
+			Balance:        balance,
+			Blinding:       blinding,
+			AccountHash:    accountHash,
+			Commitment:     commitment,
+			Epoch:          epoch,
+			SnapshotDigest: snapshotDigest,
+		},
+		Address: addressBytes,
+	}, nil
+}
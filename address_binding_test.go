@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+)
+
+func TestAddressBytes(t *testing.T) {
+
+	address := randomEthereumAddress()
+	addressBytes, err := AddressBytes(address)
+	if err != nil {
+		t.Fatalf("Failed to decode address: %v", err)
+	}
+	decoded, err := hex.DecodeString(address[2:])
+	if err != nil {
+		t.Fatalf("Failed to decode address: %v", err)
+	}
+	for i, b := range decoded {
+		if addressBytes[i].Val != b {
+			t.Fatalf("Expected byte %d to be %d, got %v", i, b, addressBytes[i].Val)
+		}
+	}
+
+	for _, invalid := range []string{"0x1234", address + "00", "0xzz" + address[4:]} {
+		if _, err := AddressBytes(invalid); err == nil {
+			t.Fatalf("Expected an error for address %s", invalid)
+		}
+	}
+}
+
+func TestAddressBoundBalanceCircuit(t *testing.T) {
+
+	blinding, err := RandomBlinding()
+	if err != nil {
+		t.Fatalf("Failed to generate blinding: %v", err)
+	}
+	address, otherAddress := randomEthereumAddress(), randomEthereumAddress()
+	valid, err := NewAddressBoundBalanceAssignment(MiMCScheme, address, big.NewInt(1000), blinding, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	other, err := NewAddressBoundBalanceAssignment(MiMCScheme, otherAddress, big.NewInt(1000), blinding, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(assignment *AddressBoundBalanceCircuit)
+		valid  bool
+	}{
+		{"HashOfTheAddress", func(assignment *AddressBoundBalanceCircuit) {}, true},
+		{"AnotherAddress", func(assignment *AddressBoundBalanceCircuit) {
+			assignment.Address = other.Address
+		}, false},
+		// A commitment to an arbitrary account hash is no longer accepted
+		{"CommitmentToAnotherAccountHash", func(assignment *AddressBoundBalanceCircuit) {
+			assignment.AccountHash, assignment.Commitment = other.AccountHash, other.Commitment
+		}, false},
+		{"NonByteAddress", func(assignment *AddressBoundBalanceCircuit) {
+			assignment.Address[0] = uints.U8{Val: 256}
+		}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := *valid
+			tc.modify(&assignment)
+			err := test.IsSolved(&AddressBoundBalanceCircuit{IndividualBalanceCircuit: *NewIndividualBalanceCircuit(MiMCScheme)}, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
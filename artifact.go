@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// Serialized constraint systems, keys, proofs and witnesses start with a
+// header recording their kind and the backend and curve they belong to. Their
+// gnark encoding does not say which curve it is on, so the header tells how
+// to read an artifact back and keeps it from being used on another curve.
+
+// ErrArtifactMismatch is returned for an artifact of another kind, backend or
+// curve than expected
+var ErrArtifactMismatch = errors.New("artifact mismatch")
+
+// artifactMagic starts every serialized artifact
+var artifactMagic = [4]byte{'z', 'k', 'b', 'a'}
+
+// ArtifactKind is what a serialized artifact holds
+type ArtifactKind uint8
+
+const (
+	ConstraintSystemArtifact ArtifactKind = iota + 1
+	ProvingKeyArtifact
+	VerifyingKeyArtifact
+	ProofArtifact
+	WitnessArtifact
+)
+
+func (kind ArtifactKind) String() string {
+	switch kind {
+	case ConstraintSystemArtifact:
+		return "constraint system"
+	case ProvingKeyArtifact:
+		return "proving key"
+	case VerifyingKeyArtifact:
+		return "verifying key"
+	case ProofArtifact:
+		return "proof"
+	case WitnessArtifact:
+		return "witness"
+	default:
+		return fmt.Sprintf("ArtifactKind(%d)", uint8(kind))
+	}
+}
+
+// Artifact is a constraint system, key, proof or witness
+type Artifact interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// ArtifactHeader describes a serialized artifact
+type ArtifactHeader struct {
+	Kind    ArtifactKind
+	Backend backend.ID // Backend of the artifact, that of its writer for a witness
+	Curve   ecc.ID
+}
+
+// WriteArtifact writes an artifact of the backend after its header. The
+// artifact must be on the curve of the backend.
+func (b ProvingBackend) WriteArtifact(w io.Writer, kind ArtifactKind, artifact Artifact) (int64, error) {
+	curve, err := curveOf(artifact)
+	if err != nil {
+		return 0, err
+	}
+	if curve != b.CurveID() {
+		return 0, fmt.Errorf("%w: %v %v written by a %v backend", ErrArtifactMismatch, curve, kind, b)
+	}
+
+	header := struct {
+		Magic   [4]byte
+		Kind    ArtifactKind
+		Backend backend.ID
+		Curve   ecc.ID
+	}{artifactMagic, kind, b.ID, curve}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	n, err := artifact.WriteTo(w)
+	return int64(binary.Size(header)) + n, err
+}
+
+// ReadArtifact reads an artifact written by WriteArtifact, whatever its kind,
+// backend and curve, as described by its header
+func ReadArtifact(r io.Reader) (ArtifactHeader, Artifact, error) {
+	var header struct {
+		Magic   [4]byte
+		Kind    ArtifactKind
+		Backend backend.ID
+		Curve   ecc.ID
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return ArtifactHeader{}, nil, err
+	}
+	if header.Magic != artifactMagic {
+		return ArtifactHeader{}, nil, errors.New("not a serialized artifact")
+	}
+	description := ArtifactHeader{Kind: header.Kind, Backend: header.Backend, Curve: header.Curve}
+
+	artifact, err := newArtifact(description)
+	if err != nil {
+		return ArtifactHeader{}, nil, err
+	}
+	if _, err := artifact.ReadFrom(r); err != nil {
+		return ArtifactHeader{}, nil, err
+	}
+	return description, artifact, nil
+}
+
+// ReadArtifact reads an artifact of the given kind written for the backend.
+// Artifacts of another kind, backend or curve are rejected with
+// ErrArtifactMismatch.
+func (b ProvingBackend) ReadArtifact(r io.Reader, kind ArtifactKind) (Artifact, error) {
+	header, artifact, err := ReadArtifact(r)
+	if err != nil {
+		return nil, err
+	}
+	// A witness does not depend on the backend
+	if header.Kind != kind || header.Curve != b.CurveID() || (kind != WitnessArtifact && header.Backend != b.ID) {
+		return nil, fmt.Errorf("%w: %v %v %v for a %v %v", ErrArtifactMismatch, header.Backend, header.Curve, header.Kind, b, kind)
+	}
+	return artifact, nil
+}
+
+// newArtifact allocates the artifact a header describes
+func newArtifact(header ArtifactHeader) (Artifact, error) {
+	if !slices.Contains(SupportedCurves, header.Curve) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedCurve, header.Curve)
+	}
+	if header.Kind == WitnessArtifact {
+		return witness.New(header.Curve.ScalarField())
+	}
+
+	switch header.Backend {
+	case backend.GROTH16:
+		switch header.Kind {
+		case ConstraintSystemArtifact:
+			return groth16.NewCS(header.Curve), nil
+		case ProvingKeyArtifact:
+			return groth16.NewProvingKey(header.Curve), nil
+		case VerifyingKeyArtifact:
+			return groth16.NewVerifyingKey(header.Curve), nil
+		case ProofArtifact:
+			return groth16.NewProof(header.Curve), nil
+		}
+	case backend.PLONK:
+		switch header.Kind {
+		case ConstraintSystemArtifact:
+			return plonk.NewCS(header.Curve), nil
+		case ProvingKeyArtifact:
+			return plonk.NewProvingKey(header.Curve), nil
+		case VerifyingKeyArtifact:
+			return plonk.NewVerifyingKey(header.Curve), nil
+		case ProofArtifact:
+			return plonk.NewProof(header.Curve), nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedBackend, header.Backend)
+	}
+	return nil, fmt.Errorf("unknown %v", header.Kind)
+}
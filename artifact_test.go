@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+// roundTrip writes an artifact and reads it back as the given backend
+func roundTrip(t *testing.T, writer, reader ProvingBackend, kind ArtifactKind, artifact Artifact) (Artifact, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := writer.WriteArtifact(&buf, kind, artifact); err != nil {
+		t.Fatalf("Failed to write %v: %v", kind, err)
+	}
+	return reader.ReadArtifact(&buf, kind)
+}
+
+func TestProofsOnEverySupportedCurve(t *testing.T) {
+
+	unsafeSRS := func(cs constraint.ConstraintSystem) (kzg.SRS, kzg.SRS, error) {
+		return unsafekzg.NewSRS(cs)
+	}
+	accounts := createAccountBalances(4)
+
+	for _, curve := range SupportedCurves {
+		provingBackends := []ProvingBackend{Groth16Backend.OnCurve(curve)}
+		// PLONK on BW6-761 takes longer than the rest of the test together
+		if curve != ecc.BW6_761 {
+			provingBackends = append(provingBackends, PlonkBackend(unsafeSRS).OnCurve(curve))
+		}
+		for _, provingBackend := range provingBackends {
+			t.Run(provingBackend.String(), func(t *testing.T) {
+				cs, err := provingBackend.Compile(NewSumAggregationCircuit(len(accounts)))
+				if err != nil {
+					t.Fatalf("Failed to compile circuit: %v", err)
+				}
+				pk, vk, err := provingBackend.Setup(cs)
+				if err != nil {
+					t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+				}
+
+				// The circuit and verifying key go through their serialization,
+				// proving keys being too slow to decode on BW6-761 for a test
+				artifact, err := roundTrip(t, provingBackend, provingBackend, ConstraintSystemArtifact, cs)
+				if err != nil {
+					t.Fatalf("Failed to read constraint system: %v", err)
+				}
+				cs = artifact.(constraint.ConstraintSystem)
+				if artifact, err = roundTrip(t, provingBackend, provingBackend, VerifyingKeyArtifact, vk); err != nil {
+					t.Fatalf("Failed to read verifying key: %v", err)
+				}
+				vk = artifact.(VerifyingKey)
+
+				assignment, err := NewSumAggregationAssignmentOnCurve(curve, accounts, len(accounts), testEpoch, testSnapshotDigest)
+				if err != nil {
+					t.Fatalf("Failed to create assignment: %v", err)
+				}
+				fw, err := provingBackend.NewWitness(assignment)
+				if err != nil {
+					t.Fatalf("Failed to create witness: %v", err)
+				}
+				proof, err := Prove(cs, pk, fw)
+				if err != nil {
+					t.Fatalf("Failed to generate proof: %v", err)
+				}
+				pw, err := fw.Public()
+				if err != nil {
+					t.Fatalf("Failed to create public witness: %v", err)
+				}
+
+				if artifact, err = roundTrip(t, provingBackend, provingBackend, ProofArtifact, proof); err != nil {
+					t.Fatalf("Failed to read proof: %v", err)
+				}
+				proof = artifact.(Proof)
+				if artifact, err = roundTrip(t, provingBackend, provingBackend, WitnessArtifact, pw); err != nil {
+					t.Fatalf("Failed to read public witness: %v", err)
+				}
+				pw = artifact.(witness.Witness)
+				if err := Verify(proof, vk, pw); err != nil {
+					t.Fatalf("Failed to verify proof: %v", err)
+				}
+
+				// Artifacts are not read as those of another curve, backend or kind
+				anotherCurve := ecc.BN254
+				if curve == ecc.BN254 {
+					anotherCurve = ecc.BLS12_381
+				}
+				anotherBackend := Groth16Backend.OnCurve(curve)
+				if provingBackend.ID == anotherBackend.ID {
+					anotherBackend = PlonkBackend(unsafeSRS).OnCurve(curve)
+				}
+				for _, reader := range []ProvingBackend{provingBackend.OnCurve(anotherCurve), anotherBackend} {
+					if _, err := roundTrip(t, provingBackend, reader, VerifyingKeyArtifact, vk); !errors.Is(err, ErrArtifactMismatch) {
+						t.Fatalf("Expected ErrArtifactMismatch reading a verifying key as %v, got %v", reader, err)
+					}
+				}
+				var buf bytes.Buffer
+				if _, err := provingBackend.WriteArtifact(&buf, ProofArtifact, proof); err != nil {
+					t.Fatalf("Failed to write proof: %v", err)
+				}
+				if _, err := provingBackend.ReadArtifact(&buf, VerifyingKeyArtifact); !errors.Is(err, ErrArtifactMismatch) {
+					t.Fatalf("Expected ErrArtifactMismatch reading a proof as a verifying key, got %v", err)
+				}
+				if _, err := provingBackend.OnCurve(anotherCurve).WriteArtifact(&buf, ProofArtifact, proof); !errors.Is(err, ErrArtifactMismatch) {
+					t.Fatalf("Expected ErrArtifactMismatch writing a proof on another curve, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestProofsOfAnotherCurveDoNotVerify(t *testing.T) {
+
+	accounts := createAccountBalances(4)
+	proofs := make([]Proof, 2)
+	vks := make([]VerifyingKey, 2)
+	pws := make([]witness.Witness, 2)
+	for i, curve := range []ecc.ID{ecc.BN254, ecc.BLS12_381} {
+		provingBackend := Groth16Backend.OnCurve(curve)
+		cs, err := provingBackend.Compile(NewSumAggregationCircuit(len(accounts)))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		var pk ProvingKey
+		if pk, vks[i], err = provingBackend.Setup(cs); err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		assignment, err := NewSumAggregationAssignmentOnCurve(curve, accounts, len(accounts), testEpoch, testSnapshotDigest)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		fw, err := provingBackend.NewWitness(assignment)
+		if err != nil {
+			t.Fatalf("Failed to create witness: %v", err)
+		}
+		if proofs[i], err = Prove(cs, pk, fw); err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		if pws[i], err = fw.Public(); err != nil {
+			t.Fatalf("Failed to create public witness: %v", err)
+		}
+	}
+
+	if err := Verify(proofs[0], vks[1], pws[1]); !errors.Is(err, ErrArtifactMismatch) {
+		t.Fatalf("Expected ErrArtifactMismatch verifying a BN254 proof with a BLS12-381 key, got %v", err)
+	}
+	if err := Verify(proofs[1], vks[1], pws[0]); !errors.Is(err, ErrArtifactMismatch) {
+		t.Fatalf("Expected ErrArtifactMismatch verifying against a BN254 public witness, got %v", err)
+	}
+
+	t.Run("ParseCurve", func(t *testing.T) {
+		if curve, err := ParseCurve("bn254"); err != nil || curve != ecc.BN254 {
+			t.Fatalf("Expected BN254, got %v, %v", curve, err)
+		}
+		if _, err := ParseCurve("bls24_315"); !errors.Is(err, ErrUnsupportedCurve) {
+			t.Fatalf("Expected ErrUnsupportedCurve, got %v", err)
+		}
+		if _, err := Groth16Backend.OnCurve(ecc.BLS24_315).Compile(NewSumAggregationCircuit(4)); !errors.Is(err, ErrUnsupportedCurve) {
+			t.Fatalf("Expected ErrUnsupportedCurve, got %v", err)
+		}
+	})
+}
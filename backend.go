@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	kzg_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/kzg"
+	fr_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	kzg_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/kzg"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	fr_bw6761 "github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	kzg_bw6761 "github.com/consensys/gnark-crypto/ecc/bw6-761/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bls12377 "github.com/consensys/gnark/backend/plonk/bls12-377"
+	plonk_bls12381 "github.com/consensys/gnark/backend/plonk/bls12-381"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	plonk_bw6761 "github.com/consensys/gnark/backend/plonk/bw6-761"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+)
+
+// Circuits are proven either with Groth16, whose setup is specific to every
+// circuit, or with PLONK, which only needs a KZG SRS at least as large as the
+// circuit. One universal SRS then serves every circuit, so changing a size
+// such as NB_ACCOUNTS does not call for a new trusted setup. Keys and proofs
+// remember their backend and curve: only compiling a circuit, setting it up
+// and creating its witnesses depend on the choice of ProvingBackend.
+
+// DEFAULT_CURVE is the curve circuits are compiled on unless another one is
+// selected. Proofs verified on Ethereum need BN254, whose pairing is the only
+// one precompiled.
+const DEFAULT_CURVE = ecc.BLS12_381
+
+// SupportedCurves are the curves circuits can be compiled on. Each of them
+// embeds the twisted Edwards curve of the Pedersen commitments.
+var SupportedCurves = []ecc.ID{ecc.BN254, ecc.BLS12_381, ecc.BLS12_377, ecc.BW6_761}
+
+// ErrUnsupportedBackend is returned for a backend other than Groth16 and PLONK
+var ErrUnsupportedBackend = errors.New("unsupported proving backend")
+
+// ErrUnsupportedCurve is returned for a curve not in SupportedCurves
+var ErrUnsupportedCurve = errors.New("unsupported curve")
+
+// Proof is a Groth16 or PLONK proof
+type Proof interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// ProvingKey is a Groth16 or PLONK proving key
+type ProvingKey interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// VerifyingKey is a Groth16 or PLONK verifying key
+type VerifyingKey interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// KZGSRSProvider returns the KZG SRS of a circuit compiled for PLONK, in
+// canonical and Lagrange form
+type KZGSRSProvider func(cs constraint.ConstraintSystem) (canonical, lagrange kzg.SRS, err error)
+
+// ProvingBackend compiles circuits on a curve and sets them up for Groth16 or
+// PLONK
+type ProvingBackend struct {
+	ID    backend.ID
+	Curve ecc.ID         // Curve of the circuits (DEFAULT_CURVE if unset)
+	SRS   KZGSRSProvider // Source of the KZG SRS on Curve, required by PLONK
+}
+
+// Groth16Backend proves circuits with Groth16 over R1CS
+var Groth16Backend = ProvingBackend{ID: backend.GROTH16}
+
+// PlonkBackend proves circuits with PLONK over sparse constraint systems,
+// using the KZG SRS from srs, typically UniversalSRS.ForCircuit
+func PlonkBackend(srs KZGSRSProvider) ProvingBackend {
+	return ProvingBackend{ID: backend.PLONK, SRS: srs}
+}
+
+// OnCurve returns the backend compiling circuits on curve
+func (b ProvingBackend) OnCurve(curve ecc.ID) ProvingBackend {
+	b.Curve = curve
+	return b
+}
+
+// CurveID returns the curve of the circuits
+func (b ProvingBackend) CurveID() ecc.ID {
+	if b.Curve == ecc.UNKNOWN {
+		return DEFAULT_CURVE
+	}
+	return b.Curve
+}
+
+// ParseCurve returns the supported curve of the given name, such as "bn254"
+func ParseCurve(name string) (ecc.ID, error) {
+	curve, err := ecc.IDFromString(name)
+	if err == nil && slices.Contains(SupportedCurves, curve) {
+		return curve, nil
+	}
+	return ecc.UNKNOWN, fmt.Errorf("%w: %q", ErrUnsupportedCurve, name)
+}
+
+// NewProvingBackend selects a backend by name, "groth16" or "plonk", on
+// DEFAULT_CURVE
+func NewProvingBackend(name string, srs KZGSRSProvider) (ProvingBackend, error) {
+	switch name {
+	case backend.GROTH16.String():
+		return Groth16Backend, nil
+	case backend.PLONK.String():
+		if srs == nil {
+			return ProvingBackend{}, errors.New("PLONK needs a KZG SRS")
+		}
+		return PlonkBackend(srs), nil
+	default:
+		return ProvingBackend{}, fmt.Errorf("%w: %q", ErrUnsupportedBackend, name)
+	}
+}
+
+func (b ProvingBackend) String() string {
+	return b.ID.String() + "/" + b.CurveID().String()
+}
+
+// Compile compiles circuit into the constraint system of the backend
+func (b ProvingBackend) Compile(circuit frontend.Circuit) (constraint.ConstraintSystem, error) {
+	if !slices.Contains(SupportedCurves, b.CurveID()) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedCurve, b.CurveID())
+	}
+	field := b.CurveID().ScalarField()
+	switch b.ID {
+	case backend.GROTH16:
+		return frontend.Compile(field, r1cs.NewBuilder, circuit)
+	case backend.PLONK:
+		return frontend.Compile(field, scs.NewBuilder, circuit)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedBackend, b.ID)
+	}
+}
+
+// NewWitness creates the witness of an assignment in the scalar field of the
+// curve, PublicOnly giving the public witness
+func (b ProvingBackend) NewWitness(assignment frontend.Circuit, opts ...frontend.WitnessOption) (witness.Witness, error) {
+	return frontend.NewWitness(assignment, b.CurveID().ScalarField(), opts...)
+}
+
+// Setup generates the proving and verifying keys of a circuit compiled by
+// Compile
+func (b ProvingBackend) Setup(cs constraint.ConstraintSystem) (ProvingKey, VerifyingKey, error) {
+	switch b.ID {
+	case backend.GROTH16:
+		pk, vk, err := groth16.Setup(cs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pk, vk, nil
+	case backend.PLONK:
+		if b.SRS == nil {
+			return nil, nil, errors.New("PLONK needs a KZG SRS")
+		}
+		canonical, lagrange, err := b.SRS(cs)
+		if err != nil {
+			return nil, nil, err
+		}
+		pk, vk, err := plonk.Setup(cs, canonical, lagrange)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pk, vk, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %v", ErrUnsupportedBackend, b.ID)
+	}
+}
+
+// Prove proves a full witness with the backend of the proving key
+func Prove(cs constraint.ConstraintSystem, pk ProvingKey, fullWitness witness.Witness, opts ...backend.ProverOption) (Proof, error) {
+	switch pk := pk.(type) {
+	case groth16.ProvingKey:
+		proof, err := groth16.Prove(cs, pk, fullWitness, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return proof, nil
+	case plonk.ProvingKey:
+		proof, err := plonk.Prove(cs, pk, fullWitness, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return proof, nil
+	default:
+		return nil, fmt.Errorf("%w: proving key %T", ErrUnsupportedBackend, pk)
+	}
+}
+
+// Verify verifies a proof against a public witness with the backend of the
+// verifying key
+func Verify(proof Proof, vk VerifyingKey, publicWitness witness.Witness, opts ...backend.VerifierOption) error {
+	curve, err := curveOf(vk)
+	if err != nil {
+		return err
+	}
+	for _, value := range []any{proof, publicWitness} {
+		if valueCurve, err := curveOf(value); err != nil || valueCurve != curve {
+			return fmt.Errorf("%w: %T is not on %v", ErrArtifactMismatch, value, curve)
+		}
+	}
+
+	switch vk := vk.(type) {
+	case groth16.VerifyingKey:
+		groth16Proof, ok := proof.(groth16.Proof)
+		if !ok {
+			return fmt.Errorf("%T is not a Groth16 proof", proof)
+		}
+		return groth16.Verify(groth16Proof, vk, publicWitness, opts...)
+	case plonk.VerifyingKey:
+		// plonk.Verify panics on a proof of another backend, and Groth16
+		// proofs have every method of a PLONK one
+		plonkProof, ok := proof.(plonk.Proof)
+		if _, isGroth16 := proof.(groth16.Proof); !ok || isGroth16 {
+			return fmt.Errorf("%T is not a PLONK proof", proof)
+		}
+		return plonk.Verify(plonkProof, vk, publicWitness, opts...)
+	default:
+		return fmt.Errorf("%w: verifying key %T", ErrUnsupportedBackend, vk)
+	}
+}
+
+// curveOf returns the curve of a constraint system, witness, key or proof of
+// either backend
+func curveOf(value any) (ecc.ID, error) {
+	switch value := value.(type) {
+	case constraint.ConstraintSystem:
+		return curveOfField(value.Field())
+	case witness.Witness:
+		switch value.Vector().(type) {
+		case fr_bn254.Vector:
+			return ecc.BN254, nil
+		case fr_bls12381.Vector:
+			return ecc.BLS12_381, nil
+		case fr_bls12377.Vector:
+			return ecc.BLS12_377, nil
+		case fr_bw6761.Vector:
+			return ecc.BW6_761, nil
+		}
+	case interface{ CurveID() ecc.ID }:
+		return value.CurveID(), nil
+	case *plonk_bn254.ProvingKey, *plonk_bn254.VerifyingKey, *plonk_bn254.Proof:
+		return ecc.BN254, nil
+	case *plonk_bls12381.ProvingKey, *plonk_bls12381.VerifyingKey, *plonk_bls12381.Proof:
+		return ecc.BLS12_381, nil
+	case *plonk_bls12377.ProvingKey, *plonk_bls12377.VerifyingKey, *plonk_bls12377.Proof:
+		return ecc.BLS12_377, nil
+	case *plonk_bw6761.ProvingKey, *plonk_bw6761.VerifyingKey, *plonk_bw6761.Proof:
+		return ecc.BW6_761, nil
+	}
+	return ecc.UNKNOWN, fmt.Errorf("%w: %T", ErrUnsupportedCurve, value)
+}
+
+// UniversalSRS is a KZG SRS in canonical form, the output of a powers of tau
+// ceremony in production, from which the SRS of every PLONK circuit up to its
+// size is derived. The Lagrange form of every domain size is computed once.
+type UniversalSRS struct {
+	canonical kzg.SRS
+	curve     ecc.ID
+	size      int
+	lagrange  map[int]kzg.SRS
+	lock      sync.Mutex
+}
+
+// NewUniversalSRS wraps a canonical KZG SRS on BN254, BLS12-381, BLS12-377 or
+// BW6-761
+func NewUniversalSRS(canonical kzg.SRS) (*UniversalSRS, error) {
+	universal := &UniversalSRS{canonical: canonical, lagrange: make(map[int]kzg.SRS)}
+	switch srs := canonical.(type) {
+	case *kzg_bn254.SRS:
+		universal.curve, universal.size = ecc.BN254, len(srs.Pk.G1)
+	case *kzg_bls12381.SRS:
+		universal.curve, universal.size = ecc.BLS12_381, len(srs.Pk.G1)
+	case *kzg_bls12377.SRS:
+		universal.curve, universal.size = ecc.BLS12_377, len(srs.Pk.G1)
+	case *kzg_bw6761.SRS:
+		universal.curve, universal.size = ecc.BW6_761, len(srs.Pk.G1)
+	default:
+		return nil, fmt.Errorf("unsupported KZG SRS %T", canonical)
+	}
+	return universal, nil
+}
+
+// ReadUniversalSRS reads a canonical KZG SRS of curve
+func ReadUniversalSRS(curve ecc.ID, reader io.Reader) (*UniversalSRS, error) {
+	canonical := kzg.NewSRS(curve)
+	if canonical == nil {
+		return nil, fmt.Errorf("no KZG SRS on %v", curve)
+	}
+	if _, err := canonical.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return NewUniversalSRS(canonical)
+}
+
+// ForCircuit returns the canonical SRS of cs, a prefix of the universal one,
+// and its Lagrange form. It is a KZGSRSProvider.
+func (srs *UniversalSRS) ForCircuit(cs constraint.ConstraintSystem) (kzg.SRS, kzg.SRS, error) {
+	if curve, err := curveOfField(cs.Field()); err != nil || curve != srs.curve {
+		return nil, nil, fmt.Errorf("the circuit is not compiled on %v", srs.curve)
+	}
+	sizeCanonical, sizeLagrange := plonk.SRSSize(cs)
+	if sizeCanonical > srs.size {
+		return nil, nil, fmt.Errorf("the circuit needs a KZG SRS of %d points, got %d", sizeCanonical, srs.size)
+	}
+
+	srs.lock.Lock()
+	defer srs.lock.Unlock()
+	lagrange, ok := srs.lagrange[sizeLagrange]
+
+	var canonical kzg.SRS
+	var err error
+	switch universal := srs.canonical.(type) {
+	case *kzg_bn254.SRS:
+		canonical = &kzg_bn254.SRS{Pk: kzg_bn254.ProvingKey{G1: universal.Pk.G1[:sizeCanonical]}, Vk: universal.Vk}
+		if !ok {
+			var g1 []kzg_bn254.Digest
+			g1, err = kzg_bn254.ToLagrangeG1(universal.Pk.G1[:sizeLagrange])
+			lagrange = &kzg_bn254.SRS{Pk: kzg_bn254.ProvingKey{G1: g1}, Vk: universal.Vk}
+		}
+	case *kzg_bls12381.SRS:
+		canonical = &kzg_bls12381.SRS{Pk: kzg_bls12381.ProvingKey{G1: universal.Pk.G1[:sizeCanonical]}, Vk: universal.Vk}
+		if !ok {
+			var g1 []kzg_bls12381.Digest
+			g1, err = kzg_bls12381.ToLagrangeG1(universal.Pk.G1[:sizeLagrange])
+			lagrange = &kzg_bls12381.SRS{Pk: kzg_bls12381.ProvingKey{G1: g1}, Vk: universal.Vk}
+		}
+	case *kzg_bls12377.SRS:
+		canonical = &kzg_bls12377.SRS{Pk: kzg_bls12377.ProvingKey{G1: universal.Pk.G1[:sizeCanonical]}, Vk: universal.Vk}
+		if !ok {
+			var g1 []kzg_bls12377.Digest
+			g1, err = kzg_bls12377.ToLagrangeG1(universal.Pk.G1[:sizeLagrange])
+			lagrange = &kzg_bls12377.SRS{Pk: kzg_bls12377.ProvingKey{G1: g1}, Vk: universal.Vk}
+		}
+	case *kzg_bw6761.SRS:
+		canonical = &kzg_bw6761.SRS{Pk: kzg_bw6761.ProvingKey{G1: universal.Pk.G1[:sizeCanonical]}, Vk: universal.Vk}
+		if !ok {
+			var g1 []kzg_bw6761.Digest
+			g1, err = kzg_bw6761.ToLagrangeG1(universal.Pk.G1[:sizeLagrange])
+			lagrange = &kzg_bw6761.SRS{Pk: kzg_bw6761.ProvingKey{G1: g1}, Vk: universal.Vk}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	srs.lagrange[sizeLagrange] = lagrange
+	return canonical, lagrange, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	kzg_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+var backendFlag = flag.String("backend", "groth16", "proving backend of the proof and verification tests, groth16 or plonk")
+
+// testProvingBackend returns the backend selected with -backend. PLONK uses
+// an SRS whose toxic waste is known, fit for tests only.
+func testProvingBackend(t *testing.T) ProvingBackend {
+	t.Helper()
+	provingBackend, err := NewProvingBackend(*backendFlag, func(cs constraint.ConstraintSystem) (kzg.SRS, kzg.SRS, error) {
+		return unsafekzg.NewSRS(cs)
+	})
+	if err != nil {
+		t.Fatalf("Invalid -backend: %v", err)
+	}
+	return provingBackend
+}
+
+// createUniversalSRS draws a BLS12-381 SRS of size points for tests
+func createUniversalSRS(size uint64) (*UniversalSRS, error) {
+	tau, err := rand.Int(rand.Reader, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := kzg_bls12381.NewSRS(size, tau)
+	if err != nil {
+		return nil, err
+	}
+	return NewUniversalSRS(canonical)
+}
+
+func TestPlonkBackendSharesOneUniversalSRS(t *testing.T) {
+
+	srs, err := createUniversalSRS(1<<13 + 3)
+	if err != nil {
+		t.Fatalf("Failed to create SRS: %v", err)
+	}
+	// The SRS goes through its serialization, as if it came from a ceremony
+	var buf bytes.Buffer
+	if _, err := srs.canonical.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write SRS: %v", err)
+	}
+	if srs, err = ReadUniversalSRS(ecc.BLS12_381, &buf); err != nil {
+		t.Fatalf("Failed to read SRS: %v", err)
+	}
+	provingBackend := PlonkBackend(srs.ForCircuit)
+
+	// Two circuits of different sizes are set up from the same SRS
+	for _, tc := range []struct {
+		name       string
+		nbAccounts int
+	}{{"FourAccounts", 4}, {"EightAccounts", 8}} {
+		t.Run(tc.name, func(t *testing.T) {
+			nbAccounts := tc.nbAccounts
+			cs, err := provingBackend.Compile(NewSumAggregationCircuit(nbAccounts))
+			if err != nil {
+				t.Fatalf("Failed to compile circuit: %v", err)
+			}
+			pk, vk, err := provingBackend.Setup(cs)
+			if err != nil {
+				t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+			}
+
+			assignment, err := NewSumAggregationAssignment(createAccountBalances(nbAccounts), nbAccounts, testEpoch, testSnapshotDigest)
+			if err != nil {
+				t.Fatalf("Failed to create assignment: %v", err)
+			}
+			fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+			if err != nil {
+				t.Fatalf("Failed to create witness: %v", err)
+			}
+			proof, err := Prove(cs, pk, fw)
+			if err != nil {
+				t.Fatalf("Failed to generate proof: %v", err)
+			}
+
+			pw, err := fw.Public()
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			if err := Verify(proof, vk, pw); err != nil {
+				t.Fatalf("Failed to verify proof: %v", err)
+			}
+
+			assignment.TotalSum = new(big.Int).Add(variableToBigInt(assignment.TotalSum), big.NewInt(1))
+			opw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			if err := Verify(proof, vk, opw); err == nil {
+				t.Fatal("Expected the proof not to verify against another total")
+			}
+		})
+	}
+
+	t.Run("CircuitLargerThanTheSRS", func(t *testing.T) {
+		cs, err := provingBackend.Compile(NewSumAggregationCircuit(256))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		if _, _, err := provingBackend.Setup(cs); err == nil {
+			t.Fatal("Expected the setup to fail")
+		}
+	})
+}
+
+func TestProofsOfAnotherBackendDoNotVerify(t *testing.T) {
+
+	srs, err := createUniversalSRS(1<<12 + 3)
+	if err != nil {
+		t.Fatalf("Failed to create SRS: %v", err)
+	}
+	assignment, err := NewSumAggregationAssignment(createAccountBalances(4), 4, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+
+	proofs := make([]Proof, 2)
+	vks := make([]VerifyingKey, 2)
+	for i, provingBackend := range []ProvingBackend{Groth16Backend, PlonkBackend(srs.ForCircuit)} {
+		cs, err := provingBackend.Compile(NewSumAggregationCircuit(4))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		var pk ProvingKey
+		pk, vks[i], err = provingBackend.Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		if proofs[i], err = Prove(cs, pk, fw); err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		if err := Verify(proofs[i], vks[i], pw); err != nil {
+			t.Fatalf("Failed to verify %v proof: %v", provingBackend, err)
+		}
+	}
+
+	if err := Verify(proofs[1], vks[0], pw); err == nil {
+		t.Fatal("Expected a PLONK proof not to verify with a Groth16 key")
+	}
+	if err := Verify(proofs[0], vks[1], pw); err == nil {
+		t.Fatal("Expected a Groth16 proof not to verify with a PLONK key")
+	}
+	if _, err := NewProvingBackend("stark", nil); !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("Expected ErrUnsupportedBackend, got %v", err)
+	}
+}
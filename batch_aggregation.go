@@ -0,0 +1,507 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// Large snapshots are split into batches of a fixed number of accounts, each
+// proven with BatchSumCircuit, so that setup and proving memory no longer grow
+// with the number of accounts. Every batch publishes a commitment
+// MiMC(total, firstAccountHash, lastAccountHash, blinding) instead of its
+// total. SumNodeCircuit opens the commitments of up to arity children and
+// publishes the same commitment over their sum, and SumRootCircuit publishes
+// the sum in the clear, forming a tree whose proofs are linked by the
+// commitments. The account hash bounds let every node check that its children
+// cover strictly increasing ranges of accounts, so that no account is counted
+// in two batches.
+//
+// The tree is a bundle of linked proofs, not a single proof: a node opens the
+// commitments of its children but does not verify their proofs, so the root
+// proof alone proves nothing about the batches. The verifier checks every
+// proof of the bundle with VerifySumTreeBundle, in time linear in the number
+// of batches. Verifying the children in-circuit instead would need a cycle of
+// curves, RecursiveAggregatedBalanceCircuit only recurses once on a 2-chain.
+
+// BatchSumCircuit proves the committed total of one batch of accounts
+type BatchSumCircuit struct {
+	Balances       []frontend.Variable `gnark:"balances,secret"`
+	AccountHashes  []frontend.Variable `gnark:"account_hashes,secret"` // In strictly increasing order, inactive slots repeating the last one
+	Active         []frontend.Variable `gnark:"active,secret"`
+	TotalSum       frontend.Variable   `gnark:"total_sum,secret"`
+	Blinding       frontend.Variable   `gnark:"blinding,secret"`
+	Commitment     frontend.Variable   `gnark:"commitment,public"`
+	Epoch          frontend.Variable   `gnark:"epoch,public"`
+	SnapshotDigest frontend.Variable   `gnark:"snapshot_digest,public"`
+	BalanceBits    int                 `gnark:"-"` // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+// NewBatchSumCircuit returns the circuit of a batch of batchSize accounts
+func NewBatchSumCircuit(batchSize int) *BatchSumCircuit {
+	return &BatchSumCircuit{
+		Balances:      make([]frontend.Variable, batchSize),
+		AccountHashes: make([]frontend.Variable, batchSize),
+		Active:        make([]frontend.Variable, batchSize),
+	}
+}
+
+func (circuit *BatchSumCircuit) Define(api frontend.API) error {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	if len(circuit.Balances) == 0 {
+		return errors.New("a batch needs at least one slot")
+	}
+	totalSum, _, err := sumActiveBalances(api, circuit.Balances, circuit.AccountHashes, circuit.Active, balanceBitsOrDefault(circuit.BalanceBits))
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(totalSum, circuit.TotalSum)
+
+	// The slots are ordered, so the first and last hashes bound the batch
+	commitment, err := sumTreeCommitment(api, circuit.TotalSum, circuit.AccountHashes[0], circuit.AccountHashes[len(circuit.AccountHashes)-1], circuit.Blinding)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(commitment, circuit.Commitment)
+	return nil
+}
+
+// SumTreeChildren are the commitments of the children of a node of the tree,
+// public so that the verifier checks them against the proofs of the children,
+// along with their openings. Inactive children pad the node to its arity: they
+// have a zero commitment and total, and repeat the last account hash.
+type SumTreeChildren struct {
+	Commitments    []frontend.Variable `gnark:"child_commitments,public"`
+	TotalSums      []frontend.Variable `gnark:"child_total_sums,secret"`
+	FirstHashes    []frontend.Variable `gnark:"child_first_hashes,secret"`
+	LastHashes     []frontend.Variable `gnark:"child_last_hashes,secret"`
+	Blindings      []frontend.Variable `gnark:"child_blindings,secret"`
+	Active         []frontend.Variable `gnark:"child_active,secret"`
+	Epoch          frontend.Variable   `gnark:"epoch,public"`
+	SnapshotDigest frontend.Variable   `gnark:"snapshot_digest,public"`
+}
+
+func newSumTreeChildren(arity int) SumTreeChildren {
+	return SumTreeChildren{
+		Commitments: make([]frontend.Variable, arity),
+		TotalSums:   make([]frontend.Variable, arity),
+		FirstHashes: make([]frontend.Variable, arity),
+		LastHashes:  make([]frontend.Variable, arity),
+		Blindings:   make([]frontend.Variable, arity),
+		Active:      make([]frontend.Variable, arity),
+	}
+}
+
+// assertOpenings opens the commitments of the children and returns the sum of
+// their totals, along with the account hashes bounding all of them
+func (children *SumTreeChildren) assertOpenings(api frontend.API) (totalSum, firstHash, lastHash frontend.Variable, err error) {
+	assertSnapshotBinding(api, children.Epoch, children.SnapshotDigest)
+
+	arity := len(children.Commitments)
+	if arity == 0 {
+		return nil, nil, nil, errors.New("a node needs at least one child")
+	}
+	if len(children.TotalSums) != arity || len(children.FirstHashes) != arity || len(children.LastHashes) != arity || len(children.Blindings) != arity || len(children.Active) != arity {
+		return nil, nil, nil, errors.New("expected one opening and active flag per child commitment")
+	}
+
+	assertActiveFlags(api, children.Active)
+	totalSum = frontend.Variable(0)
+	for i := range children.Commitments {
+		commitment, err := sumTreeCommitment(api, children.TotalSums[i], children.FirstHashes[i], children.LastHashes[i], children.Blindings[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// An active child opens its commitment, an inactive one is empty
+		api.AssertIsEqual(api.Mul(children.Active[i], api.Sub(commitment, children.Commitments[i])), 0)
+		assertInactiveSlotEmpty(api, children.Active[i], children.Commitments[i], 0)
+		assertInactiveSlotEmpty(api, children.Active[i], children.TotalSums[i], 0)
+		totalSum = api.Add(totalSum, children.TotalSums[i])
+	}
+
+	// The account ranges of the children must follow each other: the first
+	// hash of an active child is above the last hash of the previous one, and
+	// the last hash of every child is not below its first hash
+	bounds := make([]frontend.Variable, 0, 2*arity)
+	strict := make([]frontend.Variable, 0, 2*arity)
+	for i := range children.Commitments {
+		bounds = append(bounds, children.FirstHashes[i], children.LastHashes[i])
+		strict = append(strict, children.Active[i], 0)
+	}
+	if err := assertStrictlyIncreasing(api, bounds, strict); err != nil {
+		return nil, nil, nil, err
+	}
+	return totalSum, children.FirstHashes[0], children.LastHashes[arity-1], nil
+}
+
+// SumNodeCircuit aggregates the committed totals of up to arity batches or
+// nodes into a commitment to their sum
+type SumNodeCircuit struct {
+	SumTreeChildren
+	Blinding   frontend.Variable `gnark:"blinding,secret"`
+	Commitment frontend.Variable `gnark:"commitment,public"`
+}
+
+// NewSumNodeCircuit returns the circuit of a node of arity children
+func NewSumNodeCircuit(arity int) *SumNodeCircuit {
+	return &SumNodeCircuit{SumTreeChildren: newSumTreeChildren(arity)}
+}
+
+func (circuit *SumNodeCircuit) Define(api frontend.API) error {
+	totalSum, firstHash, lastHash, err := circuit.assertOpenings(api)
+	if err != nil {
+		return err
+	}
+	commitment, err := sumTreeCommitment(api, totalSum, firstHash, lastHash, circuit.Blinding)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(commitment, circuit.Commitment)
+	return nil
+}
+
+// SumRootCircuit aggregates the committed totals of up to arity batches or
+// nodes into the public total of the snapshot
+type SumRootCircuit struct {
+	SumTreeChildren
+	TotalSum frontend.Variable `gnark:"total_sum,public"`
+}
+
+// NewSumRootCircuit returns the circuit of the root of arity children
+func NewSumRootCircuit(arity int) *SumRootCircuit {
+	return &SumRootCircuit{SumTreeChildren: newSumTreeChildren(arity)}
+}
+
+func (circuit *SumRootCircuit) Define(api frontend.API) error {
+	totalSum, _, _, err := circuit.assertOpenings(api)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(totalSum, circuit.TotalSum)
+	return nil
+}
+
+// sumTreeCommitment computes in-circuit MiMC(total, firstHash, lastHash, blinding)
+func sumTreeCommitment(api frontend.API, totalSum, firstHash, lastHash, blinding frontend.Variable) (frontend.Variable, error) {
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(totalSum, firstHash, lastHash, blinding)
+	return hash.Sum(), nil
+}
+
+// sumTreeOpening is the opening of the commitment published by a batch or a
+// node, which its parent proves knowledge of
+type sumTreeOpening struct {
+	totalSum, firstHash, lastHash, blinding, commitment *big.Int
+}
+
+func newSumTreeOpening(curve ecc.ID, totalSum, firstHash, lastHash *big.Int) (sumTreeOpening, error) {
+	blinding, err := RandomBlindingOnCurve(curve)
+	if err != nil {
+		return sumTreeOpening{}, err
+	}
+	commitment, err := mimcHashOnCurve(curve, totalSum, firstHash, lastHash, blinding)
+	if err != nil {
+		return sumTreeOpening{}, err
+	}
+	return sumTreeOpening{totalSum, firstHash, lastHash, blinding, commitment}, nil
+}
+
+// SumTreeLevel holds the proofs of one level of the tree and the commitments
+// they publish, in order
+type SumTreeLevel struct {
+	Proofs      []Proof
+	Commitments []*big.Int
+}
+
+// SumTreeBundle is the bundle of proofs of the total of a snapshot split into
+// batches: Levels[0] holds the batch proofs, the following levels the node
+// proofs, and Root aggregates the last level. Only the whole bundle proves the
+// total.
+type SumTreeBundle struct {
+	Levels   []SumTreeLevel
+	Root     Proof
+	TotalSum *big.Int
+}
+
+// SumTreeVerifyingKeys verify the proofs of every kind of circuit of the tree
+type SumTreeVerifyingKeys struct {
+	Arity int
+	Batch VerifyingKey
+	Node  VerifyingKey
+	Root  VerifyingKey
+}
+
+// sumTreeCircuit is a compiled circuit of the tree along with its keys
+type sumTreeCircuit struct {
+	provingBackend ProvingBackend
+	cs             constraint.ConstraintSystem
+	pk             ProvingKey
+	vk             VerifyingKey
+}
+
+func setupSumTreeCircuit(provingBackend ProvingBackend, circuit frontend.Circuit) (sumTreeCircuit, error) {
+	cs, err := provingBackend.Compile(circuit)
+	if err != nil {
+		return sumTreeCircuit{}, err
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		return sumTreeCircuit{}, err
+	}
+	return sumTreeCircuit{provingBackend, cs, pk, vk}, nil
+}
+
+func (circuit sumTreeCircuit) prove(assignment frontend.Circuit) (Proof, error) {
+	fw, err := circuit.provingBackend.NewWitness(assignment)
+	if err != nil {
+		return nil, err
+	}
+	return Prove(circuit.cs, circuit.pk, fw)
+}
+
+// SumTreeProver splits snapshots into batches of BatchSize accounts and
+// aggregates them in a tree of the given arity. The three circuits of the
+// tree are compiled and set up once, whatever the number of accounts.
+type SumTreeProver struct {
+	BatchSize int
+	Arity     int
+	curve     ecc.ID
+	batch     sumTreeCircuit
+	node      sumTreeCircuit
+	root      sumTreeCircuit
+}
+
+// NewSumTreeProver compiles and sets up the circuits of the tree with
+// provingBackend
+func NewSumTreeProver(provingBackend ProvingBackend, batchSize, arity int) (*SumTreeProver, error) {
+	if batchSize < 1 || arity < 2 {
+		return nil, fmt.Errorf("invalid batch size %d or arity %d", batchSize, arity)
+	}
+	prover := &SumTreeProver{BatchSize: batchSize, Arity: arity, curve: provingBackend.CurveID()}
+	var err error
+	if prover.batch, err = setupSumTreeCircuit(provingBackend, NewBatchSumCircuit(batchSize)); err != nil {
+		return nil, err
+	}
+	if prover.node, err = setupSumTreeCircuit(provingBackend, NewSumNodeCircuit(arity)); err != nil {
+		return nil, err
+	}
+	if prover.root, err = setupSumTreeCircuit(provingBackend, NewSumRootCircuit(arity)); err != nil {
+		return nil, err
+	}
+	return prover, nil
+}
+
+// VerifyingKeys returns the keys verifying the proofs of the prover
+func (prover *SumTreeProver) VerifyingKeys() SumTreeVerifyingKeys {
+	return SumTreeVerifyingKeys{Arity: prover.Arity, Batch: prover.batch.vk, Node: prover.node.vk, Root: prover.root.vk}
+}
+
+// Prove proves the total of a snapshot with a bundle of proofs. The accounts
+// are sorted by account hash and rejected like in NewSumAggregationAssignment,
+// split into batches, and the batches aggregated level by level until at most
+// arity are left for the root.
+func (prover *SumTreeProver) Prove(accounts []AccountBalance, epoch uint64, snapshotDigest *big.Int) (*SumTreeBundle, error) {
+	if len(accounts) == 0 {
+		return nil, errors.New("no account to prove")
+	}
+	// Order and check all the accounts at once, so that the batches follow
+	// each other
+	sorted, err := NewSumAggregationAssignmentOnCurve(prover.curve, accounts, len(accounts), epoch, snapshotDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &SumTreeBundle{}
+	var openings []sumTreeOpening
+	var level SumTreeLevel
+	for start := 0; start < len(accounts); start += prover.BatchSize {
+		end := min(start+prover.BatchSize, len(accounts))
+		assignment, opening, err := prover.batchAssignment(sorted, start, end, epoch, snapshotDigest)
+		if err != nil {
+			return nil, err
+		}
+		batchProof, err := prover.batch.prove(assignment)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %w", start/prover.BatchSize, err)
+		}
+		level.Proofs = append(level.Proofs, batchProof)
+		level.Commitments = append(level.Commitments, opening.commitment)
+		openings = append(openings, opening)
+	}
+	bundle.Levels = append(bundle.Levels, level)
+
+	for len(openings) > prover.Arity {
+		var parents []sumTreeOpening
+		level = SumTreeLevel{}
+		for start := 0; start < len(openings); start += prover.Arity {
+			children := openings[start:min(start+prover.Arity, len(openings))]
+			totalSum := big.NewInt(0)
+			for _, child := range children {
+				totalSum.Add(totalSum, child.totalSum)
+			}
+			opening, err := newSumTreeOpening(prover.curve, totalSum, children[0].firstHash, children[len(children)-1].lastHash)
+			if err != nil {
+				return nil, err
+			}
+			assignment := &SumNodeCircuit{
+				SumTreeChildren: sumTreeChildrenAssignment(prover.Arity, children, epoch, snapshotDigest),
+				Blinding:        opening.blinding,
+				Commitment:      opening.commitment,
+			}
+			nodeProof, err := prover.node.prove(assignment)
+			if err != nil {
+				return nil, fmt.Errorf("node %d of level %d: %w", start/prover.Arity, len(bundle.Levels), err)
+			}
+			level.Proofs = append(level.Proofs, nodeProof)
+			level.Commitments = append(level.Commitments, opening.commitment)
+			parents = append(parents, opening)
+		}
+		bundle.Levels = append(bundle.Levels, level)
+		openings = parents
+	}
+
+	bundle.TotalSum = big.NewInt(0)
+	for _, child := range openings {
+		bundle.TotalSum.Add(bundle.TotalSum, child.totalSum)
+	}
+	bundle.Root, err = prover.root.prove(&SumRootCircuit{
+		SumTreeChildren: sumTreeChildrenAssignment(prover.Arity, openings, epoch, snapshotDigest),
+		TotalSum:        bundle.TotalSum,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("root: %w", err)
+	}
+	return bundle, nil
+}
+
+// batchAssignment builds the assignment of the batch of the sorted accounts
+// from start to end, its inactive slots repeating the last account hash
+func (prover *SumTreeProver) batchAssignment(sorted *SumAggregationCircuit, start, end int, epoch uint64, snapshotDigest *big.Int) (*BatchSumCircuit, sumTreeOpening, error) {
+	assignment := NewBatchSumCircuit(prover.BatchSize)
+	assignment.Epoch, assignment.SnapshotDigest = epoch, snapshotDigest
+	totalSum := big.NewInt(0)
+	for i := range assignment.Balances {
+		if start+i < end {
+			assignment.Balances[i] = sorted.Balances[start+i]
+			assignment.AccountHashes[i] = sorted.AccountHashes[start+i]
+			assignment.Active[i] = 1
+			totalSum.Add(totalSum, sorted.Balances[start+i].(*big.Int))
+		} else {
+			assignment.Balances[i] = 0
+			assignment.AccountHashes[i] = sorted.AccountHashes[end-1]
+			assignment.Active[i] = 0
+		}
+	}
+	opening, err := newSumTreeOpening(prover.curve, totalSum, sorted.AccountHashes[start].(*big.Int), sorted.AccountHashes[end-1].(*big.Int))
+	if err != nil {
+		return nil, sumTreeOpening{}, err
+	}
+	assignment.TotalSum, assignment.Blinding, assignment.Commitment = totalSum, opening.blinding, opening.commitment
+	return assignment, opening, nil
+}
+
+// sumTreeChildrenAssignment fills the children of a node, padded to the arity
+// with inactive children
+func sumTreeChildrenAssignment(arity int, children []sumTreeOpening, epoch uint64, snapshotDigest *big.Int) SumTreeChildren {
+	assignment := newSumTreeChildren(arity)
+	assignment.Epoch, assignment.SnapshotDigest = epoch, snapshotDigest
+	last := children[len(children)-1].lastHash
+	for i := range assignment.Commitments {
+		if i < len(children) {
+			child := children[i]
+			assignment.Commitments[i], assignment.TotalSums[i] = child.commitment, child.totalSum
+			assignment.FirstHashes[i], assignment.LastHashes[i] = child.firstHash, child.lastHash
+			assignment.Blindings[i], assignment.Active[i] = child.blinding, 1
+		} else {
+			assignment.Commitments[i], assignment.TotalSums[i] = 0, 0
+			assignment.FirstHashes[i], assignment.LastHashes[i] = last, last
+			assignment.Blindings[i], assignment.Active[i] = 0, 0
+		}
+	}
+	return assignment
+}
+
+// VerifySumTreeBundle verifies every proof of the bundle and the commitments
+// linking each level to the next one, for the published total
+func VerifySumTreeBundle(keys SumTreeVerifyingKeys, bundle *SumTreeBundle, totalSum *big.Int, epoch uint64, snapshotDigest *big.Int) error {
+	if len(bundle.Levels) == 0 || len(bundle.Levels[0].Proofs) == 0 {
+		return errors.New("the tree has no batch")
+	}
+
+	for depth, level := range bundle.Levels {
+		if len(level.Proofs) != len(level.Commitments) {
+			return fmt.Errorf("level %d: expected one commitment per proof", depth)
+		}
+		for i, levelProof := range level.Proofs {
+			var public frontend.Circuit
+			vk := keys.Node
+			if depth == 0 {
+				public, vk = &BatchSumCircuit{Commitment: level.Commitments[i], Epoch: epoch, SnapshotDigest: snapshotDigest}, keys.Batch
+			} else {
+				children, err := childrenPublicAssignment(keys.Arity, bundle.Levels[depth-1].Commitments, i, epoch, snapshotDigest)
+				if err != nil {
+					return fmt.Errorf("level %d: %w", depth, err)
+				}
+				public = &SumNodeCircuit{SumTreeChildren: children, Commitment: level.Commitments[i]}
+			}
+			if err := verifySumTreeProof(levelProof, vk, public); err != nil {
+				return fmt.Errorf("proof %d of level %d: %w", i, depth, err)
+			}
+		}
+		if depth > 0 && len(level.Proofs) != (len(bundle.Levels[depth-1].Proofs)+keys.Arity-1)/keys.Arity {
+			return fmt.Errorf("level %d does not aggregate all the proofs of level %d", depth, depth-1)
+		}
+	}
+
+	last := bundle.Levels[len(bundle.Levels)-1].Commitments
+	if len(last) > keys.Arity {
+		return fmt.Errorf("the root cannot aggregate %d proofs", len(last))
+	}
+	children, err := childrenPublicAssignment(keys.Arity, last, 0, epoch, snapshotDigest)
+	if err != nil {
+		return err
+	}
+	if err := verifySumTreeProof(bundle.Root, keys.Root, &SumRootCircuit{SumTreeChildren: children, TotalSum: totalSum}); err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+	return nil
+}
+
+// childrenPublicAssignment returns the public children of the index-th node
+// over the commitments of the level below
+func childrenPublicAssignment(arity int, commitments []*big.Int, index int, epoch uint64, snapshotDigest *big.Int) (SumTreeChildren, error) {
+	start := index * arity
+	if start >= len(commitments) {
+		return SumTreeChildren{}, fmt.Errorf("node %d has no child", index)
+	}
+	children := SumTreeChildren{Commitments: make([]frontend.Variable, arity), Epoch: epoch, SnapshotDigest: snapshotDigest}
+	for i := range children.Commitments {
+		children.Commitments[i] = 0
+		if start+i < len(commitments) {
+			children.Commitments[i] = commitments[start+i]
+		}
+	}
+	return children, nil
+}
+
+func verifySumTreeProof(proof Proof, vk VerifyingKey, public frontend.Circuit) error {
+	curve, err := curveOf(vk)
+	if err != nil {
+		return err
+	}
+	pw, err := frontend.NewWitness(public, curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	return Verify(proof, vk, pw)
+}
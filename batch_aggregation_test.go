@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+)
+
+// swapped returns a copy of values with the elements at i and j swapped
+func swapped[T any](values []T, i, j int) []T {
+	values = append([]T(nil), values...)
+	values[i], values[j] = values[j], values[i]
+	return values
+}
+
+func TestSumTreeBundleProofAndVerification(t *testing.T) {
+
+	// 13 accounts in batches of 4 give 4 batches, aggregated by 2 nodes of
+	// arity 3 and the root, the last batch, node and the root being padded
+	const batchSize, arity = 4, 3
+	prover, err := NewSumTreeProver(testProvingBackend(t), batchSize, arity)
+	if err != nil {
+		t.Fatalf("Failed to set up the circuits of the tree: %v", err)
+	}
+	keys := prover.VerifyingKeys()
+
+	accounts := createAccountBalances(13)
+	totalSum := big.NewInt(0)
+	for _, account := range accounts {
+		totalSum.Add(totalSum, account.Balance)
+	}
+	bundle, err := prover.Prove(accounts, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to prove the tree: %v", err)
+	}
+	if bundle.TotalSum.Cmp(totalSum) != 0 {
+		t.Fatalf("Expected a total of %v, got %v", totalSum, bundle.TotalSum)
+	}
+	if len(bundle.Levels) != 2 || len(bundle.Levels[0].Proofs) != 4 || len(bundle.Levels[1].Proofs) != 2 {
+		t.Fatalf("Expected 4 batches and 2 nodes")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		verify func() error
+		valid  bool
+	}{
+		{"PublishedTotal", func() error {
+			return VerifySumTreeBundle(keys, bundle, totalSum, testEpoch, testSnapshotDigest)
+		}, true},
+		{"WrongTotal", func() error {
+			return VerifySumTreeBundle(keys, bundle, new(big.Int).Add(totalSum, big.NewInt(1)), testEpoch, testSnapshotDigest)
+		}, false},
+		{"AnotherEpoch", func() error {
+			return VerifySumTreeBundle(keys, bundle, totalSum, testEpoch+1, testSnapshotDigest)
+		}, false},
+		{"SwappedBatches", func() error {
+			tampered := *bundle
+			tampered.Levels = []SumTreeLevel{{
+				Proofs:      swapped(bundle.Levels[0].Proofs, 0, 1),
+				Commitments: swapped(bundle.Levels[0].Commitments, 0, 1),
+			}, bundle.Levels[1]}
+			return VerifySumTreeBundle(keys, &tampered, totalSum, testEpoch, testSnapshotDigest)
+		}, false},
+		{"RootAlone", func() error {
+			tampered := *bundle
+			tampered.Levels = nil
+			return VerifySumTreeBundle(keys, &tampered, totalSum, testEpoch, testSnapshotDigest)
+		}, false},
+		{"MissingBatch", func() error {
+			tampered := *bundle
+			tampered.Levels = []SumTreeLevel{{
+				Proofs:      bundle.Levels[0].Proofs[:3],
+				Commitments: bundle.Levels[0].Commitments[:3],
+			}, bundle.Levels[1]}
+			return VerifySumTreeBundle(keys, &tampered, totalSum, testEpoch, testSnapshotDigest)
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.verify()
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the tree to verify: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the tree not to verify")
+			}
+		})
+	}
+
+	t.Run("DuplicateAccountAcrossBatches", func(t *testing.T) {
+		duplicated := append(createAccountBalances(batchSize), AccountBalance{})
+		duplicated[batchSize] = AccountBalance{Address: duplicated[0].Address, Balance: big.NewInt(1)}
+		if _, err := prover.Prove(duplicated, testEpoch, testSnapshotDigest); !errors.Is(err, ErrDuplicateAccount) {
+			t.Fatalf("Expected ErrDuplicateAccount, got %v", err)
+		}
+	})
+}
+
+func TestSumRootRejectsInvalidChildren(t *testing.T) {
+
+	const arity = 3
+	accountHashes := testAccountHashes(4)
+	opening := func(totalSum int64, first, last int) sumTreeOpening {
+		opening, err := newSumTreeOpening(ecc.BLS12_381, big.NewInt(totalSum), variableToBigInt(accountHashes[first]), variableToBigInt(accountHashes[last]))
+		if err != nil {
+			t.Fatalf("Failed to commit to a child: %v", err)
+		}
+		return opening
+	}
+	first, second := opening(10, 0, 1), opening(20, 2, 3)
+
+	testCases := []struct {
+		name     string
+		children []sumTreeOpening
+		modify   func(assignment *SumRootCircuit)
+		valid    bool
+	}{
+		{"OrderedChildren", []sumTreeOpening{first, second}, func(assignment *SumRootCircuit) {}, true},
+		{"UnorderedChildren", []sumTreeOpening{second, first}, func(assignment *SumRootCircuit) {}, false},
+		{"OverlappingChildren", []sumTreeOpening{first, opening(20, 1, 3)}, func(assignment *SumRootCircuit) {}, false},
+		{"ReversedRange", []sumTreeOpening{first, opening(20, 3, 2)}, func(assignment *SumRootCircuit) {}, false},
+		{"WrongOpening", []sumTreeOpening{first, second}, func(assignment *SumRootCircuit) {
+			assignment.TotalSums[0] = 11
+			assignment.TotalSum = 31
+		}, false},
+		{"InactiveChildWithTotal", []sumTreeOpening{first, second}, func(assignment *SumRootCircuit) {
+			assignment.TotalSums[2] = 5
+			assignment.TotalSum = 35
+		}, false},
+		{"InactiveChildWithCommitment", []sumTreeOpening{first, second}, func(assignment *SumRootCircuit) {
+			assignment.Commitments[2] = first.commitment
+		}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := &SumRootCircuit{
+				SumTreeChildren: sumTreeChildrenAssignment(arity, tc.children, testEpoch, testSnapshotDigest),
+				TotalSum:        30,
+			}
+			tc.modify(assignment)
+			err := test.IsSolved(NewSumRootCircuit(arity), assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	fr_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	bw6761 "github.com/consensys/gnark-crypto/ecc/bw6-761"
+	fr_bw6761 "github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	groth16_bls12377 "github.com/consensys/gnark/backend/groth16/bls12-377"
+	groth16_bls12381 "github.com/consensys/gnark/backend/groth16/bls12-381"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	groth16_bw6761 "github.com/consensys/gnark/backend/groth16/bw6-761"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// Groth16 proofs of one verifying key are checked together with a random
+// linear combination ρ of their equations, in a single multi-pairing:
+//
+//	Π e(ρ_i·A_i, B_i) · e(-Σ ρ_i·C_i, δ) · e(-Σ ρ_i·S_i, γ) · e(-Σ ρ_i·α, β) = 1
+//
+// where S_i is the public input term of proof i. Unless every proof holds, the
+// combination fails but with negligible probability. It does not tell which
+// proof failed, so the proofs are then verified one by one.
+
+// ProofVerificationError reports the first proof of a batch that does not
+// verify
+type ProofVerificationError struct {
+	Index int
+	Err   error
+}
+
+func (err *ProofVerificationError) Error() string {
+	return fmt.Sprintf("proof %d: %v", err.Index, err.Err)
+}
+
+func (err *ProofVerificationError) Unwrap() error {
+	return err.Err
+}
+
+// BatchVerify verifies proofs of vk against their public witnesses. Groth16
+// proofs without commitments, such as those of IndividualBalanceCircuit, are
+// checked at once on every curve of SupportedCurves, and other proofs one by
+// one. A failure is reported as a *ProofVerificationError.
+func BatchVerify(vk VerifyingKey, proofs []Proof, publicWitnesses []witness.Witness) error {
+	if len(proofs) != len(publicWitnesses) {
+		return fmt.Errorf("got %d proofs and %d public witnesses", len(proofs), len(publicWitnesses))
+	}
+	if batchVerifyGroth16(vk, proofs, publicWitnesses) {
+		return nil
+	}
+
+	// Find the proof that does not hold
+	for i := range proofs {
+		if err := Verify(proofs[i], vk, publicWitnesses[i]); err != nil {
+			return &ProofVerificationError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// CanBatchVerify reports whether BatchVerify checks the proofs of vk with a
+// single multi-pairing rather than one by one
+func CanBatchVerify(vk VerifyingKey) bool {
+	switch vk := vk.(type) {
+	case *groth16_bn254.VerifyingKey:
+		return len(vk.CommitmentKeys) == 0
+	case *groth16_bls12381.VerifyingKey:
+		return len(vk.CommitmentKeys) == 0
+	case *groth16_bls12377.VerifyingKey:
+		return len(vk.CommitmentKeys) == 0
+	case *groth16_bw6761.VerifyingKey:
+		return len(vk.CommitmentKeys) == 0
+	default:
+		return false
+	}
+}
+
+// groth16Equation is the verification equation of one Groth16 proof
+type groth16Equation[F, G1, G2 any] struct {
+	Ar, Krs   G1
+	Bs        G2
+	Statement []F
+}
+
+// batchVerifyGroth16 checks the random linear combination of the Groth16
+// equations of the proofs. It returns false unless CanBatchVerify(vk), every
+// proof is well formed and the combination holds.
+func batchVerifyGroth16(vk VerifyingKey, proofs []Proof, publicWitnesses []witness.Witness) bool {
+	if !CanBatchVerify(vk) {
+		return false
+	}
+	switch vk := vk.(type) {
+	case *groth16_bn254.VerifyingKey:
+		equations := make([]groth16Equation[fr_bn254.Element, bn254.G1Affine, bn254.G2Affine], len(proofs))
+		for i := range proofs {
+			proof, ok := proofs[i].(*groth16_bn254.Proof)
+			statement, isVector := publicWitnesses[i].Vector().(fr_bn254.Vector)
+			if !ok || !isVector || len(proof.Commitments) > 0 {
+				return false
+			}
+			equations[i] = groth16Equation[fr_bn254.Element, bn254.G1Affine, bn254.G2Affine]{proof.Ar, proof.Krs, proof.Bs, statement}
+		}
+		return batchVerifyPairing(vk.G1.K, vk.G1.Alpha, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta, equations, bn254.PairingCheck)
+	case *groth16_bls12381.VerifyingKey:
+		equations := make([]groth16Equation[fr_bls12381.Element, bls12381.G1Affine, bls12381.G2Affine], len(proofs))
+		for i := range proofs {
+			proof, ok := proofs[i].(*groth16_bls12381.Proof)
+			statement, isVector := publicWitnesses[i].Vector().(fr_bls12381.Vector)
+			if !ok || !isVector || len(proof.Commitments) > 0 {
+				return false
+			}
+			equations[i] = groth16Equation[fr_bls12381.Element, bls12381.G1Affine, bls12381.G2Affine]{proof.Ar, proof.Krs, proof.Bs, statement}
+		}
+		return batchVerifyPairing(vk.G1.K, vk.G1.Alpha, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta, equations, bls12381.PairingCheck)
+	case *groth16_bls12377.VerifyingKey:
+		equations := make([]groth16Equation[fr_bls12377.Element, bls12377.G1Affine, bls12377.G2Affine], len(proofs))
+		for i := range proofs {
+			proof, ok := proofs[i].(*groth16_bls12377.Proof)
+			statement, isVector := publicWitnesses[i].Vector().(fr_bls12377.Vector)
+			if !ok || !isVector || len(proof.Commitments) > 0 {
+				return false
+			}
+			equations[i] = groth16Equation[fr_bls12377.Element, bls12377.G1Affine, bls12377.G2Affine]{proof.Ar, proof.Krs, proof.Bs, statement}
+		}
+		return batchVerifyPairing(vk.G1.K, vk.G1.Alpha, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta, equations, bls12377.PairingCheck)
+	case *groth16_bw6761.VerifyingKey:
+		equations := make([]groth16Equation[fr_bw6761.Element, bw6761.G1Affine, bw6761.G2Affine], len(proofs))
+		for i := range proofs {
+			proof, ok := proofs[i].(*groth16_bw6761.Proof)
+			statement, isVector := publicWitnesses[i].Vector().(fr_bw6761.Vector)
+			if !ok || !isVector || len(proof.Commitments) > 0 {
+				return false
+			}
+			equations[i] = groth16Equation[fr_bw6761.Element, bw6761.G1Affine, bw6761.G2Affine]{proof.Ar, proof.Krs, proof.Bs, statement}
+		}
+		return batchVerifyPairing(vk.G1.K, vk.G1.Alpha, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta, equations, bw6761.PairingCheck)
+	default:
+		return false
+	}
+}
+
+// scalarField is a scalar field element of a gnark-crypto curve
+type scalarField[F any] interface {
+	*F
+	SetRandom() (*F, error)
+	Add(a, b *F) *F
+	Mul(a, b *F) *F
+	BigInt(res *big.Int) *big.Int
+}
+
+// g1Point is a point of the G1 group of a gnark-crypto curve
+type g1Point[G1, F any] interface {
+	*G1
+	ScalarMultiplication(a *G1, s *big.Int) *G1
+	MultiExp(points []G1, scalars []F, config ecc.MultiExpConfig) (*G1, error)
+	Neg(a *G1) *G1
+	IsInSubGroup() bool
+}
+
+// g2Point is a point of the G2 group of a gnark-crypto curve
+type g2Point[G2 any] interface {
+	*G2
+	IsInSubGroup() bool
+}
+
+// batchVerifyPairing checks the random linear combination of the Groth16
+// equations with the key (K, α, β, γ, δ) in a single multi-pairing, on any
+// curve
+func batchVerifyPairing[F, G1, G2 any, PF scalarField[F], PG1 g1Point[G1, F], PG2 g2Point[G2]](k []G1, alpha G1, beta, gamma, delta G2, equations []groth16Equation[F, G1, G2], pairingCheck func([]G1, []G2) (bool, error)) bool {
+	n := len(equations)
+	if n == 0 {
+		return true
+	}
+	rho := make([]F, n)
+	for i := range rho {
+		if _, err := PF(&rho[i]).SetRandom(); err != nil {
+			return false
+		}
+	}
+
+	g1 := make([]G1, 0, n+3)
+	g2 := make([]G2, 0, n+3)
+	cPoints := make([]G1, n)
+	// Σ ρ_i·S_i = (Σ ρ_i)·K_0 + Σ_j (Σ_i ρ_i·x_ij)·K_(j+1)
+	scalars := make([]F, len(k))
+	for i, equation := range equations {
+		if !PG1(&equation.Ar).IsInSubGroup() || !PG2(&equation.Bs).IsInSubGroup() || !PG1(&equation.Krs).IsInSubGroup() {
+			return false
+		}
+		if len(equation.Statement) != len(k)-1 {
+			return false
+		}
+
+		var a G1
+		PG1(&a).ScalarMultiplication(&equation.Ar, PF(&rho[i]).BigInt(new(big.Int)))
+		g1, g2 = append(g1, a), append(g2, equation.Bs)
+		cPoints[i] = equation.Krs
+		PF(&scalars[0]).Add(&scalars[0], &rho[i])
+		for j := range equation.Statement {
+			var term F
+			PF(&term).Mul(&rho[i], &equation.Statement[j])
+			PF(&scalars[j+1]).Add(&scalars[j+1], &term)
+		}
+	}
+
+	var c, publicTerm, alphaTerm G1
+	if _, err := PG1(&c).MultiExp(cPoints, rho, ecc.MultiExpConfig{}); err != nil {
+		return false
+	}
+	if _, err := PG1(&publicTerm).MultiExp(k, scalars, ecc.MultiExpConfig{}); err != nil {
+		return false
+	}
+	PG1(&alphaTerm).ScalarMultiplication(&alpha, PF(&scalars[0]).BigInt(new(big.Int)))
+	PG1(&c).Neg(&c)
+	PG1(&publicTerm).Neg(&publicTerm)
+	PG1(&alphaTerm).Neg(&alphaTerm)
+	g1 = append(g1, c, publicTerm, alphaTerm)
+	g2 = append(g2, delta, gamma, beta)
+
+	ok, err := pairingCheck(g1, g2)
+	return err == nil && ok
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+func TestBatchVerifyReportsTheFailingProof(t *testing.T) {
+
+	unsafeSRS := func(cs constraint.ConstraintSystem) (kzg.SRS, kzg.SRS, error) {
+		return unsafekzg.NewSRS(cs)
+	}
+	const nbProofs = 6
+
+	// Groth16 proofs are batched on every supported curve
+	provingBackends := []ProvingBackend{PlonkBackend(unsafeSRS)}
+	for _, curve := range SupportedCurves {
+		provingBackends = append(provingBackends, Groth16Backend.OnCurve(curve))
+	}
+	for _, provingBackend := range provingBackends {
+		t.Run(provingBackend.String(), func(t *testing.T) {
+			_, fullWitnesses, publicWitnesses, err := createIndividualBalanceWitnessesOnCurve(provingBackend.CurveID(), MiMCScheme)
+			if err != nil {
+				t.Fatalf("Failed to create witnesses: %v", err)
+			}
+			cs, err := provingBackend.Compile(NewIndividualBalanceCircuit(MiMCScheme))
+			if err != nil {
+				t.Fatalf("Failed to compile circuit: %v", err)
+			}
+			pk, vk, err := provingBackend.Setup(cs)
+			if err != nil {
+				t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+			}
+			proofs := make([]Proof, nbProofs+1)
+			for i := range proofs {
+				if proofs[i], err = Prove(cs, pk, (*fullWitnesses)[i]); err != nil {
+					t.Fatalf("Failed to generate proof: %v", err)
+				}
+			}
+			publics := (*publicWitnesses)[:nbProofs]
+
+			if err := BatchVerify(vk, proofs[:nbProofs], publics); err != nil {
+				t.Fatalf("Failed to verify batch: %v", err)
+			}
+			// Groth16 proofs are checked with a single multi-pairing
+			batched := provingBackend.ID == backend.GROTH16
+			if CanBatchVerify(vk) != batched {
+				t.Fatalf("Expected CanBatchVerify to be %v", batched)
+			}
+			if batched && !batchVerifyGroth16(vk, proofs[:nbProofs], publics) {
+				t.Fatal("Expected the proofs to verify in a single multi-pairing")
+			}
+
+			// A proof of another statement in the batch
+			forged := append([]Proof{}, proofs[:nbProofs]...)
+			forged[3] = proofs[nbProofs]
+			swapped := append([]witness.Witness{}, publics...)
+			swapped[2], swapped[4] = swapped[4], swapped[2]
+
+			testCases := []struct {
+				name    string
+				proofs  []Proof
+				publics []witness.Witness
+				index   int
+			}{
+				{"ProofOfAnotherStatement", forged, publics, 3},
+				{"SwappedPublicWitnesses", proofs[:nbProofs], swapped, 2},
+			}
+			for _, tc := range testCases {
+				t.Run(tc.name, func(t *testing.T) {
+					err := BatchVerify(vk, tc.proofs, tc.publics)
+					var verificationErr *ProofVerificationError
+					if !errors.As(err, &verificationErr) {
+						t.Fatalf("Expected a ProofVerificationError, got %v", err)
+					}
+					if verificationErr.Index != tc.index {
+						t.Fatalf("Expected proof %d to fail, got proof %d", tc.index, verificationErr.Index)
+					}
+					if batchVerifyGroth16(vk, tc.proofs, tc.publics) {
+						t.Fatal("Expected the multi-pairing to fail")
+					}
+				})
+			}
+
+			if err := BatchVerify(vk, proofs[:nbProofs], publics[:nbProofs-1]); err == nil {
+				t.Fatal("Expected an error for a public witness missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// CommitmentScheme selects how a balance is committed to. It is chosen when
+// the circuit is built and is not part of the witness.
+type CommitmentScheme int
+
+const (
+	// PedersenScheme commits with C = balance*G + blinding*H + low*J + high*K on
+	// the embedded twisted Edwards curve, low and high being the halves of the
+	// account hash. Commitments are additively homomorphic.
+	PedersenScheme CommitmentScheme = iota
+	// MiMCScheme commits with C = MiMC(balance, blinding, accountHash). It costs
+	// far fewer constraints. The commitment is a single field element, and hash
+	// commitments cannot be aggregated.
+	MiMCScheme
+)
+
+// ErrNotHomomorphic is returned when commitments of a scheme that is not
+// additively homomorphic are to be aggregated
+var ErrNotHomomorphic = errors.New("commitment scheme is not additively homomorphic")
+
+func (scheme CommitmentScheme) String() string {
+	switch scheme {
+	case PedersenScheme:
+		return "Pedersen"
+	case MiMCScheme:
+		return "MiMC"
+	default:
+		return fmt.Sprintf("CommitmentScheme(%d)", int(scheme))
+	}
+}
+
+// size returns the number of field elements of a commitment with the scheme:
+// the two coordinates of a Pedersen commitment, or a single hash. It is zero
+// for an unknown scheme, which commit rejects.
+func (scheme CommitmentScheme) size() int {
+	switch scheme {
+	case PedersenScheme:
+		return 2
+	case MiMCScheme:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// commit computes in-circuit the commitment to a balance with the given
+// scheme, as scheme.size() field elements. The balance is range-checked to
+// balanceBits bits.
+func commit(api frontend.API, scheme CommitmentScheme, balance, blinding, accountHash frontend.Variable, balanceBits int) ([]frontend.Variable, error) {
+	switch scheme {
+	case PedersenScheme:
+		commitment, err := pedersenCommit(api, balance, blinding, accountHash, balanceBits)
+		if err != nil {
+			return nil, err
+		}
+		return []frontend.Variable{commitment.X, commitment.Y}, nil
+	case MiMCScheme:
+		commitment, err := hashCommit(api, balance, blinding, accountHash, balanceBits)
+		if err != nil {
+			return nil, err
+		}
+		return []frontend.Variable{commitment}, nil
+	default:
+		return nil, fmt.Errorf("unknown commitment scheme %v", scheme)
+	}
+}
+
+// assertCommitment recomputes in-circuit the commitment to a balance and
+// asserts it equals the given one
+func assertCommitment(api frontend.API, scheme CommitmentScheme, balance, blinding, accountHash frontend.Variable, balanceBits int, commitment []frontend.Variable) error {
+	computed, err := commit(api, scheme, balance, blinding, accountHash, balanceBits)
+	if err != nil {
+		return err
+	}
+	if len(commitment) != len(computed) {
+		return fmt.Errorf("expected a %v commitment of %d field elements, got %d", scheme, len(computed), len(commitment))
+	}
+	for i := range computed {
+		api.AssertIsEqual(computed[i], commitment[i])
+	}
+	return nil
+}
+
+// hashCommit computes in-circuit C = MiMC(balance, blinding, accountHash).
+// The balance is range-checked to balanceBits bits.
+func hashCommit(api frontend.API, balance, blinding, accountHash frontend.Variable, balanceBits int) (frontend.Variable, error) {
+	if balanceBits <= 0 || balanceBits >= api.Compiler().FieldBitLen() {
+		return nil, fmt.Errorf("invalid balance bit width %d", balanceBits)
+	}
+	api.ToBinary(balance, balanceBits)
+
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(balance, blinding, accountHash)
+	return hash.Sum(), nil
+}
+
+// PrecomputeHashCommitment computes natively the commitment bytes
+// MiMC(balance, blinding, accountHash) that IndividualBalanceCircuit computes
+// in-circuit on BLS12-381 with MiMCScheme. Every input is hashed as a
+// big-endian scalar field element.
+func PrecomputeHashCommitment(balance, blinding, accountHash *big.Int) ([]byte, error) {
+	return PrecomputeHashCommitmentOnCurve(ecc.BLS12_381, balance, blinding, accountHash)
+}
+
+// PrecomputeHashCommitmentOnCurve computes natively the commitment bytes of a
+// circuit compiled on curve, as long as its scalar field elements
+func PrecomputeHashCommitmentOnCurve(curve ecc.ID, balance, blinding, accountHash *big.Int) ([]byte, error) {
+	if err := checkAccountHash(accountHash, curve.ScalarField()); err != nil {
+		return nil, err
+	}
+	commitment, err := mimcHashOnCurve(curve, balance, blinding, accountHash)
+	if err != nil {
+		return nil, err
+	}
+	return commitment.FillBytes(make([]byte, scalarFieldBytes(curve))), nil
+}
+
+// PrecomputeCommitment computes natively the commitment to a balance with the
+// given scheme, in the form assigned to IndividualBalanceCircuit.Commitment
+// on BLS12-381
+func PrecomputeCommitment(scheme CommitmentScheme, balance, blinding, accountHash *big.Int) ([]frontend.Variable, error) {
+	return PrecomputeCommitmentOnCurve(ecc.BLS12_381, scheme, balance, blinding, accountHash)
+}
+
+// PrecomputeCommitmentOnCurve computes natively the commitment to a balance
+// of an IndividualBalanceCircuit compiled on curve
+func PrecomputeCommitmentOnCurve(curve ecc.ID, scheme CommitmentScheme, balance, blinding, accountHash *big.Int) ([]frontend.Variable, error) {
+	switch scheme {
+	case PedersenScheme:
+		commitment, err := PrecomputePedersenCommitmentOnCurve(curve, balance, blinding, accountHash)
+		if err != nil {
+			return nil, err
+		}
+		return []frontend.Variable{commitment.X, commitment.Y}, nil
+	case MiMCScheme:
+		commitment, err := PrecomputeHashCommitmentOnCurve(curve, balance, blinding, accountHash)
+		if err != nil {
+			return nil, err
+		}
+		return []frontend.Variable{new(big.Int).SetBytes(commitment)}, nil
+	default:
+		return nil, fmt.Errorf("unknown commitment scheme %v", scheme)
+	}
+}
+
+// CommitmentPoint returns the curve point of a Pedersen commitment in the
+// form assigned to IndividualBalanceCircuit.Commitment, to be aggregated
+func CommitmentPoint(commitment []frontend.Variable) (twistededwards.Point, error) {
+	if len(commitment) != 2 {
+		return twistededwards.Point{}, fmt.Errorf("%w: expected a Pedersen commitment of 2 field elements, got %d", ErrNotHomomorphic, len(commitment))
+	}
+	return twistededwards.Point{X: commitment[0], Y: commitment[1]}, nil
+}
+
+// EmptyCommitment returns the neutral element of the sum of commitments with
+// the given scheme, the commitment held by the inactive slots of a padded
+// AggregatedBalanceCircuit
+func EmptyCommitment(scheme CommitmentScheme) (twistededwards.Point, error) {
+	if err := checkHomomorphic(scheme); err != nil {
+		return twistededwards.Point{}, err
+	}
+	return twistededwards.Point{X: 0, Y: 1}, nil
+}
+
+// checkHomomorphic returns ErrNotHomomorphic unless commitments of the scheme
+// can be summed into a commitment to the total balance. Summing hash
+// commitments as field elements would bind to nothing.
+func checkHomomorphic(scheme CommitmentScheme) error {
+	switch scheme {
+	case PedersenScheme:
+		return nil
+	case MiMCScheme:
+		return fmt.Errorf("%w: %v", ErrNotHomomorphic, scheme)
+	default:
+		return fmt.Errorf("unknown commitment scheme %v", scheme)
+	}
+}
+
+// aggregateCommitments sums commitments in-circuit. Pedersen commitments are
+// added on the curve, so that the sum commits to the total balance. Other
+// schemes cannot be aggregated.
+func aggregateCommitments(api frontend.API, scheme CommitmentScheme, commitments []twistededwards.Point) (twistededwards.Point, error) {
+	aggregateSum, err := EmptyCommitment(scheme)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	edwardsID, err := circuitEdwardsCurve(api)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	curve, err := twistededwards.NewEdCurve(api, edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	for _, commitment := range commitments {
+		aggregateSum = curve.Add(aggregateSum, commitment)
+	}
+	return aggregateSum, nil
+}
+
+// AggregateCommitments natively sums commitments the way
+// AggregatedBalanceCircuit does on BLS12-381 for the given scheme
+func AggregateCommitments(scheme CommitmentScheme, commitments []twistededwards.Point) (twistededwards.Point, error) {
+	return AggregateCommitmentsOnCurve(ecc.BLS12_381, scheme, commitments)
+}
+
+// AggregateCommitmentsOnCurve natively sums the commitments of circuits
+// compiled on curve
+func AggregateCommitmentsOnCurve(curve ecc.ID, scheme CommitmentScheme, commitments []twistededwards.Point) (twistededwards.Point, error) {
+	if err := checkHomomorphic(scheme); err != nil {
+		return twistededwards.Point{}, err
+	}
+	return AggregatePedersenCommitmentsOnCurve(curve, commitments)
+}
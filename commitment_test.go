@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
+)
+
+func TestCommitmentSchemesMatchCircuit(t *testing.T) {
+
+	for _, scheme := range []CommitmentScheme{PedersenScheme, MiMCScheme} {
+		t.Run(scheme.String(), func(t *testing.T) {
+
+			balance := big.NewInt(int64(rand.Int64()))
+			blinding, err := RandomBlinding()
+			if err != nil {
+				t.Fatalf("Failed to draw blinding: %v", err)
+			}
+			accountHash := big.NewInt(int64(rand.Int64()))
+
+			commitment, err := PrecomputeCommitment(scheme, balance, blinding, accountHash)
+			if err != nil {
+				t.Fatalf("Failed to compute commitment: %v", err)
+			}
+			otherCommitment, err := PrecomputeCommitment(scheme, balance, new(big.Int).Add(blinding, big.NewInt(1)), accountHash)
+			if err != nil {
+				t.Fatalf("Failed to compute commitment: %v", err)
+			}
+
+			testCases := []struct {
+				name       string
+				commitment []frontend.Variable
+				valid      bool
+			}{
+				{"NativeCommitment", commitment, true},
+				{"CommitmentWithAnotherBlinding", otherCommitment, false},
+			}
+
+			for _, tc := range testCases {
+				t.Run(tc.name, func(t *testing.T) {
+					assignment := IndividualBalanceCircuit{
+						Balance:        balance,
+						Blinding:       blinding,
+						AccountHash:    accountHash,
+						Commitment:     tc.commitment,
+						Epoch:          testEpoch,
+						SnapshotDigest: testSnapshotDigest,
+					}
+					err := test.IsSolved(NewIndividualBalanceCircuit(scheme), &assignment, ecc.BLS12_381.ScalarField())
+					if tc.valid && err != nil {
+						t.Fatalf("Expected the circuit to be solved: %v", err)
+					}
+					if !tc.valid && err == nil {
+						t.Fatal("Expected the circuit not to be solved")
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestHashCommitmentBytes(t *testing.T) {
+
+	balance := big.NewInt(1_000)
+	blinding := big.NewInt(42)
+	accountHash := AccountHash("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	commitment, err := PrecomputeHashCommitment(balance, blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	if len(commitment) != 32 {
+		t.Fatalf("Expected a 32-byte commitment, got %d bytes", len(commitment))
+	}
+
+	// The witness value is the commitment bytes read as a big-endian integer
+	assigned, err := PrecomputeCommitment(MiMCScheme, balance, blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	if len(assigned) != 1 || variableToBigInt(assigned[0]).Cmp(new(big.Int).SetBytes(commitment)) != 0 {
+		t.Fatal("The hash commitment is not the single field element of the commitment bytes")
+	}
+}
+
+func TestHashCommitmentIsCheaperThanPedersen(t *testing.T) {
+
+	nbConstraints := make(map[CommitmentScheme]int)
+	for _, scheme := range []CommitmentScheme{PedersenScheme, MiMCScheme} {
+		cs, err := frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, NewIndividualBalanceCircuit(scheme))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		nbConstraints[scheme] = cs.GetNbConstraints()
+		t.Logf("%v commitment: %d constraints", scheme, nbConstraints[scheme])
+	}
+
+	if nbConstraints[MiMCScheme] >= nbConstraints[PedersenScheme] {
+		t.Fatal("Expected the hash commitment to cost fewer constraints than the Pedersen commitment")
+	}
+}
+
+func TestHashCommitmentsCannotBeAggregated(t *testing.T) {
+
+	commitment, err := PrecomputeCommitment(MiMCScheme, big.NewInt(1_000), big.NewInt(42), big.NewInt(7))
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	if _, err := CommitmentPoint(commitment); !errors.Is(err, ErrNotHomomorphic) {
+		t.Fatalf("Expected ErrNotHomomorphic for the point of a hash commitment, got %v", err)
+	}
+
+	commitments := []twistededwards.Point{{X: commitment[0], Y: 0}}
+	testCases := []struct {
+		name      string
+		aggregate func() error
+	}{
+		{"EmptyCommitment", func() error {
+			_, err := EmptyCommitment(MiMCScheme)
+			return err
+		}},
+		{"AggregateCommitments", func() error {
+			_, err := AggregateCommitments(MiMCScheme, commitments)
+			return err
+		}},
+		{"PadCommitments", func() error {
+			_, _, err := PadCommitments(MiMCScheme, commitments, 2)
+			return err
+		}},
+		{"AggregatedBalanceCircuit", func() error {
+			circuit := AggregatedBalanceCircuit{
+				Commitments: make([]twistededwards.Point, 2),
+				Active:      make([]frontend.Variable, 2),
+				Scheme:      MiMCScheme,
+			}
+			_, err := frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, &circuit)
+			return err
+		}},
+		{"RecursiveAggregatedBalanceCircuit", func() error {
+			_, err := NewRecursiveAggregatedBalanceCircuit(nil, nil, 2, MiMCScheme)
+			return err
+		}},
+		{"RecursiveTotalCommitment", func() error {
+			_, err := NewRecursiveAggregatedBalanceAssignment(nil, nil, testEpoch, testSnapshotDigest, MiMCScheme)
+			return err
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.aggregate(); !errors.Is(err, ErrNotHomomorphic) {
+				t.Fatalf("Expected ErrNotHomomorphic, got %v", err)
+			}
+		})
+	}
+}
@@ -2,3 +2,33 @@ package main
 
 const DEBUG = false
 const NB_ACCOUNTS = 10_000
+
+// NB_COMMITTED_ACCOUNTS is the number of accounts proven one by one with
+// IndividualBalanceCircuit. Each proof costs up to a few thousand constraints
+// (three scalar multiplications on the embedded curve with PedersenScheme), so
+// it is kept well below NB_ACCOUNTS, as is the size of the aggregate circuits
+// that recompute every commitment.
+const NB_COMMITTED_ACCOUNTS = 100
+
+// BALANCE_BITS is the default bit width every balance is range-checked to
+const BALANCE_BITS = 64
+
+// NB_RECURSIVE_PROOFS is the number of IndividualBalanceCircuit proofs
+// verified by one RecursiveAggregatedBalanceCircuit. Each one costs about 20k
+// constraints on BW6-761, whose setup is slow, so batches are kept small.
+const NB_RECURSIVE_PROOFS = 2
+
+// PRICE_DECIMALS is the default number of decimals of fixed-point prices
+const PRICE_DECIMALS = 8
+
+// PRICE_BITS is the default bit width every fixed-point price is range-checked to
+const PRICE_BITS = 64
+
+// NB_BATCH_ACCOUNTS is the number of accounts of a BatchSumCircuit. Larger
+// snapshots are split into batches, so that the cost of a setup and of a proof
+// no longer depends on the number of accounts.
+const NB_BATCH_ACCOUNTS = 1_024
+
+// SUM_AGGREGATION_ARITY is the number of batch or node proofs aggregated by
+// every node of the tree of sub-aggregations
+const SUM_AGGREGATION_ARITY = 4
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+func init() {
+	solver.RegisterHint(splitAccountHashHint)
+}
+
+// ACCOUNT_HASH_LOW_BITS is the bit width of the low limb of an account hash
+// when it is ordered in-circuit. The high limb holds the remaining bits of
+// the scalar field.
+const ACCOUNT_HASH_LOW_BITS = 128
+
+// ErrDuplicateAccount is returned when the same account appears twice in the
+// input data
+var ErrDuplicateAccount = errors.New("duplicate account")
+
+// assertStrictlyIncreasing asserts that the account hashes of the active slots,
+// read as integers in [0, r), are in strictly increasing order, which proves
+// that no account is counted twice. The hashes of inactive slots only must not
+// decrease. Field elements cannot be compared directly, so every hash is split
+// into a range-checked low limb and a high limb, and the high limbs must be
+// strictly increasing. This is slightly stronger than ordering the hashes: two
+// distinct accounts whose hashes share their high bits, which happens with
+// probability about 2^-127, cannot be aggregated together.
+func assertStrictlyIncreasing(api frontend.API, accountHashes, active []frontend.Variable) error {
+	if len(accountHashes) == 0 {
+		return nil
+	}
+	rangeChecker := rangecheck.New(api)
+	highBits := api.Compiler().FieldBitLen() - ACCOUNT_HASH_LOW_BITS
+
+	highLimbs := make([]frontend.Variable, len(accountHashes))
+	lowLimbs := make([]frontend.Variable, len(accountHashes))
+	for i, accountHash := range accountHashes {
+		outputs, err := api.Compiler().NewHint(splitAccountHashHint, 2, accountHash)
+		if err != nil {
+			return err
+		}
+		highLimbs[i], lowLimbs[i] = outputs[0], outputs[1]
+		rangeChecker.Check(lowLimbs[i], ACCOUNT_HASH_LOW_BITS)
+		api.AssertIsEqual(api.Add(api.Mul(highLimbs[i], new(big.Int).Lsh(big.NewInt(1), ACCOUNT_HASH_LOW_BITS)), lowLimbs[i]), accountHash)
+
+		// Every step is an integer below 2^highBits, positive for an active
+		// slot, and there are far too few of them for the high limbs to wrap
+		// around the field
+		if i == 0 {
+			rangeChecker.Check(highLimbs[i], highBits)
+		} else {
+			rangeChecker.Check(api.Sub(highLimbs[i], highLimbs[i-1], active[i]), highBits)
+		}
+	}
+
+	// The last hash must be the canonical representative, below r, so that no
+	// hash can be given twice as h and h + r. The others are not above it.
+	last := len(accountHashes) - 1
+	maxLimbs := splitAccountHash(new(big.Int).Sub(api.Compiler().Field(), big.NewInt(1)))
+	highDifference := api.Sub(maxLimbs[0], highLimbs[last])
+	rangeChecker.Check(highDifference, highBits)
+	rangeChecker.Check(api.Mul(api.IsZero(highDifference), api.Sub(maxLimbs[1], lowLimbs[last])), ACCOUNT_HASH_LOW_BITS)
+	return nil
+}
+
+// checkAccountOrder rejects two accounts, consecutive once sorted by account
+// hash, that assertStrictlyIncreasing cannot order: the same account listed
+// twice, reported with ErrDuplicateAccount, or hashes sharing their high limb
+func checkAccountOrder(previousAddress string, previousHash *big.Int, address string, accountHash *big.Int) error {
+	if accountHash.Cmp(previousHash) == 0 {
+		return fmt.Errorf("%w: %s and %s", ErrDuplicateAccount, previousAddress, address)
+	}
+	if splitAccountHash(accountHash)[0].Cmp(splitAccountHash(previousHash)[0]) == 0 {
+		return fmt.Errorf("the hashes of accounts %s and %s share their high limb and cannot be ordered in-circuit", previousAddress, address)
+	}
+	return nil
+}
+
+// splitAccountHash returns the high and low limbs of an account hash
+func splitAccountHash(accountHash *big.Int) [2]*big.Int {
+	high, low := new(big.Int), new(big.Int)
+	high.DivMod(accountHash, new(big.Int).Lsh(big.NewInt(1), ACCOUNT_HASH_LOW_BITS), low)
+	return [2]*big.Int{high, low}
+}
+
+// splitAccountHashHint computes the high and low limbs of inputs[0]
+func splitAccountHashHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	if len(inputs) != 1 || len(outputs) != 2 {
+		return errors.New("expected one account hash and two limbs")
+	}
+	limbs := splitAccountHash(inputs[0])
+	outputs[0].Set(limbs[0])
+	outputs[1].Set(limbs[1])
+	return nil
+}
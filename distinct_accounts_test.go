@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// testAccountHashes returns n ordered account hashes for hand-written assignments
+func testAccountHashes(n int) []frontend.Variable {
+	accountHashes := make([]frontend.Variable, n)
+	for i := range accountHashes {
+		accountHashes[i] = new(big.Int).Lsh(big.NewInt(int64(i+1)), ACCOUNT_HASH_LOW_BITS)
+	}
+	return accountHashes
+}
+
+func TestSumAggregationAssignmentSortsAccounts(t *testing.T) {
+
+	accounts := make([]AccountBalance, 8)
+	for i := range accounts {
+		accounts[i] = AccountBalance{Address: randomEthereumAddress(), Balance: big.NewInt(int64(i + 1))}
+	}
+	assignment, err := NewSumAggregationAssignment(accounts, len(accounts), testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	for i := 1; i < len(assignment.AccountHashes); i++ {
+		if variableToBigInt(assignment.AccountHashes[i-1]).Cmp(variableToBigInt(assignment.AccountHashes[i])) >= 0 {
+			t.Fatalf("Expected the account hashes to be strictly increasing at index %d", i)
+		}
+	}
+	// Every balance must follow its account
+	for _, account := range accounts {
+		accountHash := AccountHash(account.Address)
+		found := false
+		for i, sortedHash := range assignment.AccountHashes {
+			if variableToBigInt(sortedHash).Cmp(accountHash) == 0 {
+				found = variableToBigInt(assignment.Balances[i]).Cmp(account.Balance) == 0
+			}
+		}
+		if !found {
+			t.Fatalf("Expected account %s to keep its balance", account.Address)
+		}
+	}
+
+	if err := test.IsSolved(NewSumAggregationCircuit(len(accounts)), assignment, ecc.BLS12_381.ScalarField()); err != nil {
+		t.Fatalf("Expected the circuit to be solved: %v", err)
+	}
+}
+
+func TestSumAggregationAssignmentRejectsDuplicateAccounts(t *testing.T) {
+
+	address := randomEthereumAddress()
+	duplicate := "0x" + strings.ToUpper(strings.TrimPrefix(address, "0x"))
+	accounts := []AccountBalance{
+		{Address: address, Balance: big.NewInt(10)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(20)},
+		{Address: duplicate, Balance: big.NewInt(30)},
+	}
+
+	_, err := NewSumAggregationAssignment(accounts, len(accounts), testEpoch, testSnapshotDigest)
+	if !errors.Is(err, ErrDuplicateAccount) {
+		t.Fatalf("Expected ErrDuplicateAccount, got %v", err)
+	}
+	if !strings.Contains(err.Error(), address) || !strings.Contains(err.Error(), duplicate) {
+		t.Fatalf("Expected the error to name both addresses, got %v", err)
+	}
+}
+
+func TestSumAggregationAssignmentRejectsMalformedAddresses(t *testing.T) {
+
+	for _, address := range []string{"0x1234", "0xnot-an-address", randomEthereumAddress() + "00"} {
+		accounts := []AccountBalance{
+			{Address: randomEthereumAddress(), Balance: big.NewInt(10)},
+			{Address: address, Balance: big.NewInt(20)},
+		}
+		_, err := NewSumAggregationAssignment(accounts, len(accounts), testEpoch, testSnapshotDigest)
+		if err == nil {
+			t.Fatalf("Expected address %s to be rejected", address)
+		}
+		if !strings.Contains(err.Error(), address) {
+			t.Fatalf("Expected the error to name address %s, got %v", address, err)
+		}
+	}
+}
+
+func TestSumAggregationRejectsUnorderedAccounts(t *testing.T) {
+
+	field := ecc.BLS12_381.ScalarField()
+	limb := new(big.Int).Lsh(big.NewInt(1), ACCOUNT_HASH_LOW_BITS)
+	fieldMinus := func(x int64) *big.Int {
+		return new(big.Int).Sub(field, big.NewInt(x))
+	}
+	highLimb := func(x int64) *big.Int {
+		return new(big.Int).Mul(limb, big.NewInt(x))
+	}
+
+	testCases := []struct {
+		name          string
+		accountHashes []frontend.Variable
+		valid         bool
+	}{
+		{"StrictlyIncreasing", []frontend.Variable{highLimb(1), highLimb(2), highLimb(5)}, true},
+		{"DuplicateAccount", []frontend.Variable{highLimb(1), highLimb(2), highLimb(2)}, false},
+		{"Decreasing", []frontend.Variable{highLimb(1), highLimb(5), highLimb(2)}, false},
+		{"HighLimbIncreases", []frontend.Variable{0, new(big.Int).Sub(highLimb(2), big.NewInt(1)), highLimb(2)}, true},
+		{"HighLimbDecreases", []frontend.Variable{0, highLimb(2), new(big.Int).Sub(highLimb(2), big.NewInt(1))}, false},
+		{"SharedHighLimb", []frontend.Variable{0, new(big.Int).Add(limb, big.NewInt(5)), new(big.Int).Add(limb, big.NewInt(6))}, false},
+		{"LargestHash", []frontend.Variable{highLimb(1), highLimb(2), fieldMinus(1)}, true},
+		{"WrapAroundModulus", []frontend.Variable{highLimb(1), fieldMinus(1), 0}, false},
+	}
+
+	circuit := NewSumAggregationCircuit(3)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := SumAggregationCircuit{
+				Balances:       []frontend.Variable{10, 20, 5},
+				AccountHashes:  tc.accountHashes,
+				Active:         activeSlots(3, 3),
+				TotalSum:       35,
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+			err := test.IsSolved(circuit, &assignment, field)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/consensys/gnark/frontend"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// BalanceUpdateKind tells how an account changed between two snapshots
+type BalanceUpdateKind int
+
+const (
+	InsertAccount BalanceUpdateKind = iota // The account appeared
+	ChangeBalance                          // The balance of the account changed
+	DeleteAccount                          // The account disappeared
+)
+
+func (kind BalanceUpdateKind) String() string {
+	switch kind {
+	case InsertAccount:
+		return "Insert"
+	case ChangeBalance:
+		return "Change"
+	case DeleteAccount:
+		return "Delete"
+	default:
+		return fmt.Sprintf("BalanceUpdateKind(%d)", int(kind))
+	}
+}
+
+// BalanceUpdate is the change of one account between two snapshots
+type BalanceUpdate struct {
+	Kind    BalanceUpdateKind
+	Address string
+	Balance *big.Int // New balance, nil for a deletion
+}
+
+// DiffSnapshots returns the updates turning the previous snapshot into the
+// next one, ordered by address. Unchanged accounts are left out.
+func DiffSnapshots(previous, next []AccountBalance) ([]BalanceUpdate, error) {
+	previousBalances, err := balancesByAddress(previous)
+	if err != nil {
+		return nil, err
+	}
+	nextBalances, err := balancesByAddress(next)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []BalanceUpdate
+	for address, balance := range nextBalances {
+		previousBalance, found := previousBalances[address]
+		switch {
+		case !found:
+			updates = append(updates, BalanceUpdate{Kind: InsertAccount, Address: address, Balance: balance})
+		case previousBalance.Cmp(balance) != 0:
+			updates = append(updates, BalanceUpdate{Kind: ChangeBalance, Address: address, Balance: balance})
+		}
+	}
+	for address := range previousBalances {
+		if _, found := nextBalances[address]; !found {
+			updates = append(updates, BalanceUpdate{Kind: DeleteAccount, Address: address})
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Address < updates[j].Address })
+	return updates, nil
+}
+
+// balancesByAddress indexes a snapshot by canonical address. A malformed
+// address is rejected with an error naming it.
+func balancesByAddress(accounts []AccountBalance) (map[string]*big.Int, error) {
+	balances := make(map[string]*big.Int, len(accounts))
+	for _, account := range accounts {
+		address, err := canonicalAddress(account.Address)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := balances[address]; found {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateAccount, account.Address)
+		}
+		if account.Balance == nil || account.Balance.Sign() < 0 || account.Balance.BitLen() > BALANCE_BITS {
+			return nil, fmt.Errorf("balance of account %s does not fit in %d bits", account.Address, BALANCE_BITS)
+		}
+		balances[address] = account.Balance
+	}
+	return balances, nil
+}
+
+// LeafUpdate replaces one leaf of the tree. Inserting an account replaces an
+// empty leaf, deleting it puts an empty leaf back, and an update whose old and
+// new leaves are equal pads a batch without changing the tree.
+type LeafUpdate struct {
+	Index          frontend.Variable   `gnark:"index"`
+	OldAccountHash frontend.Variable   `gnark:"old_account_hash"`
+	OldSalt        frontend.Variable   `gnark:"old_salt"`
+	OldBalance     frontend.Variable   `gnark:"old_balance"`
+	NewAccountHash frontend.Variable   `gnark:"new_account_hash"`
+	NewSalt        frontend.Variable   `gnark:"new_salt"`
+	NewBalance     frontend.Variable   `gnark:"new_balance"`
+	SiblingHashes  []frontend.Variable `gnark:"sibling_hashes"` // Siblings on the path from the leaf up, before the update
+	SiblingSums    []frontend.Variable `gnark:"sibling_sums"`
+}
+
+// EpochTransitionCircuit proves that applying a batch of leaf updates to the
+// Merkle sum tree of the previous epoch gives the tree of the new epoch. The
+// updates are applied one after the other, each against the root left by the
+// previous one, so only the updated paths are hashed rather than the whole tree.
+type EpochTransitionCircuit struct {
+	Updates          []LeafUpdate      `gnark:"updates,secret"`
+	PreviousRootHash frontend.Variable `gnark:"previous_root_hash,public"`
+	PreviousTotalSum frontend.Variable `gnark:"previous_total_sum,public"`
+	NewRootHash      frontend.Variable `gnark:"new_root_hash,public"`
+	NewTotalSum      frontend.Variable `gnark:"new_total_sum,public"`
+	BalanceBits      int               `gnark:"-"` // Bit width of every new balance (BALANCE_BITS if unset)
+}
+
+// NewEpochTransitionCircuit returns the circuit applying batchSize updates to a
+// tree of the given depth
+func NewEpochTransitionCircuit(depth, batchSize int) *EpochTransitionCircuit {
+	circuit := &EpochTransitionCircuit{Updates: make([]LeafUpdate, batchSize)}
+	for i := range circuit.Updates {
+		circuit.Updates[i].SiblingHashes = make([]frontend.Variable, depth)
+		circuit.Updates[i].SiblingSums = make([]frontend.Variable, depth)
+	}
+	return circuit
+}
+
+func (circuit *EpochTransitionCircuit) Define(api frontend.API) error {
+	if len(circuit.Updates) == 0 {
+		return errors.New("expected at least one update")
+	}
+	depth := len(circuit.Updates[0].SiblingHashes)
+	newBalances := make([]frontend.Variable, len(circuit.Updates))
+	for i, update := range circuit.Updates {
+		if len(update.SiblingHashes) != depth || len(update.SiblingSums) != depth {
+			return errors.New("expected one sibling hash and sum per level in every update")
+		}
+		newBalances[i] = update.NewBalance
+	}
+
+	// The siblings are authenticated by the previous root, so only the new
+	// balances need a range check to keep the sums from wrapping around
+	if err := assertBalancesInRange(api, newBalances, balanceBitsOrDefault(circuit.BalanceBits)); err != nil {
+		return err
+	}
+
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	hashNode := func(data ...frontend.Variable) frontend.Variable {
+		hash.Reset()
+		hash.Write(data...)
+		return hash.Sum()
+	}
+	// pathRoot recomputes the root above a leaf node, the bits of the index
+	// telling on which side of its sibling every node lies
+	pathRoot := func(update LeafUpdate, path []frontend.Variable, nodeHash, nodeSum frontend.Variable) (frontend.Variable, frontend.Variable) {
+		for level, isRight := range path {
+			siblingHash, siblingSum := update.SiblingHashes[level], update.SiblingSums[level]
+			leftHash, rightHash := api.Select(isRight, siblingHash, nodeHash), api.Select(isRight, nodeHash, siblingHash)
+			leftSum, rightSum := api.Select(isRight, siblingSum, nodeSum), api.Select(isRight, nodeSum, siblingSum)
+			nodeHash = hashNode(leftHash, leftSum, rightHash, rightSum)
+			nodeSum = api.Add(nodeSum, siblingSum)
+		}
+		return nodeHash, nodeSum
+	}
+
+	rootHash, totalSum := circuit.PreviousRootHash, circuit.PreviousTotalSum
+	for _, update := range circuit.Updates {
+		path := api.ToBinary(update.Index, depth)
+
+		// The old leaf must be in the current tree
+		oldHash, oldSum := pathRoot(update, path, hashNode(update.OldAccountHash, update.OldSalt), update.OldBalance)
+		api.AssertIsEqual(oldHash, rootHash)
+		api.AssertIsEqual(oldSum, totalSum)
+
+		// The same siblings with the new leaf give the next root
+		rootHash, totalSum = pathRoot(update, path, hashNode(update.NewAccountHash, update.NewSalt), update.NewBalance)
+	}
+
+	api.AssertIsEqual(rootHash, circuit.NewRootHash)
+	api.AssertIsEqual(totalSum, circuit.NewTotalSum)
+	return nil
+}
+
+// ApplyUpdates applies the updates to a copy of the tree and returns the new
+// tree along with the EpochTransitionCircuit assignment of batchSize updates
+// proving the transition. Deletions are applied first so that inserted
+// accounts can take the leaves they free, with a fresh salt. The batch is
+// padded with updates leaving the first leaf as is.
+func (tree *MerkleSumTree) ApplyUpdates(updates []BalanceUpdate, batchSize int) (*MerkleSumTree, *EpochTransitionCircuit, error) {
+	if len(updates) > batchSize {
+		return nil, nil, fmt.Errorf("got %d updates for a batch of %d", len(updates), batchSize)
+	}
+	updates = append([]BalanceUpdate{}, updates...)
+	sort.SliceStable(updates, func(i, j int) bool { return updates[i].Kind > updates[j].Kind })
+
+	next := tree.clone()
+	assignment := NewEpochTransitionCircuit(tree.Depth(), batchSize)
+	assignment.PreviousRootHash, assignment.PreviousTotalSum = tree.Root().Hash, tree.Root().Sum
+
+	for i := range assignment.Updates {
+		index, leaf := 0, next.Leaves[0]
+		if i < len(updates) {
+			var err error
+			if index, leaf, err = next.updatedLeaf(updates[i]); err != nil {
+				return nil, nil, err
+			}
+		}
+		update, err := next.setLeaf(index, leaf)
+		if err != nil {
+			return nil, nil, err
+		}
+		assignment.Updates[i] = update
+	}
+
+	assignment.NewRootHash, assignment.NewTotalSum = next.Root().Hash, next.Root().Sum
+	return next, assignment, nil
+}
+
+// updatedLeaf returns the index and new content of the leaf an update changes
+func (tree *MerkleSumTree) updatedLeaf(update BalanceUpdate) (int, MerkleSumLeaf, error) {
+	accountHash, err := accountHashOfAddressOnCurve(tree.Curve, update.Address)
+	if err != nil {
+		return 0, MerkleSumLeaf{}, err
+	}
+	index := tree.indexOf(accountHash)
+
+	switch update.Kind {
+	case InsertAccount:
+		if index >= 0 {
+			return 0, MerkleSumLeaf{}, fmt.Errorf("%w: %s", ErrDuplicateAccount, update.Address)
+		}
+		for i, leaf := range tree.Leaves {
+			if leaf.IsEmpty() {
+				leaf, err := NewMerkleSumLeafOnCurve(tree.Curve, update.Address, update.Balance)
+				return i, leaf, err
+			}
+		}
+		return 0, MerkleSumLeaf{}, fmt.Errorf("no empty leaf left to insert account %s", update.Address)
+	case ChangeBalance:
+		if index < 0 {
+			return 0, MerkleSumLeaf{}, fmt.Errorf("account %s is not in the tree", update.Address)
+		}
+		leaf := tree.Leaves[index]
+		leaf.Balance = update.Balance
+		return index, leaf, nil
+	case DeleteAccount:
+		if index < 0 {
+			return 0, MerkleSumLeaf{}, fmt.Errorf("account %s is not in the tree", update.Address)
+		}
+		return index, emptyMerkleSumLeaf(), nil
+	default:
+		return 0, MerkleSumLeaf{}, fmt.Errorf("unknown update %v", update.Kind)
+	}
+}
+
+// indexOf returns the index of the leaf of an account hash, -1 if there is none
+func (tree *MerkleSumTree) indexOf(accountHash *big.Int) int {
+	for i, leaf := range tree.Leaves {
+		if !leaf.IsEmpty() && leaf.AccountHash.Cmp(accountHash) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// setLeaf replaces the leaf at index and recomputes the nodes on its path. It
+// returns the LeafUpdate assignment of the change.
+func (tree *MerkleSumTree) setLeaf(index int, leaf MerkleSumLeaf) (LeafUpdate, error) {
+	if leaf.Balance == nil || leaf.Balance.Sign() < 0 || leaf.Balance.BitLen() > BALANCE_BITS {
+		return LeafUpdate{}, fmt.Errorf("balance of leaf %d does not fit in %d bits", index, BALANCE_BITS)
+	}
+	old := tree.Leaves[index]
+	update := LeafUpdate{
+		Index:          index,
+		OldAccountHash: old.AccountHash,
+		OldSalt:        old.Salt,
+		OldBalance:     old.Balance,
+		NewAccountHash: leaf.AccountHash,
+		NewSalt:        leaf.Salt,
+		NewBalance:     leaf.Balance,
+		SiblingHashes:  make([]frontend.Variable, tree.Depth()),
+		SiblingSums:    make([]frontend.Variable, tree.Depth()),
+	}
+
+	node, err := leaf.NodeOnCurve(tree.Curve)
+	if err != nil {
+		return LeafUpdate{}, err
+	}
+	tree.Leaves[index] = leaf
+	tree.Levels[0][index] = node
+	position := index
+	for level := 0; level < tree.Depth(); level++ {
+		sibling := tree.Levels[level][position^1]
+		update.SiblingHashes[level], update.SiblingSums[level] = sibling.Hash, sibling.Sum
+		if position&1 == 0 {
+			node, err = parentMerkleSumNode(tree.Curve, node, sibling)
+		} else {
+			node, err = parentMerkleSumNode(tree.Curve, sibling, node)
+		}
+		if err != nil {
+			return LeafUpdate{}, err
+		}
+		position >>= 1
+		tree.Levels[level+1][position] = node
+	}
+
+	switch {
+	case old.IsEmpty() && !leaf.IsEmpty():
+		tree.NbAccounts++
+	case !old.IsEmpty() && leaf.IsEmpty():
+		tree.NbAccounts--
+	}
+	return update, nil
+}
+
+// clone returns a copy of the tree that can be updated independently
+func (tree *MerkleSumTree) clone() *MerkleSumTree {
+	clone := &MerkleSumTree{
+		Leaves:     append([]MerkleSumLeaf{}, tree.Leaves...),
+		Levels:     make([][]MerkleSumNode, len(tree.Levels)),
+		NbAccounts: tree.NbAccounts,
+		Curve:      tree.Curve,
+	}
+	for i, level := range tree.Levels {
+		clone.Levels[i] = append([]MerkleSumNode{}, level...)
+	}
+	return clone
+}
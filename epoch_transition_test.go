@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+func createMerkleSumTreeOfSnapshot(accounts []AccountBalance, capacity int) (*MerkleSumTree, error) {
+	return createMerkleSumTreeOfSnapshotOnCurve(ecc.BLS12_381, accounts, capacity)
+}
+
+func createMerkleSumTreeOfSnapshotOnCurve(curve ecc.ID, accounts []AccountBalance, capacity int) (*MerkleSumTree, error) {
+	leaves := make([]MerkleSumLeaf, len(accounts))
+	for i, account := range accounts {
+		leaf, err := NewMerkleSumLeafOnCurve(curve, account.Address, account.Balance)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return NewMerkleSumTreeWithCapacityOnCurve(curve, leaves, capacity)
+}
+
+// nextSnapshot changes the balance of the first account, deletes the second
+// one and inserts two new accounts
+func nextSnapshot(previous []AccountBalance) []AccountBalance {
+	next := []AccountBalance{{Address: previous[0].Address, Balance: big.NewInt(7)}}
+	next = append(next, previous[2:]...)
+	return append(next, createAccountBalances(2)...)
+}
+
+func TestDiffSnapshots(t *testing.T) {
+
+	previous := createAccountBalances(4)
+	next := nextSnapshot(previous)
+	updates, err := DiffSnapshots(previous, next)
+	if err != nil {
+		t.Fatalf("Failed to diff snapshots: %v", err)
+	}
+
+	kinds := map[BalanceUpdateKind]int{}
+	for _, update := range updates {
+		kinds[update.Kind]++
+		if update.Kind == ChangeBalance && update.Balance.Cmp(big.NewInt(7)) != 0 {
+			t.Fatalf("Expected the new balance 7, got %v", update.Balance)
+		}
+	}
+	if len(updates) != 4 || kinds[InsertAccount] != 2 || kinds[ChangeBalance] != 1 || kinds[DeleteAccount] != 1 {
+		t.Fatalf("Expected 2 inserts, 1 change and 1 deletion, got %v", kinds)
+	}
+
+	if updates, err := DiffSnapshots(previous, previous); err != nil || len(updates) != 0 {
+		t.Fatalf("Expected no update between identical snapshots, got %v (%v)", updates, err)
+	}
+
+	duplicated := append(createAccountBalances(1), previous[0], previous[0])
+	if _, err := DiffSnapshots(previous, duplicated); !errors.Is(err, ErrDuplicateAccount) {
+		t.Fatalf("Expected ErrDuplicateAccount, got %v", err)
+	}
+	// An address spelled without its 0x prefix and in uppercase is the same account
+	respelled := AccountBalance{Address: strings.ToUpper(strings.TrimPrefix(previous[0].Address, "0x")), Balance: big.NewInt(1)}
+	if _, err := DiffSnapshots(previous, append([]AccountBalance{respelled}, previous...)); !errors.Is(err, ErrDuplicateAccount) {
+		t.Fatalf("Expected ErrDuplicateAccount, got %v", err)
+	}
+
+	malformed := AccountBalance{Address: "0xnot-an-address", Balance: big.NewInt(1)}
+	if _, err := DiffSnapshots(previous, append([]AccountBalance{malformed}, previous...)); err == nil || !strings.Contains(err.Error(), malformed.Address) {
+		t.Fatalf("Expected an error naming the malformed address, got %v", err)
+	}
+}
+
+func TestEpochTransitionProofAndVerification(t *testing.T) {
+
+	// The inserted accounts only fit in the tree if they take the leaf of the
+	// deleted one
+	const capacity, batchSize = 4, 4
+	previous := createAccountBalances(3)
+	next := nextSnapshot(previous)
+
+	tree, err := createMerkleSumTreeOfSnapshot(previous, capacity)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	updates, err := DiffSnapshots(previous, next)
+	if err != nil {
+		t.Fatalf("Failed to diff snapshots: %v", err)
+	}
+	nextTree, assignment, err := tree.ApplyUpdates(updates, batchSize)
+	if err != nil {
+		t.Fatalf("Failed to apply updates: %v", err)
+	}
+
+	t.Run("MatchesTheTreeOfTheNextSnapshot", func(t *testing.T) {
+		totalSum := big.NewInt(0)
+		for _, account := range next {
+			totalSum.Add(totalSum, account.Balance)
+		}
+		if nextTree.Root().Sum.Cmp(totalSum) != 0 {
+			t.Fatalf("Root sum %v does not match the total balance %v", nextTree.Root().Sum, totalSum)
+		}
+		if nextTree.NbAccounts != len(next) {
+			t.Fatalf("Expected %d accounts, got %d", len(next), nextTree.NbAccounts)
+		}
+		if tree.Root().Hash.Cmp(assignment.PreviousRootHash.(*big.Int)) != 0 || tree.NbAccounts != len(previous) {
+			t.Fatal("Applying the updates modified the previous tree")
+		}
+		proofs, err := GenerateInclusionProofs(nextTree)
+		if err != nil {
+			t.Fatalf("Failed to generate inclusion proofs: %v", err)
+		}
+		if len(proofs) != len(next) {
+			t.Fatalf("Expected %d inclusion proofs, got %d", len(next), len(proofs))
+		}
+		for _, proof := range proofs {
+			if err := VerifyInclusionProof(proof, nextTree.Root()); err != nil {
+				t.Fatalf("Failed to verify inclusion proof: %v", err)
+			}
+		}
+	})
+
+	t.Run("ProveAndVerify", func(t *testing.T) {
+		cs, err := testProvingBackend(t).Compile(NewEpochTransitionCircuit(tree.Depth(), batchSize))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		pk, vk, err := testProvingBackend(t).Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+		if err != nil {
+			t.Fatalf("Failed to create witness: %v", err)
+		}
+		proof, err := Prove(cs, pk, fw)
+		if err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+
+		for _, tc := range []struct {
+			name   string
+			modify func(public *EpochTransitionCircuit)
+			valid  bool
+		}{
+			{"PublishedRoots", func(public *EpochTransitionCircuit) {}, true},
+			{"WrongPreviousRoot", func(public *EpochTransitionCircuit) {
+				public.PreviousRootHash = nextTree.Root().Hash
+			}, false},
+			{"WrongNewTotal", func(public *EpochTransitionCircuit) {
+				public.NewTotalSum = new(big.Int).Add(nextTree.Root().Sum, big.NewInt(1))
+			}, false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				public := *assignment
+				tc.modify(&public)
+				pw, err := frontend.NewWitness(&public, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+				if err != nil {
+					t.Fatalf("Failed to create public witness: %v", err)
+				}
+				err = Verify(proof, vk, pw)
+				if tc.valid && err != nil {
+					t.Fatalf("Expected the proof to verify: %v", err)
+				}
+				if !tc.valid && err == nil {
+					t.Fatal("Expected the proof not to verify")
+				}
+			})
+		}
+	})
+
+	t.Run("TreeFull", func(t *testing.T) {
+		if _, _, err := nextTree.ApplyUpdates(updatesInserting(createAccountBalances(1)), batchSize); err == nil {
+			t.Fatal("Expected an error when no empty leaf is left")
+		}
+	})
+
+	t.Run("BatchTooLarge", func(t *testing.T) {
+		if _, _, err := tree.ApplyUpdates(updatesInserting(createAccountBalances(batchSize+1)), batchSize); err == nil {
+			t.Fatal("Expected an error for more updates than the batch size")
+		}
+	})
+}
+
+func TestEpochTransitionOnBN254(t *testing.T) {
+
+	const capacity, batchSize = 4, 4
+	provingBackend := Groth16Backend.OnCurve(ecc.BN254)
+	previous := createAccountBalances(3)
+	next := nextSnapshot(previous)
+
+	tree, err := createMerkleSumTreeOfSnapshotOnCurve(ecc.BN254, previous, capacity)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	updates, err := DiffSnapshots(previous, next)
+	if err != nil {
+		t.Fatalf("Failed to diff snapshots: %v", err)
+	}
+	nextTree, assignment, err := tree.ApplyUpdates(updates, batchSize)
+	if err != nil {
+		t.Fatalf("Failed to apply updates: %v", err)
+	}
+
+	// The updated tree is the one built from scratch on the same curve
+	leaves := make([]MerkleSumLeaf, len(nextTree.Leaves))
+	copy(leaves, nextTree.Leaves)
+	rebuilt, err := NewMerkleSumTreeWithCapacityOnCurve(ecc.BN254, leaves, capacity)
+	if err != nil {
+		t.Fatalf("Failed to rebuild tree: %v", err)
+	}
+	if rebuilt.Root().Hash.Cmp(nextTree.Root().Hash) != 0 || rebuilt.Root().Sum.Cmp(nextTree.Root().Sum) != 0 {
+		t.Fatal("The updated tree does not match the tree of its leaves")
+	}
+
+	cs, err := provingBackend.Compile(NewEpochTransitionCircuit(tree.Depth(), batchSize))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+	fw, err := provingBackend.NewWitness(assignment)
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+}
+
+func updatesInserting(accounts []AccountBalance) []BalanceUpdate {
+	updates := make([]BalanceUpdate, len(accounts))
+	for i, account := range accounts {
+		updates[i] = BalanceUpdate{Kind: InsertAccount, Address: account.Address, Balance: account.Balance}
+	}
+	return updates
+}
+
+func TestEpochTransitionRejectsInvalidUpdates(t *testing.T) {
+
+	const capacity, batchSize = 4, 2
+	previous := createAccountBalances(3)
+	tree, err := createMerkleSumTreeOfSnapshot(previous, capacity)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	updates := []BalanceUpdate{
+		{Kind: ChangeBalance, Address: previous[1].Address, Balance: big.NewInt(5)},
+		{Kind: DeleteAccount, Address: previous[2].Address},
+	}
+	_, valid, err := tree.ApplyUpdates(updates, batchSize)
+	if err != nil {
+		t.Fatalf("Failed to apply updates: %v", err)
+	}
+	malformed := []BalanceUpdate{{Kind: InsertAccount, Address: "0x1234", Balance: big.NewInt(5)}}
+	if _, _, err := tree.ApplyUpdates(malformed, batchSize); err == nil || !strings.Contains(err.Error(), "0x1234") {
+		t.Fatalf("Expected an error naming the malformed address, got %v", err)
+	}
+
+	// A "negative" balance wraps around the scalar field to p - x
+	wrappedBalance := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(5))
+
+	testCases := []struct {
+		name   string
+		modify func(assignment *EpochTransitionCircuit)
+		valid  bool
+	}{
+		{"ValidUpdates", func(assignment *EpochTransitionCircuit) {}, true},
+		{"TamperedSibling", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[0].SiblingSums[0] = 1
+		}, false},
+		{"WrongOldBalance", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[0].OldBalance = 0
+		}, false},
+		{"UnaccountedNewBalance", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[1].NewBalance = 1
+		}, false},
+		{"WrappedNewBalance", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[1].NewBalance = wrappedBalance
+			assignment.NewTotalSum = new(big.Int).Sub(valid.NewTotalSum.(*big.Int), big.NewInt(5))
+		}, false},
+		{"WrongIndex", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[0].Index = 0
+		}, false},
+		{"IndexOutOfRange", func(assignment *EpochTransitionCircuit) {
+			assignment.Updates[0].Index = capacity + 1
+		}, false},
+	}
+
+	circuit := NewEpochTransitionCircuit(tree.Depth(), batchSize)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := *valid
+			assignment.Updates = make([]LeafUpdate, len(valid.Updates))
+			for i, update := range valid.Updates {
+				update.SiblingHashes = append([]frontend.Variable{}, update.SiblingHashes...)
+				update.SiblingSums = append([]frontend.Variable{}, update.SiblingSums...)
+				assignment.Updates[i] = update
+			}
+			tc.modify(&assignment)
+			err := test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
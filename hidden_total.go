@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+)
+
+// The circuits of this file keep the total private and only publish bounds on
+// it, for when the exact total must not be disclosed. The threshold circuits
+// prove TotalSum >= LowerBound and the range circuits, which embed them, add
+// TotalSum <= UpperBound.
+
+// assertTotalInRange asserts lowerBound <= total, and total <= upperBound
+// unless upperBound is nil. Both comparisons decompose their operands over
+// the whole field, so the bounds can take any value.
+func assertTotalInRange(api frontend.API, total, lowerBound, upperBound frontend.Variable) {
+	api.AssertIsLessOrEqual(lowerBound, total)
+	if upperBound != nil {
+		api.AssertIsLessOrEqual(total, upperBound)
+	}
+}
+
+// ThresholdSumAggregationCircuit proves that the balances of a snapshot add
+// up to at least the public LowerBound. The total is computed in-circuit and
+// stays private. The slots obey the invariants of SumAggregationCircuit:
+// strictly increasing account hashes and empty inactive slots.
+type ThresholdSumAggregationCircuit struct {
+	Balances       []frontend.Variable `gnark:"balances,secret"`        // User balances (private inputs)
+	AccountHashes  []frontend.Variable `gnark:"account_hashes,secret"`  // Account of every balance, in strictly increasing order
+	Active         []frontend.Variable `gnark:"active,secret"`          // Whether every slot holds an account
+	LowerBound     frontend.Variable   `gnark:"lower_bound,public"`     // Public lower bound on the total
+	Epoch          frontend.Variable   `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest frontend.Variable   `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	BalanceBits    int                 `gnark:"-"`                      // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+// NewThresholdSumAggregationCircuit returns the circuit with capacity slots
+func NewThresholdSumAggregationCircuit(capacity int) *ThresholdSumAggregationCircuit {
+	return &ThresholdSumAggregationCircuit{
+		Balances:      make([]frontend.Variable, capacity),
+		AccountHashes: make([]frontend.Variable, capacity),
+		Active:        make([]frontend.Variable, capacity),
+	}
+}
+
+// NewThresholdSumAggregationAssignment builds the witness assignment of a
+// circuit of capacity slots for the given snapshot and lower bound, with the
+// slots laid out by NewSumAggregationAssignment
+func NewThresholdSumAggregationAssignment(accounts []AccountBalance, capacity int, epoch uint64, snapshotDigest, lowerBound *big.Int) (*ThresholdSumAggregationCircuit, error) {
+	sum, err := NewSumAggregationAssignment(accounts, capacity, epoch, snapshotDigest)
+	if err != nil {
+		return nil, err
+	}
+	return &ThresholdSumAggregationCircuit{
+		Balances:       sum.Balances,
+		AccountHashes:  sum.AccountHashes,
+		Active:         sum.Active,
+		LowerBound:     lowerBound,
+		Epoch:          sum.Epoch,
+		SnapshotDigest: sum.SnapshotDigest,
+	}, nil
+}
+
+func (circuit *ThresholdSumAggregationCircuit) Define(api frontend.API) error {
+	total, err := circuit.totalSum(api)
+	if err != nil {
+		return err
+	}
+	assertTotalInRange(api, total, circuit.LowerBound, nil)
+	return nil
+}
+
+// totalSum returns the total of the active slots, checked like the one of
+// SumAggregationCircuit
+func (circuit *ThresholdSumAggregationCircuit) totalSum(api frontend.API) (frontend.Variable, error) {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	total, _, err := sumActiveBalances(api, circuit.Balances, circuit.AccountHashes, circuit.Active, balanceBitsOrDefault(circuit.BalanceBits))
+	return total, err
+}
+
+// RangeSumAggregationCircuit proves LowerBound <= total <= UpperBound for the
+// private total of the balances
+type RangeSumAggregationCircuit struct {
+	ThresholdSumAggregationCircuit
+	UpperBound frontend.Variable `gnark:"upper_bound,public"` // Public upper bound on the total
+}
+
+// NewRangeSumAggregationCircuit returns the circuit with capacity slots
+func NewRangeSumAggregationCircuit(capacity int) *RangeSumAggregationCircuit {
+	return &RangeSumAggregationCircuit{ThresholdSumAggregationCircuit: *NewThresholdSumAggregationCircuit(capacity)}
+}
+
+// NewRangeSumAggregationAssignment builds the witness assignment of a circuit
+// of capacity slots for the given snapshot and bounds
+func NewRangeSumAggregationAssignment(accounts []AccountBalance, capacity int, epoch uint64, snapshotDigest, lowerBound, upperBound *big.Int) (*RangeSumAggregationCircuit, error) {
+	threshold, err := NewThresholdSumAggregationAssignment(accounts, capacity, epoch, snapshotDigest, lowerBound)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeSumAggregationCircuit{ThresholdSumAggregationCircuit: *threshold, UpperBound: upperBound}, nil
+}
+
+func (circuit *RangeSumAggregationCircuit) Define(api frontend.API) error {
+	total, err := circuit.totalSum(api)
+	if err != nil {
+		return err
+	}
+	assertTotalInRange(api, total, circuit.LowerBound, circuit.UpperBound)
+	return nil
+}
+
+// ThresholdAggregatedBalanceCircuit proves that the sum of the public Pedersen
+// commitments, which AggregatedBalanceCircuit publishes as TotalCommitment,
+// commits to a total balance of at least LowerBound. The total commitment
+// stays private: the circuit opens it to the private total balance instead.
+type ThresholdAggregatedBalanceCircuit struct {
+	Commitments      []twistededwards.Point `gnark:"commitments,public"`
+	TotalBalance     frontend.Variable      `gnark:"total_balance,secret"`
+	TotalBlinding    frontend.Variable      `gnark:"total_blinding,secret"`
+	TotalAccountHash [2]frontend.Variable   `gnark:"total_account_hash,secret"` // Totals of the low and high halves of the account hashes
+	LowerBound       frontend.Variable      `gnark:"lower_bound,public"`        // Public lower bound on the total balance
+	Epoch            frontend.Variable      `gnark:"epoch,public"`              // Epoch the snapshot was taken at
+	SnapshotDigest   frontend.Variable      `gnark:"snapshot_digest,public"`    // Digest of the input dataset
+	BalanceBits      int                    `gnark:"-"`                         // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+func (circuit *ThresholdAggregatedBalanceCircuit) Define(api frontend.API) error {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	if err := circuit.assertOpening(api); err != nil {
+		return err
+	}
+	assertTotalInRange(api, circuit.TotalBalance, circuit.LowerBound, nil)
+	return nil
+}
+
+// assertOpening asserts that the sum of the commitments is the Pedersen
+// commitment to the total balance, blinding and account hash. Only Pedersen
+// commitments can be opened this way, the sum of hash commitments does not
+// commit to anything.
+func (circuit *ThresholdAggregatedBalanceCircuit) assertOpening(api frontend.API) error {
+	if len(circuit.Commitments) == 0 {
+		return errors.New("expected at least one commitment")
+	}
+	totalCommitment, err := aggregateCommitments(api, PedersenScheme, circuit.Commitments)
+	if err != nil {
+		return err
+	}
+
+	// The total of n balances fits in a few more bits than each balance
+	totalBits := balanceBitsOrDefault(circuit.BalanceBits) + bits.Len(uint(len(circuit.Commitments)))
+	opening, err := pedersenCommitLimbs(api, circuit.TotalBalance, circuit.TotalBlinding, circuit.TotalAccountHash[0], circuit.TotalAccountHash[1], totalBits)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(opening.X, totalCommitment.X)
+	api.AssertIsEqual(opening.Y, totalCommitment.Y)
+	return nil
+}
+
+// RangeAggregatedBalanceCircuit proves that the sum of the public Pedersen
+// commitments commits to a total balance between LowerBound and UpperBound
+type RangeAggregatedBalanceCircuit struct {
+	ThresholdAggregatedBalanceCircuit
+	UpperBound frontend.Variable `gnark:"upper_bound,public"` // Public upper bound on the total balance
+}
+
+func (circuit *RangeAggregatedBalanceCircuit) Define(api frontend.API) error {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	if err := circuit.assertOpening(api); err != nil {
+		return err
+	}
+	assertTotalInRange(api, circuit.TotalBalance, circuit.LowerBound, circuit.UpperBound)
+	return nil
+}
+
+// OpenAggregatedPedersenCommitment natively computes the opening of the sum of
+// BLS12-381 Pedersen commitments from the openings of the commitments. The
+// blindings and the halves of the account hashes are added modulo the order
+// of the commitment group, which differs from the scalar field, so that the
+// opening is made of canonical scalars.
+func OpenAggregatedPedersenCommitment(balances, blindings, accountHashes []*big.Int) (totalBalance, totalBlinding *big.Int, totalAccountHash [2]*big.Int, err error) {
+	return OpenAggregatedPedersenCommitmentOnCurve(ecc.BLS12_381, balances, blindings, accountHashes)
+}
+
+// OpenAggregatedPedersenCommitmentOnCurve natively computes the opening of
+// the sum of Pedersen commitments of circuits compiled on id
+func OpenAggregatedPedersenCommitmentOnCurve(id ecc.ID, balances, blindings, accountHashes []*big.Int) (totalBalance, totalBlinding *big.Int, totalAccountHash [2]*big.Int, err error) {
+	if len(blindings) != len(balances) || len(accountHashes) != len(balances) {
+		return nil, nil, totalAccountHash, errors.New("expected one balance, blinding and account hash per commitment")
+	}
+	edwardsID, err := edwardsCurveOf(id)
+	if err != nil {
+		return nil, nil, totalAccountHash, err
+	}
+	curve, err := newEdwardsCurve(edwardsID)
+	if err != nil {
+		return nil, nil, totalAccountHash, err
+	}
+
+	totalBalance, totalBlinding = new(big.Int), new(big.Int)
+	totalAccountHash = [2]*big.Int{new(big.Int), new(big.Int)}
+	for i := range balances {
+		if err := checkAccountHash(accountHashes[i], curve.field); err != nil {
+			return nil, nil, totalAccountHash, err
+		}
+		totalBalance.Add(totalBalance, balances[i])
+		totalBlinding.Add(totalBlinding, blindings[i])
+		low, high := accountHashLimbs(id, accountHashes[i])
+		totalAccountHash[0].Add(totalAccountHash[0], low)
+		totalAccountHash[1].Add(totalAccountHash[1], high)
+	}
+	totalBlinding.Mod(totalBlinding, curve.params.Order)
+	totalAccountHash[0].Mod(totalAccountHash[0], curve.params.Order)
+	totalAccountHash[1].Mod(totalAccountHash[1], curve.params.Order)
+	return totalBalance, totalBlinding, totalAccountHash, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
+)
+
+// createPedersenOpenings draws random accounts and returns their Pedersen
+// commitments along with the opening of their sum
+func createPedersenOpenings(nbAccounts int) ([]twistededwards.Point, *big.Int, *big.Int, [2]*big.Int, error) {
+	balances := make([]*big.Int, nbAccounts)
+	blindings := make([]*big.Int, nbAccounts)
+	accountHashes := make([]*big.Int, nbAccounts)
+	commitments := make([]twistededwards.Point, nbAccounts)
+	for i := 0; i < nbAccounts; i++ {
+		var err error
+		balances[i] = big.NewInt(int64(rand.Int64()))
+		blindings[i], err = RandomBlinding()
+		if err != nil {
+			return nil, nil, nil, [2]*big.Int{}, err
+		}
+		accountHashes[i] = AccountHash(randomEthereumAddress())
+		commitments[i], err = PrecomputePedersenCommitment(balances[i], blindings[i], accountHashes[i])
+		if err != nil {
+			return nil, nil, nil, [2]*big.Int{}, err
+		}
+	}
+	totalBalance, totalBlinding, totalAccountHash, err := OpenAggregatedPedersenCommitment(balances, blindings, accountHashes)
+	if err != nil {
+		return nil, nil, nil, [2]*big.Int{}, err
+	}
+	return commitments, totalBalance, totalBlinding, totalAccountHash, nil
+}
+
+func TestHiddenTotalSumStatements(t *testing.T) {
+
+	balances := []frontend.Variable{10, 20, 30, 40}
+	// A wrapped-around "negative" balance must not help reaching the threshold
+	negativeBalances := []frontend.Variable{10, 20, 30, new(big.Int).Sub(fr.Modulus(), big.NewInt(40))}
+
+	// The same account in two slots must not help reaching the threshold
+	repeatedAccountHashes := testAccountHashes(len(balances))
+	repeatedAccountHashes[1] = repeatedAccountHashes[0]
+
+	testCases := []struct {
+		name          string
+		balances      []frontend.Variable
+		accountHashes []frontend.Variable
+		lowerBound    frontend.Variable
+		upperBound    frontend.Variable // nil for a threshold statement
+		valid         bool
+	}{
+		{"AboveThreshold", balances, testAccountHashes(4), 99, nil, true},
+		{"AtThreshold", balances, testAccountHashes(4), 100, nil, true},
+		{"BelowThreshold", balances, testAccountHashes(4), 101, nil, false},
+		{"WrappedNegativeBalance", negativeBalances, testAccountHashes(4), 0, nil, false},
+		{"RepeatedAccount", balances, repeatedAccountHashes, 0, nil, false},
+		{"InRange", balances, testAccountHashes(4), 50, 150, true},
+		{"AtRangeBounds", balances, testAccountHashes(4), 100, 100, true},
+		{"AboveRange", balances, testAccountHashes(4), 50, 99, false},
+		{"BelowRange", balances, testAccountHashes(4), 101, 150, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			threshold := ThresholdSumAggregationCircuit{
+				Balances:       tc.balances,
+				AccountHashes:  tc.accountHashes,
+				Active:         activeSlots(len(tc.balances), len(tc.balances)),
+				LowerBound:     tc.lowerBound,
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+			placeholder := NewThresholdSumAggregationCircuit(len(tc.balances))
+			var err error
+			if tc.upperBound == nil {
+				err = test.IsSolved(placeholder, &threshold, ecc.BLS12_381.ScalarField())
+			} else {
+				err = test.IsSolved(
+					&RangeSumAggregationCircuit{ThresholdSumAggregationCircuit: *placeholder},
+					&RangeSumAggregationCircuit{ThresholdSumAggregationCircuit: threshold, UpperBound: tc.upperBound},
+					ecc.BLS12_381.ScalarField(),
+				)
+			}
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestHiddenTotalSumProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	accounts := createAccountBalances(nbAccounts)
+	totalSum := big.NewInt(0)
+	for _, account := range accounts {
+		totalSum.Add(totalSum, account.Balance)
+	}
+
+	cs, err := testProvingBackend(t).Compile(NewRangeSumAggregationCircuit(nbAccounts))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	lowerBound := new(big.Int).Rsh(totalSum, 1)
+	upperBound := new(big.Int).Lsh(totalSum, 1)
+	assignment, err := NewRangeSumAggregationAssignment(accounts, nbAccounts, testEpoch, testSnapshotDigest, lowerBound, upperBound)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+
+	// The public witness holds the bounds and the snapshot, nothing else
+	publicValues := pw.Vector().(fr.Vector)
+	if len(publicValues) != 4 {
+		t.Fatalf("Expected 4 public inputs, got %d", len(publicValues))
+	}
+	for _, value := range publicValues {
+		if value.BigInt(new(big.Int)).Cmp(totalSum) == 0 {
+			t.Fatal("The public witness discloses the total")
+		}
+	}
+
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	t.Log("Proof verified successfully!")
+}
+
+func TestHiddenTotalCommitmentStatements(t *testing.T) {
+
+	commitments, totalBalance, totalBlinding, totalAccountHash, err := createPedersenOpenings(4)
+	if err != nil {
+		t.Fatalf("Failed to create commitments: %v", err)
+	}
+
+	// The opening of the sum matches the natively aggregated commitment
+	aggregated, err := AggregatePedersenCommitments(commitments)
+	if err != nil {
+		t.Fatalf("Failed to aggregate commitments: %v", err)
+	}
+	opened, err := precomputePedersenCommitmentOfLimbs(ecc.BLS12_381, totalBalance, totalBlinding, totalAccountHash[0], totalAccountHash[1])
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	if variableToBigInt(aggregated.X).Cmp(variableToBigInt(opened.X)) != 0 || variableToBigInt(aggregated.Y).Cmp(variableToBigInt(opened.Y)) != 0 {
+		t.Fatal("The opening does not match the aggregated commitment")
+	}
+
+	below := new(big.Int).Sub(totalBalance, big.NewInt(1))
+	above := new(big.Int).Add(totalBalance, big.NewInt(1))
+	testCases := []struct {
+		name         string
+		totalBalance *big.Int
+		lowerBound   *big.Int
+		upperBound   *big.Int // nil for a threshold statement
+		valid        bool
+	}{
+		{"AboveThreshold", totalBalance, below, nil, true},
+		{"BelowThreshold", totalBalance, above, nil, false},
+		{"ClaimedTotalNotCommitted", above, totalBalance, nil, false},
+		{"InRange", totalBalance, below, above, true},
+		{"AboveRange", totalBalance, below, below, false},
+		{"ClaimedTotalInRangeNotCommitted", below, below, below, false},
+	}
+
+	newThreshold := func(totalBalance, lowerBound *big.Int) ThresholdAggregatedBalanceCircuit {
+		return ThresholdAggregatedBalanceCircuit{
+			Commitments:      commitments,
+			TotalBalance:     totalBalance,
+			TotalBlinding:    totalBlinding,
+			TotalAccountHash: [2]frontend.Variable{totalAccountHash[0], totalAccountHash[1]},
+			LowerBound:       lowerBound,
+			Epoch:            testEpoch,
+			SnapshotDigest:   testSnapshotDigest,
+		}
+	}
+	placeholder := ThresholdAggregatedBalanceCircuit{Commitments: make([]twistededwards.Point, len(commitments))}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			threshold := newThreshold(tc.totalBalance, tc.lowerBound)
+			var err error
+			if tc.upperBound == nil {
+				err = test.IsSolved(&placeholder, &threshold, ecc.BLS12_381.ScalarField())
+			} else {
+				err = test.IsSolved(
+					&RangeAggregatedBalanceCircuit{ThresholdAggregatedBalanceCircuit: placeholder},
+					&RangeAggregatedBalanceCircuit{ThresholdAggregatedBalanceCircuit: threshold, UpperBound: tc.upperBound},
+					ecc.BLS12_381.ScalarField(),
+				)
+			}
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestHiddenTotalCommitmentProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	commitments, totalBalance, totalBlinding, totalAccountHash, err := createPedersenOpenings(nbAccounts)
+	if err != nil {
+		t.Fatalf("Failed to create commitments: %v", err)
+	}
+
+	circuit := ThresholdAggregatedBalanceCircuit{Commitments: make([]twistededwards.Point, nbAccounts)}
+	cs, err := testProvingBackend(t).Compile(&circuit)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	assignment := ThresholdAggregatedBalanceCircuit{
+		Commitments:      commitments,
+		TotalBalance:     totalBalance,
+		TotalBlinding:    totalBlinding,
+		TotalAccountHash: [2]frontend.Variable{totalAccountHash[0], totalAccountHash[1]},
+		LowerBound:       new(big.Int).Rsh(totalBalance, 1),
+		Epoch:            testEpoch,
+		SnapshotDigest:   testSnapshotDigest,
+	}
+	fw, err := frontend.NewWitness(&assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	// The commitments, the bound and the snapshot are public, the total
+	// commitment is not
+	if nbPublic := len(pw.Vector().(fr.Vector)); nbPublic != 2*nbAccounts+3 {
+		t.Fatalf("Expected %d public inputs, got %d", 2*nbAccounts+3, nbPublic)
+	}
+
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	t.Log("Proof verified successfully!")
+}
@@ -8,95 +8,37 @@ import (
 	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/frontend/schema"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
 )
 
 type IndividualBalanceCircuit struct {
-	Balance     frontend.Variable `gnark:"balance,secret"`
-	Blinding    frontend.Variable `gnark:"blinding,secret"`
-	AccountHash frontend.Variable `gnark:"account_hash,secret"`
-	Commitment  frontend.Variable `gnark:"commitment,public"`
+	Balance        frontend.Variable   `gnark:"balance,secret"`
+	Blinding       frontend.Variable   `gnark:"blinding,secret"`
+	AccountHash    frontend.Variable   `gnark:"account_hash,secret"`
+	Commitment     []frontend.Variable `gnark:"commitment,public"`      // Point coordinates or hash, as returned by PrecomputeCommitment
+	Epoch          frontend.Variable   `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest frontend.Variable   `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	BalanceBits    int                 `gnark:"-"`                      // Bit width of the balance (BALANCE_BITS if unset)
+	Scheme         CommitmentScheme    `gnark:"-"`                      // Commitment scheme (Pedersen by default)
 }
 
-// PrecomputePedersenCommitment computes the Pedersen commitment
-/*
-func PrecomputePedersenCommitment(balance, blinding, accountHash *big.Int) (bls12381.G1Affine, error) {
-	// Initialize generator points for G1
-	g := bls12381.G1Affine{}
-	g.Set(&bls12381.G1Gen)
-
-	// Generate a random point h in G1
-	var h bls12381.G1Affine
-	_, err := h.SetRandom()
-	if err != nil {
-		return bls12381.G1Affine{}, err
+// NewIndividualBalanceCircuit returns the circuit proving a commitment to a
+// balance with the given scheme
+func NewIndividualBalanceCircuit(scheme CommitmentScheme) *IndividualBalanceCircuit {
+	return &IndividualBalanceCircuit{
+		Commitment: make([]frontend.Variable, scheme.size()),
+		Scheme:     scheme,
 	}
-
-	// Convert balance and blinding to field elements
-	var balanceFr, blindingFr fr.Element
-	balanceFr.SetBigInt(balance)
-	blindingFr.SetBigInt(blinding)
-
-	// Compute g^balance
-	var balanceCommitment bls12381.G1Jac
-	balanceCommitment.ScalarMultiplication(&g, balanceFr)
-
-	// Compute h^blinding
-	var blindingCommitment bls12381.G1Jac
-	blindingCommitment.ScalarMultiplication(&h, blindingFr)
-
-	// Hash the account identifier to the scalar field using MiMC
-	mimcHash := mimc.NewMiMC()
-	accountHashBytes := accountHash.Bytes()
-	mimcHash.Write(accountHashBytes)
-	accountHashFr := mimcHash.Sum(nil)
-
-	var accountHashCommitment bls12381.G1Jac
-	accountHashCommitment.ScalarMultiplication(&g, &accountHashFr)
-
-	// Compute the final commitment: C = g^balance * h^blinding * H(accountHash)
-	var commitment bls12381.G1Jac
-	commitment.Set(&balanceCommitment).
-		AddAssign(&blindingCommitment).
-		AddAssign(&accountHashCommitment)
-
-	// Convert to affine coordinates
-	var commitmentAffine bls12381.G1Affine
-	commitmentAffine.FromJacobian(&commitment)
-
-	return commitmentAffine, nil
 }
-*/
 
 func (circuit *IndividualBalanceCircuit) Define(api frontend.API) error {
-	// Initialize MiMC hash function
-	/*
-		hash, err := mimc.NewMiMC(api)
-		if err != nil {
-			return err
-		}
-
-		// Compute g^balance
-		g := sw_bls12381.G1AffineGenerator()
-		balanceCommitment := sw_bls12381.ScalarMul(api, g, circuit.Balance)
-
-		// Compute h^blinding
-		h := sw_bls12381.G1AffineGenerator() // In practice, h should be a different point
-		blindingCommitment := sw_bls12381.ScalarMul(api, h, circuit.Blinding)
-
-		// Compute H(accountHash)
-		accountHashCommitment := sw_bls12381.ScalarMul(api, g, accountHash)
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
 
-		// Compute the final commitment: C = g^balance * h^blinding * H(accountHash)
-		commitment := sw_bls12381.Add(api, balanceCommitment, blindingCommitment)
-		commitment = sw_bls12381.Add(api, commitment, accountHashCommitment)
-	*/
-
-	// Placeholder commitment calculation in the circuit
-	commitment := api.Add(api.Mul(circuit.Balance, circuit.Blinding), circuit.AccountHash)
-
-	// Assert the computed commitment equals the public commitment
-	api.AssertIsEqual(commitment, circuit.Commitment)
-	return nil
+	// Compute the commitment to the balance with the selected scheme, either a
+	// Pedersen commitment on the embedded twisted Edwards curve or a MiMC hash,
+	// and assert it equals the public commitment
+	return assertCommitment(api, circuit.Scheme, circuit.Balance, circuit.Blinding, circuit.AccountHash, balanceBitsOrDefault(circuit.BalanceBits), circuit.Commitment)
 }
 
 // Implement io.WriterTo
@@ -118,7 +60,7 @@ func (w *IndividualBalanceCircuit) ReadFrom(reader io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int64(4), nil
+	return int64(len(w.Commitment) + 5), nil
 }
 
 // Implement encoding.BinaryMarshaler
@@ -133,12 +75,14 @@ func (w *IndividualBalanceCircuit) UnmarshalBinary(data []byte) error {
 
 // Implement Public method
 func (w *IndividualBalanceCircuit) Public() (witness.Witness, error) {
-	return &IndividualBalanceCircuit{Commitment: w.Commitment}, nil
+	return &IndividualBalanceCircuit{Commitment: w.Commitment, Epoch: w.Epoch, SnapshotDigest: w.SnapshotDigest}, nil
 }
 
 // Implement Vector method
 func (w *IndividualBalanceCircuit) Vector() any {
-	return []frontend.Variable{w.Balance, w.Blinding, w.AccountHash, w.Commitment}
+	vector := []frontend.Variable{w.Balance, w.Blinding, w.AccountHash}
+	vector = append(vector, w.Commitment...)
+	return append(vector, w.Epoch, w.SnapshotDigest)
 }
 
 // Implement ToJSON method
@@ -156,8 +100,8 @@ func (w *IndividualBalanceCircuit) Fill(nbPublic, nbSecret int, values <-chan an
 	if nbSecret != 3 {
 		return errors.New("expected 3 secret inputs")
 	}
-	if nbPublic != 1 {
-		return errors.New("expected 1 public input")
+	if nbPublic < 3 {
+		return errors.New("expected a commitment and 2 more public inputs")
 	}
 
 	v, ok := <-values
@@ -178,32 +122,81 @@ func (w *IndividualBalanceCircuit) Fill(nbPublic, nbSecret int, values <-chan an
 	}
 	w.AccountHash = v.(frontend.Variable)
 
+	w.Commitment = make([]frontend.Variable, nbPublic-2)
+	for i := range w.Commitment {
+		v, ok = <-values
+		if !ok {
+			return errors.New("not enough values for public input commitment")
+		}
+		w.Commitment[i] = v.(frontend.Variable)
+	}
+
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public input epoch")
+	}
+	w.Epoch = v.(frontend.Variable)
+
 	v, ok = <-values
 	if !ok {
-		return errors.New("not enough values for public input")
+		return errors.New("not enough values for public input snapshot digest")
 	}
-	w.Commitment = v.(frontend.Variable)
+	w.SnapshotDigest = v.(frontend.Variable)
 
 	return nil
 }
 
+// AggregatedBalanceCircuit sums the commitments of a snapshot. Like
+// SumAggregationCircuit it has a fixed number of slots, and its inactive slots
+// must hold the empty commitment, which commits to a zero balance. Only
+// Pedersen commitments can be summed, hash commitments are rejected with
+// ErrNotHomomorphic.
 type AggregatedBalanceCircuit struct {
-	Commitments     []frontend.Variable `gnark:"commitments,private"`
-	TotalCommitment frontend.Variable   `gnark:"total_commitment,public"`
+	Commitments     []twistededwards.Point `gnark:"commitments,private"`
+	Active          []frontend.Variable    `gnark:"active,secret"` // Whether every slot holds an account
+	TotalCommitment twistededwards.Point   `gnark:"total_commitment,public"`
+	Epoch           frontend.Variable      `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest  frontend.Variable      `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	Scheme          CommitmentScheme       `gnark:"-"`                      // Scheme of the individual commitments
 }
 
 func (circuit *AggregatedBalanceCircuit) Define(api frontend.API) error {
-	// Initialize sum of commitments
-	aggregateSum := frontend.Variable(0)
+	_, err := circuit.assertTotalCommitment(api)
+	return err
+}
+
+// assertTotalCommitment proves the sum of the commitments and returns the
+// number of active slots
+func (circuit *AggregatedBalanceCircuit) assertTotalCommitment(api frontend.API) (frontend.Variable, error) {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
 
-	// Sum all individual commitments
-	for _, commitment := range circuit.Commitments {
-		aggregateSum = api.Add(aggregateSum, commitment)
+	if len(circuit.Active) != len(circuit.Commitments) {
+		return nil, errors.New("expected one active flag per commitment")
+	}
+
+	// Inactive slots must hold the empty commitment
+	empty, err := EmptyCommitment(circuit.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	nbAccounts := assertActiveFlags(api, circuit.Active)
+	for i, commitment := range circuit.Commitments {
+		assertInactiveSlotEmpty(api, circuit.Active[i], commitment.X, empty.X)
+		assertInactiveSlotEmpty(api, circuit.Active[i], commitment.Y, empty.Y)
+	}
+
+	// Sum all individual commitments. Pedersen commitments are additively
+	// homomorphic, so their sum commits to the total balance.
+	aggregateSum, err := aggregateCommitments(api, circuit.Scheme, circuit.Commitments)
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure the sum of all commitments matches the declared total commitment
-	api.AssertIsEqual(aggregateSum, circuit.TotalCommitment)
-	return nil
+	api.AssertIsEqual(aggregateSum.X, circuit.TotalCommitment.X)
+	api.AssertIsEqual(aggregateSum.Y, circuit.TotalCommitment.Y)
+	return nbAccounts, nil
 }
 
 // Implement io.WriterTo
@@ -225,7 +218,7 @@ func (w *AggregatedBalanceCircuit) ReadFrom(reader io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int64(len(w.Commitments) + 1), nil
+	return int64(2*len(w.Commitments) + len(w.Active) + 4), nil
 }
 
 // Implement encoding.BinaryMarshaler
@@ -240,12 +233,17 @@ func (w *AggregatedBalanceCircuit) UnmarshalBinary(data []byte) error {
 
 // Implement Public method
 func (w *AggregatedBalanceCircuit) Public() (witness.Witness, error) {
-	return &AggregatedBalanceCircuit{TotalCommitment: w.TotalCommitment}, nil
+	return &AggregatedBalanceCircuit{TotalCommitment: w.TotalCommitment, Epoch: w.Epoch, SnapshotDigest: w.SnapshotDigest}, nil
 }
 
 // Implement Vector method
 func (w *AggregatedBalanceCircuit) Vector() any {
-	return append(w.Commitments, []frontend.Variable{w.TotalCommitment}...)
+	vector := make([]frontend.Variable, 0, 3*len(w.Commitments)+4)
+	for _, commitment := range w.Commitments {
+		vector = append(vector, commitment.X, commitment.Y)
+	}
+	vector = append(vector, w.Active...)
+	return append(vector, w.TotalCommitment.X, w.TotalCommitment.Y, w.Epoch, w.SnapshotDigest)
 }
 
 // Implement ToJSON method
@@ -260,23 +258,118 @@ func (w *AggregatedBalanceCircuit) FromJSON(s *schema.Schema, data []byte) error
 
 // Implement Fill method
 func (w *AggregatedBalanceCircuit) Fill(nbPublic, nbSecret int, values <-chan any) error {
-	if nbSecret != 0 {
-		return errors.New("secret input not expected")
+	if nbSecret%3 != 0 {
+		return errors.New("expected three secret inputs per commitment")
+	}
+	if nbPublic != 4 {
+		return errors.New("expected 4 public inputs")
 	}
 
-	for i := 0; i < nbSecret; i++ {
+	w.Commitments = make([]twistededwards.Point, nbSecret/3)
+	for i := range w.Commitments {
+		x, ok := <-values
+		if !ok {
+			return errors.New("not enough values for commitments")
+		}
+		y, ok := <-values
+		if !ok {
+			return errors.New("not enough values for commitments")
+		}
+		w.Commitments[i] = twistededwards.Point{X: x.(frontend.Variable), Y: y.(frontend.Variable)}
+	}
+	w.Active = make([]frontend.Variable, nbSecret/3)
+	for i := range w.Active {
 		v, ok := <-values
 		if !ok {
-			return errors.New("not enough values for public commitments")
+			return errors.New("not enough values for active flags")
 		}
-		w.Commitments[i] = v.(frontend.Variable)
+		w.Active[i] = v.(frontend.Variable)
 	}
 
 	v, ok := <-values
 	if !ok {
-		return errors.New("not enough values for public total commitment")
+		return errors.New("not enough values for public total commitment x")
+	}
+	w.TotalCommitment.X = v.(frontend.Variable)
+
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public total commitment y")
+	}
+	w.TotalCommitment.Y = v.(frontend.Variable)
+
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public input epoch")
+	}
+	w.Epoch = v.(frontend.Variable)
+
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public input snapshot digest")
+	}
+	w.SnapshotDigest = v.(frontend.Variable)
+
+	return nil
+}
+
+// CommittedAggregatedBalanceCircuit proves the total balance behind a set of
+// individual commitments. Every commitment is recomputed in-circuit from the
+// account's balance, blinding and account hash, so the aggregate proof is
+// tied to the commitments the IndividualBalanceCircuit proofs were issued
+// against.
+type CommittedAggregatedBalanceCircuit struct {
+	Balances       []frontend.Variable   `gnark:"balances,secret"`
+	Blindings      []frontend.Variable   `gnark:"blindings,secret"`
+	AccountHashes  []frontend.Variable   `gnark:"account_hashes,secret"`
+	Commitments    [][]frontend.Variable `gnark:"commitments,public"` // As returned by PrecomputeCommitment
+	TotalBalance   frontend.Variable     `gnark:"total_balance,public"`
+	Epoch          frontend.Variable     `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest frontend.Variable     `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	BalanceBits    int                   `gnark:"-"`                      // Bit width of every balance (BALANCE_BITS if unset)
+	Scheme         CommitmentScheme      `gnark:"-"`                      // Scheme of the individual commitments
+}
+
+// NewCommittedAggregatedBalanceCircuit returns the circuit proving the total
+// balance behind nbAccounts commitments with the given scheme
+func NewCommittedAggregatedBalanceCircuit(nbAccounts int, scheme CommitmentScheme) *CommittedAggregatedBalanceCircuit {
+	circuit := &CommittedAggregatedBalanceCircuit{
+		Balances:      make([]frontend.Variable, nbAccounts),
+		Blindings:     make([]frontend.Variable, nbAccounts),
+		AccountHashes: make([]frontend.Variable, nbAccounts),
+		Commitments:   make([][]frontend.Variable, nbAccounts),
+		Scheme:        scheme,
+	}
+	for i := range circuit.Commitments {
+		circuit.Commitments[i] = make([]frontend.Variable, scheme.size())
+	}
+	return circuit
+}
+
+func (circuit *CommittedAggregatedBalanceCircuit) Define(api frontend.API) error {
+	nbAccounts := len(circuit.Commitments)
+	if len(circuit.Balances) != nbAccounts || len(circuit.Blindings) != nbAccounts || len(circuit.AccountHashes) != nbAccounts {
+		return errors.New("expected one balance, blinding and account hash per commitment")
+	}
+	balanceBits := balanceBitsOrDefault(circuit.BalanceBits)
+	if err := checkBalanceBitWidth(api, nbAccounts, balanceBits); err != nil {
+		return err
+	}
+
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	aggregateSum := frontend.Variable(0)
+	for i := 0; i < nbAccounts; i++ {
+		// Recompute the commitment, which also range-checks the balance
+		if err := assertCommitment(api, circuit.Scheme, circuit.Balances[i], circuit.Blindings[i], circuit.AccountHashes[i], balanceBits, circuit.Commitments[i]); err != nil {
+			return err
+		}
+
+		aggregateSum = api.Add(aggregateSum, circuit.Balances[i])
 	}
-	w.TotalCommitment = v.(frontend.Variable)
 
+	// Ensure the sum of the committed balances matches the declared total
+	api.AssertIsEqual(aggregateSum, circuit.TotalBalance)
 	return nil
 }
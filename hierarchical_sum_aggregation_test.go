@@ -2,50 +2,75 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"math/rand/v2"
 	"testing"
 
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
 )
 
-func createIndividualBalanceWitnesses() (*[]IndividualBalanceCircuit, *[]witness.Witness, *[]witness.Witness, error) {
+// testNbCommittedAccounts returns NB_COMMITTED_ACCOUNTS, or a smaller number
+// of accounts with -short since the aggregate circuits recompute or sum every
+// commitment
+func testNbCommittedAccounts() int {
+	if testing.Short() {
+		return 16
+	}
+	return NB_COMMITTED_ACCOUNTS
+}
+
+func createIndividualBalanceWitnesses(scheme CommitmentScheme) (*[]IndividualBalanceCircuit, *[]witness.Witness, *[]witness.Witness, error) {
+	return createIndividualBalanceWitnessesOnCurve(ecc.BLS12_381, scheme)
+}
 
+func createIndividualBalanceWitnessesOnCurve(curve ecc.ID, scheme CommitmentScheme) (*[]IndividualBalanceCircuit, *[]witness.Witness, *[]witness.Witness, error) {
+
+	nbAccounts := testNbCommittedAccounts()
 	var err error
 
-	circuits := make([]IndividualBalanceCircuit, NB_ACCOUNTS)
-	fullWitnesses := make([]witness.Witness, NB_ACCOUNTS)
-	publicWitnesses := make([]witness.Witness, NB_ACCOUNTS)
-	for i := 0; i < NB_ACCOUNTS; i++ {
+	circuits := make([]IndividualBalanceCircuit, nbAccounts)
+	fullWitnesses := make([]witness.Witness, nbAccounts)
+	publicWitnesses := make([]witness.Witness, nbAccounts)
+	for i := 0; i < nbAccounts; i++ {
 		// Generate random balances
 
 		balance := big.NewInt(int64(rand.Int64()))
-		blinding := big.NewInt(int64(1))
-		accountHash := hashToBigInt(hashEthereumAddress(fmt.Sprintf("0x%064x", rand.Int64())))
-		// Compute the commitment: commitment = balance * blinding + accountHash
-		commitment := new(big.Int)
-		commitment.Mul(balance, blinding)       // commitment = balance * blinding
-		commitment.Add(commitment, accountHash) // commitment += accountHash
+		var blinding *big.Int
+		blinding, err = RandomBlindingOnCurve(curve)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		accountHash := AccountHashOnCurve(curve, fmt.Sprintf("0x%064x", rand.Int64()))
+		// Compute the commitment with the selected scheme
+		var commitment []frontend.Variable
+		commitment, err = PrecomputeCommitmentOnCurve(curve, scheme, balance, blinding, accountHash)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 
 		circuits[i] = IndividualBalanceCircuit{
-			Balance:     balance,
-			Blinding:    blinding,
-			AccountHash: accountHash,
-			Commitment:  commitment,
+			Balance:        balance,
+			Blinding:       blinding,
+			AccountHash:    accountHash,
+			Commitment:     commitment,
+			Epoch:          testEpoch,
+			SnapshotDigest: testSnapshotDigest,
+			Scheme:         scheme,
 		}
 
-		fullWitnesses[i], err = frontend.NewWitness(&circuits[i], ecc.BLS12_381.ScalarField())
+		fullWitnesses[i], err = frontend.NewWitness(&circuits[i], curve.ScalarField())
 		if err != nil {
 			return nil, nil, nil, err
 		}
 
-		publicWitnesses[i], err = frontend.NewWitness(&circuits[i], ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+		publicWitnesses[i], err = frontend.NewWitness(&circuits[i], curve.ScalarField(), frontend.PublicOnly())
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -55,24 +80,31 @@ func createIndividualBalanceWitnesses() (*[]IndividualBalanceCircuit, *[]witness
 	return &circuits, &fullWitnesses, &publicWitnesses, nil
 }
 
-func createAggregatedBalanceWitnesses(individualCircuits *[]IndividualBalanceCircuit) (*witness.Witness, *witness.Witness, error) {
+func createAggregatedBalanceWitnesses(scheme CommitmentScheme, individualCircuits *[]IndividualBalanceCircuit) (*witness.Witness, *witness.Witness, error) {
 
+	nbAccounts := testNbCommittedAccounts()
 	var circuit *AggregatedBalanceCircuit
 	var err error
 
-	commitments := make([]frontend.Variable, NB_ACCOUNTS)
-	totalCommitment := big.NewInt(0)
-	for i := 0; i < NB_ACCOUNTS; i++ {
-		commitments[i] = (*individualCircuits)[i].Commitment
-		if commitments[i] == nil {
-			return nil, nil, fmt.Errorf("Commitment for index %d is nil", i)
+	commitments := make([]twistededwards.Point, nbAccounts)
+	for i := 0; i < nbAccounts; i++ {
+		commitments[i], err = CommitmentPoint((*individualCircuits)[i].Commitment)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Commitment for index %d: %w", i, err)
 		}
-		totalCommitment.Add(totalCommitment, variableToBigInt(commitments[i]))
+	}
+	totalCommitment, err := AggregateCommitments(scheme, commitments)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	circuit = &AggregatedBalanceCircuit{
 		Commitments:     commitments,
+		Active:          activeSlots(nbAccounts, nbAccounts),
 		TotalCommitment: totalCommitment,
+		Epoch:           testEpoch,
+		SnapshotDigest:  testSnapshotDigest,
+		Scheme:          scheme,
 	}
 
 	fullWitness, err := frontend.NewWitness(circuit, ecc.BLS12_381.ScalarField())
@@ -88,34 +120,74 @@ func createAggregatedBalanceWitnesses(individualCircuits *[]IndividualBalanceCir
 	return &fullWitness, &publicWitness, nil
 }
 
+func createCommittedAggregatedBalanceWitnesses(scheme CommitmentScheme, individualCircuits *[]IndividualBalanceCircuit) (*witness.Witness, *witness.Witness, error) {
+
+	nbAccounts := testNbCommittedAccounts()
+	circuit := NewCommittedAggregatedBalanceCircuit(nbAccounts, scheme)
+
+	// Reuse the openings of the commitments the individual proofs were issued against
+	totalBalance := big.NewInt(0)
+	for i := 0; i < nbAccounts; i++ {
+		individualCircuit := (*individualCircuits)[i]
+		circuit.Balances[i] = individualCircuit.Balance
+		circuit.Blindings[i] = individualCircuit.Blinding
+		circuit.AccountHashes[i] = individualCircuit.AccountHash
+		circuit.Commitments[i] = individualCircuit.Commitment
+		totalBalance.Add(totalBalance, variableToBigInt(individualCircuit.Balance))
+	}
+	circuit.TotalBalance = totalBalance
+	circuit.Epoch, circuit.SnapshotDigest = testEpoch, testSnapshotDigest
+
+	fullWitness, err := frontend.NewWitness(circuit, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicWitness, err := frontend.NewWitness(circuit, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &fullWitness, &publicWitness, nil
+}
+
 func TestIndividualBalanceProofsAndVerification(t *testing.T) {
+	for _, scheme := range []CommitmentScheme{PedersenScheme, MiMCScheme} {
+		t.Run(scheme.String(), func(t *testing.T) {
+			testIndividualBalanceProofsAndVerification(t, scheme)
+		})
+	}
+}
+
+func testIndividualBalanceProofsAndVerification(t *testing.T, scheme CommitmentScheme) {
 
+	nbAccounts := testNbCommittedAccounts()
 	var err error
 	var individualCircuit IndividualBalanceCircuit
 	var individualCircuits *[]IndividualBalanceCircuit
 	var aggregatedCircuit AggregatedBalanceCircuit
 	var cs constraint.ConstraintSystem
-	var pk groth16.ProvingKey
-	var vk groth16.VerifyingKey
+	var pk ProvingKey
+	var vk VerifyingKey
 	var fullWitnesses *[]witness.Witness
 	var publicWitnesses *[]witness.Witness
-	var proofs []groth16.Proof
+	var proofs []Proof
 	var fullWitness *witness.Witness
 	var publicWitness *witness.Witness
-	var aggregatedProof groth16.Proof
+	var aggregatedProof Proof
 
 	t.Run("CompileIndividualBalanceCircuitAndCompleteSetup", func(t *testing.T) {
 
 		// Define the circuit
-		individualCircuit = IndividualBalanceCircuit{}
+		individualCircuit = *NewIndividualBalanceCircuit(scheme)
 
-		cs, err = frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, &individualCircuit)
+		cs, err = testProvingBackend(t).Compile(&individualCircuit)
 		if err != nil {
 			t.Fatalf("Failed to compile circuit: %v", err)
 		}
 
 		// Generate the Groth16 keys
-		pk, vk, err = groth16.Setup(cs)
+		pk, vk, err = testProvingBackend(t).Setup(cs)
 		if err != nil {
 			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
 		}
@@ -130,17 +202,17 @@ func TestIndividualBalanceProofsAndVerification(t *testing.T) {
 		}
 
 		// Crete witness
-		individualCircuits, fullWitnesses, publicWitnesses, err = createIndividualBalanceWitnesses()
+		individualCircuits, fullWitnesses, publicWitnesses, err = createIndividualBalanceWitnesses(scheme)
 		if err != nil {
 			t.Fatalf("Failed to create witness: %v", err)
 		}
 		t.Log("Witnesses created", t)
 
 		// Generate proof
-		proofs = make([]groth16.Proof, NB_ACCOUNTS)
-		for i := 0; i < NB_ACCOUNTS; i++ {
+		proofs = make([]Proof, nbAccounts)
+		for i := 0; i < nbAccounts; i++ {
 
-			proofs[i], err = groth16.Prove(cs, pk, (*fullWitnesses)[i])
+			proofs[i], err = Prove(cs, pk, (*fullWitnesses)[i])
 			if err != nil {
 				t.Fatalf("Failed to generate proof: %v", err)
 			}
@@ -159,10 +231,10 @@ func TestIndividualBalanceProofsAndVerification(t *testing.T) {
 			t.Skip("Skipping because initialization or proof generation failed")
 		}
 
-		for i := 0; i < NB_ACCOUNTS; i++ {
+		for i := 0; i < nbAccounts; i++ {
 
 			// Verify proof
-			err = groth16.Verify(proofs[i], vk, (*publicWitnesses)[i])
+			err = Verify(proofs[i], vk, (*publicWitnesses)[i])
 			if err != nil {
 				t.Fatalf("Failed to verify proof #%v: %v", i, err)
 			}
@@ -175,20 +247,67 @@ func TestIndividualBalanceProofsAndVerification(t *testing.T) {
 
 	})
 
+	t.Run("BatchVerifyIndividualBalanceProofs", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization or proof generation failed")
+		}
+
+		err = BatchVerify(vk, proofs, *publicWitnesses)
+		if err != nil {
+			t.Fatalf("Failed to verify batch: %v", err)
+		}
+		t.Logf("All proofs verified in one batch successfully!")
+	})
+
+	t.Run("AggregateIndividualBalanceProofsWithSnarkPack", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization or proof generation failed")
+		}
+		if testProvingBackend(t).ID != Groth16Backend.ID {
+			t.Skip("SnarkPack aggregates Groth16 proofs")
+		}
+
+		srs, err := createSnarkPackSRS(nbAccounts)
+		if err != nil {
+			t.Fatalf("Failed to create SnarkPack SRS: %v", err)
+		}
+		snarkPackProof, err := AggregateGroth16Proofs(srs, vk, proofs, *publicWitnesses)
+		if err != nil {
+			t.Fatalf("Failed to aggregate proofs: %v", err)
+		}
+		// One verification instead of one per proof
+		err = VerifyAggregateGroth16Proof(srs.VerifyingKey(), vk, snarkPackProof, *publicWitnesses)
+		if err != nil {
+			t.Fatalf("Failed to verify aggregate proof: %v", err)
+		}
+		t.Logf("%d proofs aggregated in %d rounds and verified successfully!", nbAccounts, len(snarkPackProof.Rounds))
+	})
+
 	t.Run("CompileAggregatedBalanceCircuitAndCompleteSetup", func(t *testing.T) {
 
 		// Define the circuit
 		aggregatedCircuit = AggregatedBalanceCircuit{
-			Commitments: make([]frontend.Variable, NB_ACCOUNTS),
+			Commitments: make([]twistededwards.Point, nbAccounts),
+			Active:      make([]frontend.Variable, nbAccounts),
+			Scheme:      scheme,
 		}
 
-		cs, err = frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, &aggregatedCircuit)
+		cs, err = testProvingBackend(t).Compile(&aggregatedCircuit)
+		if scheme != PedersenScheme {
+			// Only Pedersen commitments sum to a commitment to the total balance
+			if !errors.Is(err, ErrNotHomomorphic) {
+				t.Fatalf("Expected ErrNotHomomorphic, got %v", err)
+			}
+			t.Skip("Hash commitments cannot be aggregated")
+		}
 		if err != nil {
 			t.Fatalf("Failed to compile aggregated circuit: %v", err)
 		}
 
 		// Generate the Groth16 keys
-		pk, vk, err = groth16.Setup(cs)
+		pk, vk, err = testProvingBackend(t).Setup(cs)
 		if err != nil {
 			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
 		}
@@ -201,16 +320,19 @@ func TestIndividualBalanceProofsAndVerification(t *testing.T) {
 		if t.Failed() {
 			t.Skip("Skipping because initialization failed")
 		}
+		if scheme != PedersenScheme {
+			t.Skip("Hash commitments cannot be aggregated")
+		}
 
 		// Crete witness
-		fullWitness, publicWitness, err = createAggregatedBalanceWitnesses(individualCircuits)
+		fullWitness, publicWitness, err = createAggregatedBalanceWitnesses(scheme, individualCircuits)
 		if err != nil {
 			t.Fatalf("Failed to create aggregated witness: %v", err)
 		}
 		t.Log("Aggregated witnesses created", t)
 
 		// Generate proof
-		aggregatedProof, err = groth16.Prove(cs, pk, *fullWitness)
+		aggregatedProof, err = Prove(cs, pk, *fullWitness)
 		if err != nil {
 			t.Fatalf("Failed to generate aggregated proof: %v", err)
 		}
@@ -223,13 +345,130 @@ func TestIndividualBalanceProofsAndVerification(t *testing.T) {
 		if t.Failed() {
 			t.Skip("Skipping because initialization or aggregated proof generation failed")
 		}
+		if scheme != PedersenScheme {
+			t.Skip("Hash commitments cannot be aggregated")
+		}
 
 		// Verify proof
-		err = groth16.Verify(aggregatedProof, vk, *publicWitness)
+		err = Verify(aggregatedProof, vk, *publicWitness)
 		if err != nil {
 			t.Fatalf("Failed to verify aggregated proof: %v", err)
 		}
 		t.Logf("Aggregated proof verified successfully!")
 	})
 
+	t.Run("CompileCommittedAggregatedBalanceCircuitAndCompleteSetup", func(t *testing.T) {
+
+		// Define the circuit
+		committedCircuit := NewCommittedAggregatedBalanceCircuit(nbAccounts, scheme)
+
+		cs, err = testProvingBackend(t).Compile(committedCircuit)
+		if err != nil {
+			t.Fatalf("Failed to compile committed aggregated circuit: %v", err)
+		}
+
+		// Generate the Groth16 keys
+		pk, vk, err = testProvingBackend(t).Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		t.Log("Committed aggregated circuit compiled and keys generated successfully!")
+
+	})
+
+	t.Run("CreateWitnessAndGenerateCommittedAggregatedBalanceProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization failed")
+		}
+
+		// Crete witness
+		fullWitness, publicWitness, err = createCommittedAggregatedBalanceWitnesses(scheme, individualCircuits)
+		if err != nil {
+			t.Fatalf("Failed to create committed aggregated witness: %v", err)
+		}
+
+		// Generate proof
+		aggregatedProof, err = Prove(cs, pk, *fullWitness)
+		if err != nil {
+			t.Fatalf("Failed to generate committed aggregated proof: %v", err)
+		}
+		t.Logf("Committed aggregated proof generated successfully!")
+	})
+
+	t.Run("VerifyCommittedAggregatedBalanceProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization or committed aggregated proof generation failed")
+		}
+
+		// Verify proof
+		err = Verify(aggregatedProof, vk, *publicWitness)
+		if err != nil {
+			t.Fatalf("Failed to verify committed aggregated proof: %v", err)
+		}
+		t.Logf("Committed aggregated proof verified successfully!")
+	})
+
+}
+
+func TestCommittedAggregatedBalanceRejectsInvalidOpenings(t *testing.T) {
+
+	const nbAccounts = 4
+
+	balances := make([]frontend.Variable, nbAccounts)
+	blindings := make([]frontend.Variable, nbAccounts)
+	accountHashes := make([]frontend.Variable, nbAccounts)
+	commitments := make([][]frontend.Variable, nbAccounts)
+	totalBalance := big.NewInt(0)
+	for i := 0; i < nbAccounts; i++ {
+		balance := big.NewInt(int64(rand.Int64()))
+		blinding := big.NewInt(int64(rand.Int64()))
+		accountHash := big.NewInt(int64(rand.Int64()))
+		commitment, err := PrecomputeCommitment(MiMCScheme, balance, blinding, accountHash)
+		if err != nil {
+			t.Fatalf("Failed to compute commitment: %v", err)
+		}
+		balances[i], blindings[i], accountHashes[i], commitments[i] = balance, blinding, accountHash, commitment
+		totalBalance.Add(totalBalance, balance)
+	}
+
+	// A balance that does not open its commitment, yet keeps the total
+	otherBalances := append([]frontend.Variable{}, balances...)
+	otherBalances[0] = new(big.Int).Add(variableToBigInt(balances[0]), big.NewInt(1))
+	otherBalances[1] = new(big.Int).Sub(variableToBigInt(balances[1]), big.NewInt(1))
+
+	testCases := []struct {
+		name         string
+		balances     []frontend.Variable
+		totalBalance *big.Int
+		valid        bool
+	}{
+		{"ValidOpenings", balances, totalBalance, true},
+		{"WrongTotalBalance", balances, new(big.Int).Add(totalBalance, big.NewInt(1)), false},
+		{"BalancesNotMatchingCommitments", otherBalances, totalBalance, false},
+	}
+
+	circuit := NewCommittedAggregatedBalanceCircuit(nbAccounts, MiMCScheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := CommittedAggregatedBalanceCircuit{
+				Balances:       tc.balances,
+				Blindings:      blindings,
+				AccountHashes:  accountHashes,
+				Commitments:    commitments,
+				TotalBalance:   tc.totalBalance,
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+			err := test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// InclusionProof is the package handed to a customer so they can check that
+// their account and balance are included in a published Merkle sum tree root.
+// It is stored as JSON, one file per account.
+type InclusionProof struct {
+	Leaf     MerkleSumLeaf   `json:"leaf"`
+	Index    int             `json:"index"`    // Position of the leaf in the tree
+	Siblings []MerkleSumNode `json:"siblings"` // Sibling of every node on the path, from the leaf up
+	Root     MerkleSumNode   `json:"root"`
+}
+
+// InclusionProof builds the inclusion proof of the leaf at index
+func (tree *MerkleSumTree) InclusionProof(index int) (*InclusionProof, error) {
+	if index < 0 || index >= len(tree.Leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := &InclusionProof{
+		Leaf:     tree.Leaves[index],
+		Index:    index,
+		Siblings: make([]MerkleSumNode, tree.Depth()),
+		Root:     tree.Root(),
+	}
+	position := index
+	for level := 0; level < tree.Depth(); level++ {
+		proof.Siblings[level] = tree.Levels[level][position^1]
+		position >>= 1
+	}
+	return proof, nil
+}
+
+// GenerateInclusionProofs builds the inclusion proof of every account of the
+// snapshot, leaving out the empty leaves
+func GenerateInclusionProofs(tree *MerkleSumTree) ([]*InclusionProof, error) {
+	proofs := make([]*InclusionProof, 0, tree.NbAccounts)
+	for i, leaf := range tree.Leaves {
+		if leaf.IsEmpty() {
+			continue
+		}
+		proof, err := tree.InclusionProof(i)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
+
+// inclusionProofFileName names the file of an account after its account hash,
+// so a customer finds it by hashing their address
+func inclusionProofFileName(accountHash *big.Int) string {
+	return fmt.Sprintf("%064x.json", accountHash)
+}
+
+// WriteInclusionProofs writes every proof to its own JSON file in directory
+func WriteInclusionProofs(directory string, proofs []*InclusionProof) error {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return err
+	}
+	for _, proof := range proofs {
+		data, err := json.MarshalIndent(proof, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(directory, inclusionProofFileName(proof.Leaf.AccountHash))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadInclusionProof reads the proof of an Ethereum address from directory
+func ReadInclusionProof(directory, address string) (*InclusionProof, error) {
+	return ReadInclusionProofOnCurve(ecc.BLS12_381, directory, address)
+}
+
+// ReadInclusionProofOnCurve reads the proof of an Ethereum address in a tree
+// hashed on curve from directory
+func ReadInclusionProofOnCurve(curve ecc.ID, directory, address string) (*InclusionProof, error) {
+	accountHash, err := accountHashOfAddressOnCurve(curve, address)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(directory, inclusionProofFileName(accountHash)))
+	if err != nil {
+		return nil, err
+	}
+	var proof InclusionProof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// BelongsTo tells whether the proof is about the account of an Ethereum
+// address. A malformed address is reported as an error.
+func (proof *InclusionProof) BelongsTo(address string) (bool, error) {
+	return proof.BelongsToOnCurve(ecc.BLS12_381, address)
+}
+
+// BelongsToOnCurve tells whether the proof of a tree hashed on curve is about
+// the account of an Ethereum address
+func (proof *InclusionProof) BelongsToOnCurve(curve ecc.ID, address string) (bool, error) {
+	accountHash, err := accountHashOfAddressOnCurve(curve, address)
+	if err != nil {
+		return false, err
+	}
+	return proof.Leaf.AccountHash != nil && proof.Leaf.AccountHash.Cmp(accountHash) == 0, nil
+}
+
+// VerifyInclusionProof recomputes the path from the leaf up to the root and
+// checks it against the published root. It does not need anything but the
+// proof and the root, so customers can run it on their own.
+func VerifyInclusionProof(proof *InclusionProof, root MerkleSumNode) error {
+	return VerifyInclusionProofOnCurve(ecc.BLS12_381, proof, root)
+}
+
+// VerifyInclusionProofOnCurve verifies the inclusion proof of a tree hashed
+// on curve
+func VerifyInclusionProofOnCurve(curve ecc.ID, proof *InclusionProof, root MerkleSumNode) error {
+	if proof.Leaf.AccountHash == nil || proof.Leaf.Salt == nil || proof.Leaf.Balance == nil {
+		return errors.New("incomplete leaf")
+	}
+	if root.Hash == nil || root.Sum == nil {
+		return errors.New("incomplete root")
+	}
+	if err := checkAccountHash(proof.Leaf.AccountHash, curve.ScalarField()); err != nil {
+		return err
+	}
+	if proof.Index < 0 || proof.Index >= 1<<len(proof.Siblings) {
+		return fmt.Errorf("leaf index %d out of range", proof.Index)
+	}
+
+	if proof.Leaf.Balance.Sign() < 0 || proof.Leaf.Balance.BitLen() > BALANCE_BITS {
+		return fmt.Errorf("balance does not fit in %d bits", BALANCE_BITS)
+	}
+
+	node, err := proof.Leaf.NodeOnCurve(curve)
+	if err != nil {
+		return err
+	}
+	position := proof.Index
+	for level, sibling := range proof.Siblings {
+		if sibling.Hash == nil || sibling.Sum == nil {
+			return fmt.Errorf("incomplete sibling at level %d", level)
+		}
+		// A negative sibling sum would hide part of the liabilities, and so
+		// would a sum too large to add up from the 2^level balances below the
+		// sibling, which could wrap around the scalar field
+		if sibling.Sum.Sign() < 0 {
+			return fmt.Errorf("negative sibling sum at level %d", level)
+		}
+		if sibling.Sum.BitLen() > BALANCE_BITS+level {
+			return fmt.Errorf("sibling sum at level %d does not fit in %d bits", level, BALANCE_BITS+level)
+		}
+		if position&1 == 0 {
+			node, err = parentMerkleSumNode(curve, node, sibling)
+		} else {
+			node, err = parentMerkleSumNode(curve, sibling, node)
+		}
+		if err != nil {
+			return err
+		}
+		position >>= 1
+	}
+
+	if node.Hash.Cmp(root.Hash) != 0 {
+		return errors.New("the path does not lead to the published root hash")
+	}
+	if node.Sum.Cmp(root.Sum) != 0 {
+		return errors.New("the path does not add up to the published total")
+	}
+	return nil
+}
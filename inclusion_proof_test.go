@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestInclusionProofsGenerationAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	addresses := make([]string, nbAccounts-3)
+	leaves := make([]MerkleSumLeaf, len(addresses))
+	for i := range addresses {
+		addresses[i] = randomEthereumAddress()
+		leaf, err := NewMerkleSumLeaf(addresses[i], big.NewInt(int64(rand.Int64())))
+		if err != nil {
+			t.Fatalf("Failed to build leaf: %v", err)
+		}
+		leaves[i] = leaf
+	}
+	tree, err := NewMerkleSumTree(leaves)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	publishedRoot := tree.Root()
+
+	proofs, err := GenerateInclusionProofs(tree)
+	if err != nil {
+		t.Fatalf("Failed to generate inclusion proofs: %v", err)
+	}
+	if len(proofs) != len(addresses) {
+		t.Fatalf("Expected %d inclusion proofs, got %d", len(addresses), len(proofs))
+	}
+
+	directory := t.TempDir()
+	if err := WriteInclusionProofs(directory, proofs); err != nil {
+		t.Fatalf("Failed to write inclusion proofs: %v", err)
+	}
+
+	// Every customer reads back their own package and checks it
+	for i, address := range addresses {
+		proof, err := ReadInclusionProof(directory, address)
+		if err != nil {
+			t.Fatalf("Failed to read inclusion proof of %s: %v", address, err)
+		}
+		if belongs, err := proof.BelongsTo(address); err != nil || !belongs {
+			t.Fatalf("Inclusion proof of %s belongs to another account (%v)", address, err)
+		}
+		if proof.Leaf.Balance.Cmp(leaves[i].Balance) != 0 {
+			t.Fatalf("Inclusion proof of %s holds balance %v instead of %v", address, proof.Leaf.Balance, leaves[i].Balance)
+		}
+		if err := VerifyInclusionProof(proof, publishedRoot); err != nil {
+			t.Fatalf("Failed to verify inclusion proof of %s: %v", address, err)
+		}
+	}
+	t.Log("All inclusion proofs verified successfully!")
+
+	// A malformed address is an error rather than a crash
+	for _, address := range []string{"0xnot-an-address", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA"} {
+		if _, err := ReadInclusionProof(directory, address); err == nil {
+			t.Fatalf("Expected an error reading the inclusion proof of %s", address)
+		}
+		if _, err := proofs[0].BelongsTo(address); err == nil {
+			t.Fatalf("Expected an error matching the inclusion proof against %s", address)
+		}
+	}
+}
+
+func TestInclusionProofRejectsTampering(t *testing.T) {
+
+	tree, err := createMerkleSumTree(4)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	publishedRoot := tree.Root()
+
+	testCases := []struct {
+		name   string
+		tamper func(proof *InclusionProof, root *MerkleSumNode)
+		valid  bool
+	}{
+		{"ValidProof", func(proof *InclusionProof, root *MerkleSumNode) {}, true},
+		{"InflatedBalance", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Leaf.Balance = new(big.Int).Add(proof.Leaf.Balance, big.NewInt(1))
+		}, false},
+		{"WrongSalt", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Leaf.Salt = new(big.Int).Add(proof.Leaf.Salt, big.NewInt(1))
+		}, false},
+		{"WrongIndex", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Index ^= 1
+		}, false},
+		{"NegativeSiblingSum", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Siblings[0].Sum = new(big.Int).Neg(proof.Siblings[0].Sum)
+		}, false},
+		{"SiblingSumAboveItsBitWidth", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Siblings[0].Sum = new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS)
+		}, false},
+		// The sibling sum wraps around the scalar field to cancel the balance
+		// of the leaf, keeping the root sum
+		{"WrappedSiblingSum", func(proof *InclusionProof, root *MerkleSumNode) {
+			proof.Siblings[0].Sum = new(big.Int).Sub(ecc.BLS12_381.ScalarField(), proof.Leaf.Balance)
+		}, false},
+		{"OtherRoot", func(proof *InclusionProof, root *MerkleSumNode) {
+			root.Sum = new(big.Int).Add(root.Sum, big.NewInt(1))
+		}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proof, err := tree.InclusionProof(2)
+			if err != nil {
+				t.Fatalf("Failed to generate inclusion proof: %v", err)
+			}
+			// Work on copies so that test cases do not affect each other
+			proof.Leaf = MerkleSumLeaf{AccountHash: proof.Leaf.AccountHash, Salt: proof.Leaf.Salt, Balance: new(big.Int).Set(proof.Leaf.Balance)}
+			proof.Siblings = append([]MerkleSumNode{}, proof.Siblings...)
+			root := MerkleSumNode{Hash: publishedRoot.Hash, Sum: new(big.Int).Set(publishedRoot.Sum)}
+
+			tc.tamper(proof, &root)
+			err = VerifyInclusionProof(proof, root)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the inclusion proof to verify: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the inclusion proof not to verify")
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// MerkleSumLeaf is an account as committed to in a Merkle sum tree. Its leaf
+// node is (MiMC(accountHash, salt), balance): the salt keeps the account
+// identifier from being recognised by whoever sees the leaf in a sibling path.
+type MerkleSumLeaf struct {
+	AccountHash *big.Int `json:"account_hash"`
+	Salt        *big.Int `json:"salt"`
+	Balance     *big.Int `json:"balance"`
+}
+
+// MerkleSumNode is a node of a Merkle sum tree: a hash and the sum of the
+// balances of the leaves below it. An internal node hashes both children with
+// their sums, MiMC(left.Hash, left.Sum, right.Hash, right.Sum).
+type MerkleSumNode struct {
+	Hash *big.Int `json:"hash"`
+	Sum  *big.Int `json:"sum"`
+}
+
+// MerkleSumTree is the native Merkle sum tree over a snapshot of accounts,
+// padded to a power of two with empty leaves
+type MerkleSumTree struct {
+	Leaves     []MerkleSumLeaf
+	Levels     [][]MerkleSumNode // Levels[0] holds the leaf nodes, the last level the root
+	NbAccounts int               // Number of leaves that are not empty
+	Curve      ecc.ID            // Curve whose scalar field the nodes are hashed in
+}
+
+// NewMerkleSumLeaf builds the leaf of an Ethereum address with a fresh random salt
+func NewMerkleSumLeaf(address string, balance *big.Int) (MerkleSumLeaf, error) {
+	return NewMerkleSumLeafOnCurve(ecc.BLS12_381, address, balance)
+}
+
+// NewMerkleSumLeafOnCurve builds the leaf of an Ethereum address in a tree
+// hashed on curve
+func NewMerkleSumLeafOnCurve(curve ecc.ID, address string, balance *big.Int) (MerkleSumLeaf, error) {
+	accountHash, err := accountHashOfAddressOnCurve(curve, address)
+	if err != nil {
+		return MerkleSumLeaf{}, err
+	}
+	salt, err := RandomBlindingOnCurve(curve)
+	if err != nil {
+		return MerkleSumLeaf{}, err
+	}
+	return MerkleSumLeaf{
+		AccountHash: accountHash,
+		Salt:        salt,
+		Balance:     balance,
+	}, nil
+}
+
+// emptyMerkleSumLeaf pads the tree to a power of two. It holds no balance.
+func emptyMerkleSumLeaf() MerkleSumLeaf {
+	return MerkleSumLeaf{AccountHash: big.NewInt(0), Salt: big.NewInt(0), Balance: big.NewInt(0)}
+}
+
+// IsEmpty tells whether the leaf is a padding leaf
+func (leaf MerkleSumLeaf) IsEmpty() bool {
+	return leaf.AccountHash.Sign() == 0 && leaf.Salt.Sign() == 0 && leaf.Balance.Sign() == 0
+}
+
+// Node computes the leaf node (MiMC(accountHash, salt), balance)
+func (leaf MerkleSumLeaf) Node() (MerkleSumNode, error) {
+	return leaf.NodeOnCurve(ecc.BLS12_381)
+}
+
+// NodeOnCurve computes the leaf node in a tree hashed on curve
+func (leaf MerkleSumLeaf) NodeOnCurve(curve ecc.ID) (MerkleSumNode, error) {
+	hash, err := mimcHashOnCurve(curve, leaf.AccountHash, leaf.Salt)
+	if err != nil {
+		return MerkleSumNode{}, err
+	}
+	return MerkleSumNode{Hash: hash, Sum: new(big.Int).Set(leaf.Balance)}, nil
+}
+
+// parentMerkleSumNode computes the internal node above two children in a
+// tree hashed on curve
+func parentMerkleSumNode(curve ecc.ID, left, right MerkleSumNode) (MerkleSumNode, error) {
+	hash, err := mimcHashOnCurve(curve, left.Hash, left.Sum, right.Hash, right.Sum)
+	if err != nil {
+		return MerkleSumNode{}, err
+	}
+	return MerkleSumNode{Hash: hash, Sum: new(big.Int).Add(left.Sum, right.Sum)}, nil
+}
+
+// NewMerkleSumTree builds the Merkle sum tree over the leaves. Balances must
+// be non-negative and fit in BALANCE_BITS bits, like in the circuit.
+func NewMerkleSumTree(leaves []MerkleSumLeaf) (*MerkleSumTree, error) {
+	return NewMerkleSumTreeOnCurve(ecc.BLS12_381, leaves)
+}
+
+// NewMerkleSumTreeOnCurve builds the Merkle sum tree over the leaves hashed on
+// curve, for a MerkleSumTreeCircuit compiled on that curve
+func NewMerkleSumTreeOnCurve(curve ecc.ID, leaves []MerkleSumLeaf) (*MerkleSumTree, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("a Merkle sum tree needs at least one leaf")
+	}
+	return NewMerkleSumTreeWithCapacityOnCurve(curve, leaves, len(leaves))
+}
+
+// NewMerkleSumTreeWithCapacity builds the Merkle sum tree over the leaves with
+// room for at least capacity accounts, so that later epochs can insert
+// accounts without changing the depth of the tree
+func NewMerkleSumTreeWithCapacity(leaves []MerkleSumLeaf, capacity int) (*MerkleSumTree, error) {
+	return NewMerkleSumTreeWithCapacityOnCurve(ecc.BLS12_381, leaves, capacity)
+}
+
+// NewMerkleSumTreeWithCapacityOnCurve builds the Merkle sum tree over the
+// leaves hashed on curve with room for at least capacity accounts
+func NewMerkleSumTreeWithCapacityOnCurve(curve ecc.ID, leaves []MerkleSumLeaf, capacity int) (*MerkleSumTree, error) {
+	if capacity < 1 || capacity < len(leaves) {
+		return nil, fmt.Errorf("cannot fit %d leaves in a capacity of %d", len(leaves), capacity)
+	}
+	for i, leaf := range leaves {
+		if leaf.Balance.Sign() < 0 || leaf.Balance.BitLen() > BALANCE_BITS {
+			return nil, fmt.Errorf("balance of leaf %d does not fit in %d bits", i, BALANCE_BITS)
+		}
+		if err := checkAccountHash(leaf.AccountHash, curve.ScalarField()); err != nil {
+			return nil, fmt.Errorf("leaf %d: %w", i, err)
+		}
+	}
+
+	nbLeaves := 1 << bits.Len(uint(capacity-1))
+	tree := &MerkleSumTree{Leaves: make([]MerkleSumLeaf, nbLeaves), NbAccounts: len(leaves), Curve: curve}
+	copy(tree.Leaves, leaves)
+	for i := len(leaves); i < nbLeaves; i++ {
+		tree.Leaves[i] = emptyMerkleSumLeaf()
+	}
+
+	level := make([]MerkleSumNode, nbLeaves)
+	for i, leaf := range tree.Leaves {
+		node, err := leaf.NodeOnCurve(curve)
+		if err != nil {
+			return nil, err
+		}
+		level[i] = node
+	}
+	tree.Levels = append(tree.Levels, level)
+
+	for len(level) > 1 {
+		parents := make([]MerkleSumNode, len(level)/2)
+		for i := range parents {
+			parent, err := parentMerkleSumNode(curve, level[2*i], level[2*i+1])
+			if err != nil {
+				return nil, err
+			}
+			parents[i] = parent
+		}
+		tree.Levels = append(tree.Levels, parents)
+		level = parents
+	}
+
+	return tree, nil
+}
+
+// Root returns the root node, whose sum is the total of all balances
+func (tree *MerkleSumTree) Root() MerkleSumNode {
+	return tree.Levels[len(tree.Levels)-1][0]
+}
+
+// Depth returns the number of levels above the leaves
+func (tree *MerkleSumTree) Depth() int {
+	return len(tree.Levels) - 1
+}
+
+// Assignment returns the witness assignment of MerkleSumTreeCircuit for the tree
+func (tree *MerkleSumTree) Assignment() *MerkleSumTreeCircuit {
+	circuit := &MerkleSumTreeCircuit{
+		AccountHashes: make([]frontend.Variable, len(tree.Leaves)),
+		Salts:         make([]frontend.Variable, len(tree.Leaves)),
+		Balances:      make([]frontend.Variable, len(tree.Leaves)),
+		RootHash:      tree.Root().Hash,
+		TotalSum:      tree.Root().Sum,
+	}
+	for i, leaf := range tree.Leaves {
+		circuit.AccountHashes[i] = leaf.AccountHash
+		circuit.Salts[i] = leaf.Salt
+		circuit.Balances[i] = leaf.Balance
+	}
+	return circuit
+}
+
+// MerkleSumTreeCircuit proves that the public root commits to all the leaves
+// and that the root sum equals the published total, the standard proof of
+// liabilities. The number of leaves must be a power of two.
+type MerkleSumTreeCircuit struct {
+	AccountHashes []frontend.Variable `gnark:"account_hashes,secret"`
+	Salts         []frontend.Variable `gnark:"salts,secret"`
+	Balances      []frontend.Variable `gnark:"balances,secret"`
+	RootHash      frontend.Variable   `gnark:"root_hash,public"`
+	TotalSum      frontend.Variable   `gnark:"total_sum,public"`
+	BalanceBits   int                 `gnark:"-"` // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+func (circuit *MerkleSumTreeCircuit) Define(api frontend.API) error {
+	rootHash, totalSum, err := merkleSumRoot(api, circuit.AccountHashes, circuit.Salts, circuit.Balances, balanceBitsOrDefault(circuit.BalanceBits))
+	if err != nil {
+		return err
+	}
+
+	// Ensure the root matches the published root and total
+	api.AssertIsEqual(rootHash, circuit.RootHash)
+	api.AssertIsEqual(totalSum, circuit.TotalSum)
+	return nil
+}
+
+// merkleSumRoot computes in-circuit the root hash and sum of the Merkle sum
+// tree over the leaves. Every balance is range-checked to balanceBits bits so
+// that no sum along the tree can wrap around.
+func merkleSumRoot(api frontend.API, accountHashes, salts, balances []frontend.Variable, balanceBits int) (rootHash, rootSum frontend.Variable, err error) {
+	nbLeaves := len(balances)
+	if nbLeaves == 0 || nbLeaves&(nbLeaves-1) != 0 {
+		return nil, nil, errors.New("the number of leaves must be a power of two")
+	}
+	if len(accountHashes) != nbLeaves || len(salts) != nbLeaves {
+		return nil, nil, errors.New("expected one account hash and salt per balance")
+	}
+	if err := assertBalancesInRange(api, balances, balanceBits); err != nil {
+		return nil, nil, err
+	}
+
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashNode := func(data ...frontend.Variable) frontend.Variable {
+		hash.Reset()
+		hash.Write(data...)
+		return hash.Sum()
+	}
+
+	// Leaf nodes are (MiMC(accountHash, salt), balance)
+	hashes := make([]frontend.Variable, nbLeaves)
+	sums := make([]frontend.Variable, nbLeaves)
+	for i := 0; i < nbLeaves; i++ {
+		hashes[i] = hashNode(accountHashes[i], salts[i])
+		sums[i] = balances[i]
+	}
+
+	// Each internal node hashes its children with their sums
+	for len(hashes) > 1 {
+		for i := 0; i < len(hashes)/2; i++ {
+			left, right := 2*i, 2*i+1
+			hashes[i] = hashNode(hashes[left], sums[left], hashes[right], sums[right])
+			sums[i] = api.Add(sums[left], sums[right])
+		}
+		hashes = hashes[:len(hashes)/2]
+		sums = sums[:len(sums)/2]
+	}
+	return hashes[0], sums[0], nil
+}
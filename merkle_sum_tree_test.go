@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math/big"
+	"math/bits"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+func createMerkleSumTree(nbAccounts int) (*MerkleSumTree, error) {
+	return createMerkleSumTreeOnCurve(ecc.BLS12_381, nbAccounts)
+}
+
+func createMerkleSumTreeOnCurve(curve ecc.ID, nbAccounts int) (*MerkleSumTree, error) {
+	leaves := make([]MerkleSumLeaf, nbAccounts)
+	for i := range leaves {
+		leaf, err := NewMerkleSumLeafOnCurve(curve, randomEthereumAddress(), big.NewInt(int64(rand.Int64())))
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return NewMerkleSumTreeOnCurve(curve, leaves)
+}
+
+// uncheckedMerkleSumRoot computes the BLS12-381 root over a power of two of
+// leaves the way NewMerkleSumTree does, but without checking the balances
+func uncheckedMerkleSumRoot(leaves []MerkleSumLeaf) (MerkleSumNode, error) {
+	level := make([]MerkleSumNode, len(leaves))
+	for i, leaf := range leaves {
+		node, err := leaf.Node()
+		if err != nil {
+			return MerkleSumNode{}, err
+		}
+		level[i] = node
+	}
+	for len(level) > 1 {
+		for i := 0; i < len(level)/2; i++ {
+			parent, err := parentMerkleSumNode(ecc.BLS12_381, level[2*i], level[2*i+1])
+			if err != nil {
+				return MerkleSumNode{}, err
+			}
+			level[i] = parent
+		}
+		level = level[:len(level)/2]
+	}
+	return level[0], nil
+}
+
+func TestMerkleSumTreeProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	var err error
+	var tree *MerkleSumTree
+	var cs constraint.ConstraintSystem
+	var pk ProvingKey
+	var vk VerifyingKey
+	var fw witness.Witness
+	var pw witness.Witness
+	var proof Proof
+
+	t.Run("BuildTree", func(t *testing.T) {
+
+		// Leave some room so that the tree gets padded with empty leaves
+		tree, err = createMerkleSumTree(nbAccounts - 3)
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+
+		totalSum := big.NewInt(0)
+		for _, leaf := range tree.Leaves {
+			totalSum.Add(totalSum, leaf.Balance)
+		}
+		if tree.Root().Sum.Cmp(totalSum) != 0 {
+			t.Fatalf("Root sum %v does not match the total balance %v", tree.Root().Sum, totalSum)
+		}
+		// The leaves are padded to the next power of two
+		if nbLeaves := 1 << bits.Len(uint(nbAccounts-1)); len(tree.Leaves) != nbLeaves {
+			t.Fatalf("Expected the tree to be padded to %d leaves, got %d", nbLeaves, len(tree.Leaves))
+		}
+	})
+
+	t.Run("CompileCircuitAndCompleteSetup", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because the tree could not be built")
+		}
+
+		// Define the circuit
+		circuit := MerkleSumTreeCircuit{
+			AccountHashes: make([]frontend.Variable, len(tree.Leaves)),
+			Salts:         make([]frontend.Variable, len(tree.Leaves)),
+			Balances:      make([]frontend.Variable, len(tree.Leaves)),
+		}
+
+		cs, err = testProvingBackend(t).Compile(&circuit)
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+
+		// Generate the Groth16 keys
+		pk, vk, err = testProvingBackend(t).Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		t.Log("Circuit compiled and keys generated successfully!")
+	})
+
+	t.Run("CreateWitnessAndGenerateProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization failed")
+		}
+
+		fw, err = frontend.NewWitness(tree.Assignment(), ecc.BLS12_381.ScalarField())
+		if err != nil {
+			t.Fatalf("Failed to create witness: %v", err)
+		}
+		pw, err = fw.Public()
+		if err != nil {
+			t.Fatalf("Failed to create public witness: %v", err)
+		}
+
+		proof, err = Prove(cs, pk, fw)
+		if err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		t.Log("Proof generated successfully!")
+	})
+
+	t.Run("VerifyProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization or proof generation failed")
+		}
+
+		err = Verify(proof, vk, pw)
+		if err != nil {
+			t.Fatalf("Failed to verify proof: %v", err)
+		}
+		t.Log("Proof verified successfully!")
+	})
+}
+
+func TestMerkleSumTreeRejectsInvalidTrees(t *testing.T) {
+
+	tree, err := createMerkleSumTree(4)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+
+	if _, err := NewMerkleSumLeaf("0xnot-an-address", big.NewInt(1)); err == nil || !strings.Contains(err.Error(), "0xnot-an-address") {
+		t.Fatalf("Expected an error naming the malformed address, got %v", err)
+	}
+
+	// A tree whose root was honestly computed over a wrapped-around "negative"
+	// balance, which lowers the published total
+	negativeBalance := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(5))
+	negativeLeaves := append([]MerkleSumLeaf{}, tree.Leaves...)
+	negativeLeaves[1] = MerkleSumLeaf{AccountHash: tree.Leaves[1].AccountHash, Salt: tree.Leaves[1].Salt, Balance: negativeBalance}
+	negativeRoot, err := uncheckedMerkleSumRoot(negativeLeaves)
+	if err != nil {
+		t.Fatalf("Failed to hash tree: %v", err)
+	}
+	negativeTree := &MerkleSumTree{Leaves: negativeLeaves, Levels: [][]MerkleSumNode{{negativeRoot}}}
+	negativeAssignment := negativeTree.Assignment()
+	negativeAssignment.TotalSum = new(big.Int).Mod(negativeRoot.Sum, ecc.BLS12_381.ScalarField())
+
+	wrongTotal := tree.Assignment()
+	wrongTotal.TotalSum = new(big.Int).Add(tree.Root().Sum, big.NewInt(1))
+
+	wrongBalance := tree.Assignment()
+	wrongBalance.Balances[2] = new(big.Int).Add(tree.Leaves[2].Balance, big.NewInt(1))
+	wrongBalance.TotalSum = new(big.Int).Add(tree.Root().Sum, big.NewInt(1))
+
+	testCases := []struct {
+		name       string
+		assignment *MerkleSumTreeCircuit
+		valid      bool
+	}{
+		{"ValidTree", tree.Assignment(), true},
+		{"WrongTotalSum", wrongTotal, false},
+		{"BalanceNotCommittedByRoot", wrongBalance, false},
+		{"WrappedNegativeBalance", negativeAssignment, false},
+	}
+
+	circuit := MerkleSumTreeCircuit{
+		AccountHashes: make([]frontend.Variable, 4),
+		Salts:         make([]frontend.Variable, 4),
+		Balances:      make([]frontend.Variable, 4),
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := test.IsSolved(&circuit, tc.assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestMerkleSumTreeOnBN254(t *testing.T) {
+
+	provingBackend := Groth16Backend.OnCurve(ecc.BN254)
+	tree, err := createMerkleSumTreeOnCurve(ecc.BN254, 4)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+
+	// The tree is hashed in the scalar field of BN254, so its paths only
+	// verify on that curve
+	proofs, err := GenerateInclusionProofs(tree)
+	if err != nil {
+		t.Fatalf("Failed to generate inclusion proofs: %v", err)
+	}
+	for _, proof := range proofs {
+		if err := VerifyInclusionProofOnCurve(ecc.BN254, proof, tree.Root()); err != nil {
+			t.Fatalf("Failed to verify inclusion proof: %v", err)
+		}
+		if err := VerifyInclusionProofOnCurve(ecc.BLS12_381, proof, tree.Root()); err == nil {
+			t.Fatal("Expected the inclusion proof not to verify on BLS12-381")
+		}
+	}
+
+	circuit := MerkleSumTreeCircuit{
+		AccountHashes: make([]frontend.Variable, len(tree.Leaves)),
+		Salts:         make([]frontend.Variable, len(tree.Leaves)),
+		Balances:      make([]frontend.Variable, len(tree.Leaves)),
+	}
+	cs, err := provingBackend.Compile(&circuit)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+	fw, err := provingBackend.NewWitness(tree.Assignment())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+}
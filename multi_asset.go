@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Asset identifies an asset by its ticker, such as "BTC" or "USDC"
+type Asset string
+
+// AssetBalance is one entry of asset-tagged input data: the balance an
+// account holds in one asset, in the smallest unit of the asset
+type AssetBalance struct {
+	Address string   `json:"address"`
+	Asset   Asset    `json:"asset"`
+	Balance *big.Int `json:"balance"`
+}
+
+// ReadAssetBalances reads asset-tagged input data, a JSON array of entries
+func ReadAssetBalances(reader io.Reader) ([]AssetBalance, error) {
+	var entries []AssetBalance
+	if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MultiAssetSumAggregationCircuit proves the total of every asset of a
+// configurable asset list. Each account holds one balance per asset and
+// every balance is range-checked.
+type MultiAssetSumAggregationCircuit struct {
+	Balances    [][]frontend.Variable `gnark:"balances,secret"`   // Balances[account][asset]
+	TotalSums   []frontend.Variable   `gnark:"total_sums,public"` // One total per asset
+	BalanceBits int                   `gnark:"-"`                 // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+// NewMultiAssetSumAggregationCircuit returns the circuit for nbAccounts
+// accounts holding nbAssets assets
+func NewMultiAssetSumAggregationCircuit(nbAccounts, nbAssets int) *MultiAssetSumAggregationCircuit {
+	circuit := &MultiAssetSumAggregationCircuit{
+		Balances:  make([][]frontend.Variable, nbAccounts),
+		TotalSums: make([]frontend.Variable, nbAssets),
+	}
+	for i := range circuit.Balances {
+		circuit.Balances[i] = make([]frontend.Variable, nbAssets)
+	}
+	return circuit
+}
+
+// NewMultiAssetAssignment builds the witness assignment of a circuit of
+// nbAccounts accounts over the asset list from asset-tagged entries. Accounts
+// are laid out in the order they first appear, an account holds a zero
+// balance in the assets it has no entry for, and the remaining accounts are
+// padded with zero balances.
+func NewMultiAssetAssignment(assets []Asset, entries []AssetBalance, nbAccounts int) (*MultiAssetSumAggregationCircuit, error) {
+	assetIndexes := make(map[Asset]int, len(assets))
+	for i, asset := range assets {
+		if _, ok := assetIndexes[asset]; ok {
+			return nil, fmt.Errorf("asset %s is listed twice", asset)
+		}
+		assetIndexes[asset] = i
+	}
+
+	balances := [][]*big.Int{}
+	accountIndexes := make(map[string]int)
+	for _, entry := range entries {
+		assetIndex, ok := assetIndexes[entry.Asset]
+		if !ok {
+			return nil, fmt.Errorf("account %s holds unlisted asset %s", entry.Address, entry.Asset)
+		}
+		if entry.Balance == nil || entry.Balance.Sign() < 0 || entry.Balance.BitLen() > BALANCE_BITS {
+			return nil, fmt.Errorf("%s balance of account %s does not fit in %d bits", entry.Asset, entry.Address, BALANCE_BITS)
+		}
+
+		// Addresses are hex, compare them regardless of case
+		address := strings.ToLower(entry.Address)
+		accountIndex, ok := accountIndexes[address]
+		if !ok {
+			accountIndex = len(balances)
+			accountIndexes[address] = accountIndex
+			balances = append(balances, make([]*big.Int, len(assets)))
+		}
+		if balances[accountIndex][assetIndex] != nil {
+			return nil, fmt.Errorf("account %s has two %s entries", entry.Address, entry.Asset)
+		}
+		balances[accountIndex][assetIndex] = entry.Balance
+	}
+	if len(balances) > nbAccounts {
+		return nil, fmt.Errorf("got %d accounts for a circuit of %d accounts", len(balances), nbAccounts)
+	}
+
+	assignment := NewMultiAssetSumAggregationCircuit(nbAccounts, len(assets))
+	totalSums := make([]*big.Int, len(assets))
+	for j := range totalSums {
+		totalSums[j] = big.NewInt(0)
+	}
+	for i := range assignment.Balances {
+		for j := range assets {
+			balance := big.NewInt(0)
+			if i < len(balances) && balances[i][j] != nil {
+				balance = balances[i][j]
+			}
+			assignment.Balances[i][j] = balance
+			totalSums[j].Add(totalSums[j], balance)
+		}
+	}
+	for j, totalSum := range totalSums {
+		assignment.TotalSums[j] = totalSum
+	}
+	return assignment, nil
+}
+
+func (circuit *MultiAssetSumAggregationCircuit) Define(api frontend.API) error {
+	nbAssets := len(circuit.TotalSums)
+	if nbAssets == 0 {
+		return errors.New("expected at least one asset")
+	}
+	for _, accountBalances := range circuit.Balances {
+		if len(accountBalances) != nbAssets {
+			return errors.New("expected one balance per asset for every account")
+		}
+	}
+
+	for j := 0; j < nbAssets; j++ {
+		column := make([]frontend.Variable, len(circuit.Balances))
+		for i := range circuit.Balances {
+			column[i] = circuit.Balances[i][j]
+		}
+
+		// Range-check every balance so that none of them can be "negative"
+		if err := assertBalancesInRange(api, column, balanceBitsOrDefault(circuit.BalanceBits)); err != nil {
+			return err
+		}
+
+		// Ensure the sum of the asset balances matches the declared total
+		aggregateSum := frontend.Variable(0)
+		for _, balance := range column {
+			aggregateSum = api.Add(aggregateSum, balance)
+		}
+		api.AssertIsEqual(aggregateSum, circuit.TotalSums[j])
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+var testAssets = []Asset{"BTC", "ETH", "USDC"}
+
+func createAssetBalances(nbAccounts int) []AssetBalance {
+	entries := []AssetBalance{}
+	for i := 0; i < nbAccounts; i++ {
+		address := randomEthereumAddress()
+		for _, asset := range testAssets {
+			// Not every account holds every asset
+			if rand.IntN(4) == 0 {
+				continue
+			}
+			entries = append(entries, AssetBalance{Address: address, Asset: asset, Balance: big.NewInt(int64(rand.Int64()))})
+		}
+	}
+	return entries
+}
+
+func TestMultiAssetProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	entries := createAssetBalances(nbAccounts - 2)
+	assignment, err := NewMultiAssetAssignment(testAssets, entries, nbAccounts)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	// Check the totals against the input data
+	for j, asset := range testAssets {
+		expected := big.NewInt(0)
+		for _, entry := range entries {
+			if entry.Asset == asset {
+				expected.Add(expected, entry.Balance)
+			}
+		}
+		if variableToBigInt(assignment.TotalSums[j]).Cmp(expected) != 0 {
+			t.Fatalf("%s total is %v instead of %v", asset, assignment.TotalSums[j], expected)
+		}
+	}
+
+	cs, err := testProvingBackend(t).Compile(NewMultiAssetSumAggregationCircuit(nbAccounts, len(testAssets)))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	t.Log("Proof verified successfully!")
+}
+
+func TestMultiAssetAssignmentFromInputData(t *testing.T) {
+
+	data := `[
+		{"address": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "asset": "BTC", "balance": 150000000},
+		{"address": "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "asset": "USDC", "balance": 2500000000},
+		{"address": "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "asset": "ETH", "balance": 3000000000000000000}
+	]`
+	entries, err := ReadAssetBalances(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read input data: %v", err)
+	}
+
+	assignment, err := NewMultiAssetAssignment(testAssets, entries, 3)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	expected := [][]int64{
+		{150000000, 3000000000000000000, 0},
+		{0, 0, 2500000000},
+		{0, 0, 0},
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if variableToBigInt(assignment.Balances[i][j]).Int64() != expected[i][j] {
+				t.Fatalf("Balance of account %d in %s is %v instead of %d", i, testAssets[j], assignment.Balances[i][j], expected[i][j])
+			}
+		}
+	}
+	if err := test.IsSolved(NewMultiAssetSumAggregationCircuit(3, len(testAssets)), assignment, ecc.BLS12_381.ScalarField()); err != nil {
+		t.Fatalf("Expected the circuit to be solved: %v", err)
+	}
+
+	invalidInputs := []struct {
+		name    string
+		entries []AssetBalance
+	}{
+		{"UnlistedAsset", []AssetBalance{{Address: "0x01", Asset: "DOGE", Balance: big.NewInt(1)}}},
+		{"DuplicateEntry", []AssetBalance{{Address: "0x01", Asset: "BTC", Balance: big.NewInt(1)}, {Address: "0x01", Asset: "BTC", Balance: big.NewInt(2)}}},
+		{"NegativeBalance", []AssetBalance{{Address: "0x01", Asset: "BTC", Balance: big.NewInt(-1)}}},
+		{"TooManyAccounts", []AssetBalance{
+			{Address: "0x01", Asset: "BTC", Balance: big.NewInt(1)},
+			{Address: "0x02", Asset: "BTC", Balance: big.NewInt(1)},
+			{Address: "0x03", Asset: "BTC", Balance: big.NewInt(1)},
+			{Address: "0x04", Asset: "BTC", Balance: big.NewInt(1)},
+		}},
+	}
+	for _, tc := range invalidInputs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewMultiAssetAssignment(testAssets, tc.entries, 3); err == nil {
+				t.Fatal("Expected the input data to be rejected")
+			}
+		})
+	}
+}
+
+func TestMultiAssetRejectsInvalidTotals(t *testing.T) {
+
+	valid, err := NewMultiAssetAssignment(testAssets, createAssetBalances(4), 4)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	copyAssignment := func() *MultiAssetSumAggregationCircuit {
+		assignment := NewMultiAssetSumAggregationCircuit(4, len(testAssets))
+		for i := range valid.Balances {
+			copy(assignment.Balances[i], valid.Balances[i])
+		}
+		copy(assignment.TotalSums, valid.TotalSums)
+		return assignment
+	}
+
+	wrongTotal := copyAssignment()
+	wrongTotal.TotalSums[1] = new(big.Int).Add(variableToBigInt(valid.TotalSums[1]), big.NewInt(1))
+
+	// Moving value from one asset to another keeps the grand total unchanged
+	swappedAssets := copyAssignment()
+	swappedAssets.TotalSums[0] = new(big.Int).Add(variableToBigInt(valid.TotalSums[0]), big.NewInt(1))
+	swappedAssets.TotalSums[2] = new(big.Int).Sub(variableToBigInt(valid.TotalSums[2]), big.NewInt(1))
+
+	// A wrapped-around "negative" balance lowers the total of its asset
+	negativeBalance := copyAssignment()
+	negative := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(5))
+	negativeBalance.Balances[0][2] = negative
+	total := new(big.Int).Sub(variableToBigInt(valid.TotalSums[2]), variableToBigInt(valid.Balances[0][2]))
+	total.Add(total, negative)
+	negativeBalance.TotalSums[2] = total.Mod(total, ecc.BLS12_381.ScalarField())
+
+	testCases := []struct {
+		name       string
+		assignment *MultiAssetSumAggregationCircuit
+		valid      bool
+	}{
+		{"ValidTotals", valid, true},
+		{"WrongAssetTotal", wrongTotal, false},
+		{"TotalsSwappedBetweenAssets", swappedAssets, false},
+		{"WrappedNegativeBalance", negativeBalance, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := test.IsSolved(NewMultiAssetSumAggregationCircuit(4, len(testAssets)), tc.assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/signature"
+	"github.com/consensys/gnark-crypto/signature/eddsa"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+	stdeddsa "github.com/consensys/gnark/std/signature/eddsa"
+)
+
+// SignedIndividualBalanceCircuit is IndividualBalanceCircuit that also
+// verifies an EdDSA signature of the account holder over
+// MiMC(accountHash, balance, epoch), on the twisted Edwards curve embedded in
+// the scalar field. The proof then shows that the holder of the public key
+// acknowledged the committed balance at that epoch.
+type SignedIndividualBalanceCircuit struct {
+	IndividualBalanceCircuit
+	OwnerKey       stdeddsa.PublicKey `gnark:"owner_key,public"`
+	OwnerSignature stdeddsa.Signature `gnark:"owner_signature,secret"`
+}
+
+func (circuit *SignedIndividualBalanceCircuit) Define(api frontend.API) error {
+	if err := circuit.IndividualBalanceCircuit.Define(api); err != nil {
+		return err
+	}
+
+	edwardsID, err := circuitEdwardsCurve(api)
+	if err != nil {
+		return err
+	}
+	curve, err := twistededwards.NewEdCurve(api, edwardsID)
+	if err != nil {
+		return err
+	}
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	// The signed message is the hash of what the holder acknowledges. The
+	// signature verification hashes it again with its own inputs, so the
+	// hasher is reset in between.
+	hash.Write(circuit.AccountHash, circuit.Balance, circuit.Epoch)
+	message := hash.Sum()
+	hash.Reset()
+	return stdeddsa.Verify(curve, circuit.OwnerSignature, message, circuit.OwnerKey, &hash)
+}
+
+// GenerateOwnerKey draws the EdDSA key of an account holder on the twisted
+// Edwards curve embedded in BLS12-381
+func GenerateOwnerKey() (signature.Signer, error) {
+	return GenerateOwnerKeyOnCurve(ecc.BLS12_381)
+}
+
+// GenerateOwnerKeyOnCurve draws the EdDSA key of an account holder for a
+// circuit compiled on curve
+func GenerateOwnerKeyOnCurve(curve ecc.ID) (signature.Signer, error) {
+	edwardsID, err := edwardsCurveOf(curve)
+	if err != nil {
+		return nil, err
+	}
+	return eddsa.New(edwardsID, rand.Reader)
+}
+
+// SignBalance signs the acknowledgement of a balance verified by
+// SignedIndividualBalanceCircuit on BLS12-381
+func SignBalance(key signature.Signer, accountHash, balance *big.Int, epoch uint64) ([]byte, error) {
+	return SignBalanceOnCurve(ecc.BLS12_381, key, accountHash, balance, epoch)
+}
+
+// SignBalanceOnCurve signs the acknowledgement of a balance for a circuit
+// compiled on curve, with a key from GenerateOwnerKeyOnCurve
+func SignBalanceOnCurve(curve ecc.ID, key signature.Signer, accountHash, balance *big.Int, epoch uint64) ([]byte, error) {
+	if err := checkAccountHash(accountHash, curve.ScalarField()); err != nil {
+		return nil, err
+	}
+	message, err := mimcHashOnCurve(curve, accountHash, balance, new(big.Int).SetUint64(epoch))
+	if err != nil {
+		return nil, err
+	}
+	h, err := mimcOfCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(message.FillBytes(make([]byte, scalarFieldBytes(curve))), h.New())
+}
+
+// NewSignedIndividualBalanceAssignment completes an IndividualBalanceCircuit
+// assignment on BLS12-381 with the public key and signature of the holder
+func NewSignedIndividualBalanceAssignment(individual IndividualBalanceCircuit, ownerKey signature.PublicKey, ownerSignature []byte) (*SignedIndividualBalanceCircuit, error) {
+	return NewSignedIndividualBalanceAssignmentOnCurve(ecc.BLS12_381, individual, ownerKey, ownerSignature)
+}
+
+// NewSignedIndividualBalanceAssignmentOnCurve completes an
+// IndividualBalanceCircuit assignment for a circuit compiled on curve
+func NewSignedIndividualBalanceAssignmentOnCurve(curve ecc.ID, individual IndividualBalanceCircuit, ownerKey signature.PublicKey, ownerSignature []byte) (*SignedIndividualBalanceCircuit, error) {
+	edwardsID, err := edwardsCurveOf(curve)
+	if err != nil {
+		return nil, err
+	}
+	assignment := &SignedIndividualBalanceCircuit{IndividualBalanceCircuit: individual}
+	assignment.OwnerKey.Assign(edwardsID, ownerKey.Bytes())
+	assignment.OwnerSignature.Assign(edwardsID, ownerSignature)
+	return assignment, nil
+}
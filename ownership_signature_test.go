@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/signature"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// createSignedBalance commits to a balance and signs it with a fresh owner key
+func createSignedBalance(scheme CommitmentScheme, balance *big.Int) (*SignedIndividualBalanceCircuit, signature.Signer, error) {
+	key, err := GenerateOwnerKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	blinding, err := RandomBlinding()
+	if err != nil {
+		return nil, nil, err
+	}
+	accountHash := AccountHash(randomEthereumAddress())
+	commitment, err := PrecomputeCommitment(scheme, balance, blinding, accountHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	ownerSignature, err := SignBalance(key, accountHash, balance, testEpoch)
+	if err != nil {
+		return nil, nil, err
+	}
+	assignment, err := NewSignedIndividualBalanceAssignment(IndividualBalanceCircuit{
+		Balance:        balance,
+		Blinding:       blinding,
+		AccountHash:    accountHash,
+		Commitment:     commitment,
+		Epoch:          testEpoch,
+		SnapshotDigest: testSnapshotDigest,
+	}, key.Public(), ownerSignature)
+	return assignment, key, err
+}
+
+func TestOwnershipSignatureProofAndVerification(t *testing.T) {
+
+	circuit := SignedIndividualBalanceCircuit{IndividualBalanceCircuit: *NewIndividualBalanceCircuit(MiMCScheme)}
+	cs, err := testProvingBackend(t).Compile(&circuit)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	assignment, _, err := createSignedBalance(MiMCScheme, big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	other, _, err := createSignedBalance(MiMCScheme, big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(public *SignedIndividualBalanceCircuit)
+		valid  bool
+	}{
+		{"OwnerKey", func(public *SignedIndividualBalanceCircuit) {}, true},
+		{"KeyOfAnotherHolder", func(public *SignedIndividualBalanceCircuit) {
+			public.OwnerKey = other.OwnerKey
+		}, false},
+		{"AnotherEpoch", func(public *SignedIndividualBalanceCircuit) {
+			public.Epoch = testEpoch + 1
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			public := *assignment
+			tc.modify(&public)
+			pw, err := frontend.NewWitness(&public, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			err = Verify(proof, vk, pw)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the proof to verify: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the proof not to verify")
+			}
+		})
+	}
+}
+
+func TestOwnershipSignatureRejectsInvalidSignatures(t *testing.T) {
+
+	for _, scheme := range []CommitmentScheme{PedersenScheme, MiMCScheme} {
+		t.Run(scheme.String(), func(t *testing.T) {
+
+			balance := big.NewInt(1000)
+			valid, key, err := createSignedBalance(scheme, balance)
+			if err != nil {
+				t.Fatalf("Failed to create assignment: %v", err)
+			}
+			accountHash := variableToBigInt(valid.AccountHash)
+			resign := func(accountHash, balance *big.Int, epoch uint64) func(assignment *SignedIndividualBalanceCircuit) {
+				return func(assignment *SignedIndividualBalanceCircuit) {
+					ownerSignature, err := SignBalance(key, accountHash, balance, epoch)
+					if err != nil {
+						t.Fatalf("Failed to sign balance: %v", err)
+					}
+					assignment.OwnerSignature.Assign(PEDERSEN_CURVE, ownerSignature)
+				}
+			}
+			otherKey, err := GenerateOwnerKey()
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+
+			testCases := []struct {
+				name   string
+				modify func(assignment *SignedIndividualBalanceCircuit)
+				valid  bool
+			}{
+				{"SignedByTheOwner", func(assignment *SignedIndividualBalanceCircuit) {}, true},
+				{"SignatureOfAnotherBalance", resign(accountHash, new(big.Int).Add(balance, big.NewInt(1)), testEpoch), false},
+				{"SignatureOfAnotherEpoch", resign(accountHash, balance, testEpoch-1), false},
+				{"SignatureOfAnotherAccount", resign(AccountHash(randomEthereumAddress()), balance, testEpoch), false},
+				{"KeyOfAnotherHolder", func(assignment *SignedIndividualBalanceCircuit) {
+					assignment.OwnerKey.Assign(PEDERSEN_CURVE, otherKey.Public().Bytes())
+				}, false},
+			}
+
+			circuit := SignedIndividualBalanceCircuit{IndividualBalanceCircuit: *NewIndividualBalanceCircuit(scheme)}
+			for _, tc := range testCases {
+				t.Run(tc.name, func(t *testing.T) {
+					assignment := *valid
+					tc.modify(&assignment)
+					err := test.IsSolved(&circuit, &assignment, ecc.BLS12_381.ScalarField())
+					if tc.valid && err != nil {
+						t.Fatalf("Expected the circuit to be solved: %v", err)
+					}
+					if !tc.valid && err == nil {
+						t.Fatal("Expected the circuit not to be solved")
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+)
+
+// Padded circuits are compiled for a fixed capacity rather than for the exact
+// number of accounts, which would leak it. Every slot carries a secret Active
+// flag: the accounts fill active slots and the inactive ones hold an empty
+// value that does not change the total. The Counted variants additionally
+// publish the number of active slots.
+
+// assertActiveFlags constrains every flag to be 0 or 1 and returns the number
+// of active slots
+func assertActiveFlags(api frontend.API, active []frontend.Variable) frontend.Variable {
+	count := frontend.Variable(0)
+	for _, flag := range active {
+		api.AssertIsBoolean(flag)
+		count = api.Add(count, flag)
+	}
+	return count
+}
+
+// assertInactiveSlotEmpty asserts value == empty unless the slot is active
+func assertInactiveSlotEmpty(api frontend.API, active, value, empty frontend.Variable) {
+	api.AssertIsEqual(api.Mul(api.Sub(1, active), api.Sub(value, empty)), 0)
+}
+
+// CountedSumAggregationCircuit is SumAggregationCircuit with the number of
+// accounts, its active slots, as a public output
+type CountedSumAggregationCircuit struct {
+	SumAggregationCircuit
+	NbAccounts frontend.Variable `gnark:"nb_accounts,public"`
+}
+
+func (circuit *CountedSumAggregationCircuit) Define(api frontend.API) error {
+	nbAccounts, err := circuit.assertTotalSum(api)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(nbAccounts, circuit.NbAccounts)
+	return nil
+}
+
+// CountedAggregatedBalanceCircuit is AggregatedBalanceCircuit with the number
+// of accounts, its active slots, as a public output
+type CountedAggregatedBalanceCircuit struct {
+	AggregatedBalanceCircuit
+	NbAccounts frontend.Variable `gnark:"nb_accounts,public"`
+}
+
+func (circuit *CountedAggregatedBalanceCircuit) Define(api frontend.API) error {
+	nbAccounts, err := circuit.assertTotalCommitment(api)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(nbAccounts, circuit.NbAccounts)
+	return nil
+}
+
+// PadCommitments fills a capacity-slot AggregatedBalanceCircuit assignment
+// with the commitments of the accounts followed by empty commitments
+func PadCommitments(scheme CommitmentScheme, commitments []twistededwards.Point, capacity int) (paddedCommitments []twistededwards.Point, active []frontend.Variable, err error) {
+	if len(commitments) > capacity {
+		return nil, nil, errors.New("more commitments than slots")
+	}
+	empty, err := EmptyCommitment(scheme)
+	if err != nil {
+		return nil, nil, err
+	}
+	paddedCommitments = make([]twistededwards.Point, capacity)
+	active = make([]frontend.Variable, capacity)
+	for i := range paddedCommitments {
+		if i < len(commitments) {
+			paddedCommitments[i], active[i] = commitments[i], 1
+		} else {
+			paddedCommitments[i], active[i] = empty, 0
+		}
+	}
+	return paddedCommitments, active, nil
+}
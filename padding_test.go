@@ -0,0 +1,243 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
+)
+
+// activeSlots returns the flags of capacity slots of which the first
+// nbAccounts are active
+func activeSlots(nbAccounts, capacity int) []frontend.Variable {
+	active := make([]frontend.Variable, capacity)
+	for i := range active {
+		active[i] = 0
+		if i < nbAccounts {
+			active[i] = 1
+		}
+	}
+	return active
+}
+
+func createAccountBalances(n int) []AccountBalance {
+	accounts := make([]AccountBalance, n)
+	for i := range accounts {
+		accounts[i] = AccountBalance{Address: randomEthereumAddress(), Balance: big.NewInt(int64(1000 * (i + 1)))}
+	}
+	return accounts
+}
+
+func TestPaddedSumAggregationServesSnapshotsOfAnySize(t *testing.T) {
+
+	const capacity = 8
+	cs, err := testProvingBackend(t).Compile(NewSumAggregationCircuit(capacity))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		nbAccounts int
+	}{
+		{"EmptySnapshot", 0},
+		{"PartiallyFilled", 3},
+		{"Full", capacity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment, err := NewSumAggregationAssignment(createAccountBalances(tc.nbAccounts), capacity, testEpoch, testSnapshotDigest)
+			if err != nil {
+				t.Fatalf("Failed to create assignment: %v", err)
+			}
+			fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+			if err != nil {
+				t.Fatalf("Failed to create witness: %v", err)
+			}
+			pw, err := fw.Public()
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			proof, err := Prove(cs, pk, fw)
+			if err != nil {
+				t.Fatalf("Failed to generate proof: %v", err)
+			}
+			if err := Verify(proof, vk, pw); err != nil {
+				t.Fatalf("Failed to verify proof: %v", err)
+			}
+		})
+	}
+
+	t.Run("MoreAccountsThanSlots", func(t *testing.T) {
+		if _, err := NewSumAggregationAssignment(createAccountBalances(capacity+1), capacity, testEpoch, testSnapshotDigest); err == nil {
+			t.Fatal("Expected an error for more accounts than slots")
+		}
+	})
+}
+
+func TestCountedSumAggregationPublishesTheNumberOfAccounts(t *testing.T) {
+
+	const capacity = 8
+	circuit := CountedSumAggregationCircuit{SumAggregationCircuit: *NewSumAggregationCircuit(capacity)}
+	cs, err := testProvingBackend(t).Compile(&circuit)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	accounts := createAccountBalances(3)
+	sum, err := NewSumAggregationAssignment(accounts, capacity, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	assignment := CountedSumAggregationCircuit{SumAggregationCircuit: *sum, NbAccounts: len(accounts)}
+	fw, err := frontend.NewWitness(&assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		nbAccounts int
+		valid      bool
+	}{
+		{"ActualCount", len(accounts), true},
+		{"InflatedCount", len(accounts) + 1, false},
+		{"Capacity", capacity, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			public := CountedSumAggregationCircuit{SumAggregationCircuit: *sum, NbAccounts: tc.nbAccounts}
+			pw, err := frontend.NewWitness(&public, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			err = Verify(proof, vk, pw)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the proof to verify: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the proof not to verify")
+			}
+		})
+	}
+}
+
+func TestPaddedSumAggregationRejectsInvalidSlots(t *testing.T) {
+
+	accountHashes := testAccountHashes(3)
+	testCases := []struct {
+		name          string
+		balances      []frontend.Variable
+		accountHashes []frontend.Variable
+		active        []frontend.Variable
+		totalSum      frontend.Variable
+		valid         bool
+	}{
+		{"InactiveSlotsLast", []frontend.Variable{10, 20, 0}, accountHashes, []frontend.Variable{1, 1, 0}, 30, true},
+		{"InactiveSlotBetweenAccounts", []frontend.Variable{10, 0, 20}, accountHashes, []frontend.Variable{1, 0, 1}, 30, true},
+		{"InactiveSlotRepeatingHash", []frontend.Variable{10, 0, 20}, []frontend.Variable{accountHashes[0], accountHashes[0], accountHashes[2]}, []frontend.Variable{1, 0, 1}, 30, true},
+		{"InactiveSlotWithBalance", []frontend.Variable{10, 20, 5}, accountHashes, []frontend.Variable{1, 1, 0}, 35, false},
+		{"NonBooleanFlag", []frontend.Variable{10, 20, 0}, accountHashes, []frontend.Variable{1, 2, 0}, 30, false},
+		{"DuplicateAfterInactiveSlot", []frontend.Variable{10, 0, 20}, []frontend.Variable{accountHashes[0], accountHashes[0], accountHashes[0]}, []frontend.Variable{1, 0, 1}, 30, false},
+		{"InactiveSlotDecreasing", []frontend.Variable{10, 20, 0}, []frontend.Variable{accountHashes[1], accountHashes[2], accountHashes[0]}, []frontend.Variable{1, 1, 0}, 30, false},
+	}
+
+	circuit := NewSumAggregationCircuit(3)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := SumAggregationCircuit{
+				Balances:       tc.balances,
+				AccountHashes:  tc.accountHashes,
+				Active:         tc.active,
+				TotalSum:       tc.totalSum,
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+			err := test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestPaddedAggregatedBalance(t *testing.T) {
+
+	const capacity = 4
+	commitments := make([]twistededwards.Point, 2)
+	for i := range commitments {
+		blinding, err := RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to generate blinding: %v", err)
+		}
+		accountHash := AccountHash(randomEthereumAddress())
+		commitments[i], err = PrecomputePedersenCommitment(big.NewInt(int64(100*(i+1))), blinding, accountHash)
+		if err != nil {
+			t.Fatalf("Failed to compute commitment: %v", err)
+		}
+	}
+	totalCommitment, err := AggregateCommitments(PedersenScheme, commitments)
+	if err != nil {
+		t.Fatalf("Failed to aggregate commitments: %v", err)
+	}
+	padded, active, err := PadCommitments(PedersenScheme, commitments, capacity)
+	if err != nil {
+		t.Fatalf("Failed to pad commitments: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		commitments []twistededwards.Point
+		active      []frontend.Variable
+		nbAccounts  int
+		valid       bool
+	}{
+		{"PaddedCommitments", padded, active, len(commitments), true},
+		{"WrongCount", padded, active, capacity, false},
+		// The commitment of the second account is dropped from the total by
+		// flagging its slot inactive
+		{"InactiveSlotWithCommitment", []twistededwards.Point{commitments[0], commitments[1], padded[2], padded[3]}, activeSlots(1, capacity), 1, false},
+	}
+
+	circuit := CountedAggregatedBalanceCircuit{AggregatedBalanceCircuit: AggregatedBalanceCircuit{
+		Commitments: make([]twistededwards.Point, capacity),
+		Active:      make([]frontend.Variable, capacity),
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := CountedAggregatedBalanceCircuit{
+				AggregatedBalanceCircuit: AggregatedBalanceCircuit{
+					Commitments:     tc.commitments,
+					Active:          tc.active,
+					TotalCommitment: totalCommitment,
+					Epoch:           testEpoch,
+					SnapshotDigest:  testSnapshotDigest,
+				},
+				NbAccounts: tc.nbAccounts,
+			}
+			err := test.IsSolved(&circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+)
+
+// PEDERSEN_CURVE is the twisted Edwards curve embedded in the BLS12-381
+// scalar field (Jubjub) on which balance commitments are computed. Circuits
+// compiled on another curve commit on the curve embedded in its scalar field,
+// see edwardsCurveOf.
+const PEDERSEN_CURVE = tedwards.BLS12_381
+
+// PEDERSEN_DOMAIN is the domain separation tag the generators are derived from
+const PEDERSEN_DOMAIN = "zk_snark_balance_aggregation/pedersen/"
+
+// edwardsPoint is a native affine point of a twisted Edwards curve
+type edwardsPoint struct {
+	X, Y *big.Int
+}
+
+// edwardsCurve implements the native twisted Edwards arithmetic matching
+// gnark's std/algebra/native/twistededwards, for any supported curve
+type edwardsCurve struct {
+	params *twistededwards.CurveParams
+	field  *big.Int
+}
+
+// PedersenGenerators are the independent generators of the commitment
+// C = balance*G + blinding*H + low*J + high*K, where low and high are the
+// halves of the bits of the account hash. The account hash is a scalar field
+// element, larger than the order ℓ of the commitment group: committed to as a
+// single scalar, accountHash and accountHash+ℓ would give the same commitment
+// and open it to two accounts. Each half is below ℓ instead.
+type PedersenGenerators struct {
+	Balance         edwardsPoint // G
+	Blinding        edwardsPoint // H
+	AccountHash     edwardsPoint // J
+	AccountHashHigh edwardsPoint // K
+}
+
+// edwardsCurveOf returns the twisted Edwards curve embedded in the scalar
+// field of a pairing-friendly curve
+func edwardsCurveOf(curve ecc.ID) (tedwards.ID, error) {
+	switch curve {
+	case ecc.BN254:
+		return tedwards.BN254, nil
+	case ecc.BLS12_381:
+		return tedwards.BLS12_381, nil
+	case ecc.BLS12_377:
+		return tedwards.BLS12_377, nil
+	case ecc.BW6_761:
+		return tedwards.BW6_761, nil
+	default:
+		return 0, fmt.Errorf("no twisted Edwards curve embedded in %v", curve)
+	}
+}
+
+// circuitEdwardsCurve returns the twisted Edwards curve embedded in the
+// scalar field a circuit is compiled over
+func circuitEdwardsCurve(api frontend.API) (tedwards.ID, error) {
+	curve, err := curveOfField(api.Compiler().Field())
+	if err != nil {
+		return 0, err
+	}
+	return edwardsCurveOf(curve)
+}
+
+func newEdwardsCurve(id tedwards.ID) (*edwardsCurve, error) {
+	params, err := twistededwards.GetCurveParams(id)
+	if err != nil {
+		return nil, err
+	}
+	field, err := twistededwards.GetSnarkField(id)
+	if err != nil {
+		return nil, err
+	}
+	return &edwardsCurve{params: params, field: field}, nil
+}
+
+func (c *edwardsCurve) identity() edwardsPoint {
+	return edwardsPoint{X: big.NewInt(0), Y: big.NewInt(1)}
+}
+
+func (c *edwardsCurve) isIdentity(p edwardsPoint) bool {
+	return p.X.Sign() == 0 && p.Y.Cmp(big.NewInt(1)) == 0
+}
+
+func (c *edwardsCurve) isOnCurve(p edwardsPoint) bool {
+	// a*x^2 + y^2 == 1 + d*x^2*y^2
+	x2 := c.mul(p.X, p.X)
+	y2 := c.mul(p.Y, p.Y)
+	lhs := c.mul(c.params.A, x2)
+	lhs.Add(lhs, y2)
+	rhs := c.mul(c.params.D, c.mul(x2, y2))
+	rhs.Add(rhs, big.NewInt(1))
+	return lhs.Mod(lhs, c.field).Cmp(rhs.Mod(rhs, c.field)) == 0
+}
+
+func (c *edwardsCurve) mul(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, c.field)
+}
+
+// addProjective adds two points in projective coordinates (X:Y:Z) using the
+// complete addition law, so that no inversion is needed
+func (c *edwardsCurve) addProjective(p, q [3]*big.Int) [3]*big.Int {
+	a := c.mul(p[2], q[2])
+	b := c.mul(a, a)
+	cc := c.mul(p[0], q[0])
+	d := c.mul(p[1], q[1])
+	e := c.mul(c.params.D, c.mul(cc, d))
+	f := new(big.Int).Sub(b, e)
+	g := new(big.Int).Add(b, e)
+
+	x1y1 := new(big.Int).Add(p[0], p[1])
+	x2y2 := new(big.Int).Add(q[0], q[1])
+	h := c.mul(x1y1, x2y2)
+	h.Sub(h, cc).Sub(h, d)
+	ac := c.mul(c.params.A, cc)
+
+	return [3]*big.Int{
+		c.mul(c.mul(a, f), h),
+		c.mul(c.mul(a, g), new(big.Int).Sub(d, ac)),
+		c.mul(f, g),
+	}
+}
+
+func (c *edwardsCurve) toAffine(p [3]*big.Int) edwardsPoint {
+	zInv := new(big.Int).ModInverse(p[2], c.field)
+	return edwardsPoint{X: c.mul(p[0], zInv), Y: c.mul(p[1], zInv)}
+}
+
+func (c *edwardsCurve) add(p, q edwardsPoint) edwardsPoint {
+	return c.toAffine(c.addProjective(
+		[3]*big.Int{p.X, p.Y, big.NewInt(1)},
+		[3]*big.Int{q.X, q.Y, big.NewInt(1)},
+	))
+}
+
+// scalarMul computes s*p with a double-and-add over the bits of s
+func (c *edwardsCurve) scalarMul(p edwardsPoint, s *big.Int) edwardsPoint {
+	base := [3]*big.Int{p.X, p.Y, big.NewInt(1)}
+	result := [3]*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(1)}
+	for i := s.BitLen() - 1; i >= 0; i-- {
+		result = c.addProjective(result, result)
+		if s.Bit(i) == 1 {
+			result = c.addProjective(result, base)
+		}
+	}
+	return c.toAffine(result)
+}
+
+// hashToCurve maps a tag to a point of the prime-order subgroup with a
+// try-and-increment: SHA-256(tag || counter) is used as y-coordinate until
+// the curve equation can be solved for x, and the point is then cleared of
+// its cofactor. Nobody knows the discrete log of such a point with respect
+// to any other, which is what makes the generators independent.
+func (c *edwardsCurve) hashToCurve(tag string) edwardsPoint {
+	one := big.NewInt(1)
+	counter := make([]byte, 4)
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		hash := sha256.New()
+		hash.Write([]byte(tag))
+		hash.Write(counter)
+		y := new(big.Int).SetBytes(hash.Sum(nil))
+		y.Mod(y, c.field)
+
+		// x^2 = (1 - y^2) / (a - d*y^2)
+		y2 := c.mul(y, y)
+		num := new(big.Int).Sub(one, y2)
+		den := new(big.Int).Sub(c.params.A, c.mul(c.params.D, y2))
+		den.Mod(den, c.field)
+		if den.Sign() == 0 {
+			continue
+		}
+		x2 := c.mul(num, new(big.Int).ModInverse(den, c.field))
+		x := new(big.Int).ModSqrt(x2, c.field)
+		if x == nil {
+			continue
+		}
+		// Pick the smaller of the two square roots so the result is canonical
+		if negX := new(big.Int).Sub(c.field, x); negX.Cmp(x) < 0 {
+			x = negX
+		}
+
+		point := c.scalarMul(edwardsPoint{X: x, Y: y}, c.params.Cofactor)
+		if !c.isIdentity(point) {
+			return point
+		}
+	}
+}
+
+// NewPedersenGenerators derives the nothing-up-my-sleeve generators of the
+// Pedersen commitment on the given twisted Edwards curve
+func NewPedersenGenerators(id tedwards.ID) (*PedersenGenerators, error) {
+	curve, err := newEdwardsCurve(id)
+	if err != nil {
+		return nil, err
+	}
+	return &PedersenGenerators{
+		Balance:         curve.hashToCurve(PEDERSEN_DOMAIN + "balance"),
+		Blinding:        curve.hashToCurve(PEDERSEN_DOMAIN + "blinding"),
+		AccountHash:     curve.hashToCurve(PEDERSEN_DOMAIN + "account_hash"),
+		AccountHashHigh: curve.hashToCurve(PEDERSEN_DOMAIN + "account_hash_high"),
+	}, nil
+}
+
+// accountHashLimbBits is the number of bits of the low half of an account
+// hash in a Pedersen commitment of a circuit over a fieldBits-bit field
+func accountHashLimbBits(fieldBits int) int {
+	return fieldBits / 2
+}
+
+// accountHashLimbs splits an account hash of a circuit compiled on curve into
+// the low and high halves of its bits
+func accountHashLimbs(curve ecc.ID, accountHash *big.Int) (low, high *big.Int) {
+	limbBits := uint(accountHashLimbBits(curve.ScalarField().BitLen()))
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), limbBits), big.NewInt(1))
+	return new(big.Int).And(accountHash, mask), new(big.Int).Rsh(accountHash, limbBits)
+}
+
+// RandomBlinding draws a uniformly random blinding factor for a circuit
+// compiled on BLS12-381
+func RandomBlinding() (*big.Int, error) {
+	return RandomBlindingOnCurve(ecc.BLS12_381)
+}
+
+// RandomBlindingOnCurve draws a uniformly random blinding factor for a
+// circuit compiled on curve. It is drawn below the order of the Pedersen
+// commitment group, the only blindings PedersenScheme accepts, which leaves
+// more than 250 random bits for the hash commitments and salts.
+func RandomBlindingOnCurve(curve ecc.ID) (*big.Int, error) {
+	edwardsID, err := edwardsCurveOf(curve)
+	if err != nil {
+		return nil, err
+	}
+	params, err := twistededwards.GetCurveParams(edwardsID)
+	if err != nil {
+		return nil, err
+	}
+	return rand.Int(rand.Reader, params.Order)
+}
+
+// PrecomputePedersenCommitment computes natively the commitment
+// C = balance*G + blinding*H + low*J + high*K that IndividualBalanceCircuit
+// computes in-circuit on BLS12-381. The returned point can be assigned to the
+// circuit.
+func PrecomputePedersenCommitment(balance, blinding, accountHash *big.Int) (twistededwards.Point, error) {
+	return PrecomputePedersenCommitmentOnCurve(ecc.BLS12_381, balance, blinding, accountHash)
+}
+
+// PrecomputePedersenCommitmentOnCurve computes natively the Pedersen
+// commitment of a circuit compiled on curve
+func PrecomputePedersenCommitmentOnCurve(id ecc.ID, balance, blinding, accountHash *big.Int) (twistededwards.Point, error) {
+	if err := checkAccountHash(accountHash, id.ScalarField()); err != nil {
+		return twistededwards.Point{}, err
+	}
+	low, high := accountHashLimbs(id, accountHash)
+	return precomputePedersenCommitmentOfLimbs(id, balance, blinding, low, high)
+}
+
+// precomputePedersenCommitmentOfLimbs computes natively the Pedersen
+// commitment to the halves of an account hash, or to the opening of a sum of
+// commitments, of a circuit compiled on curve
+func precomputePedersenCommitmentOfLimbs(id ecc.ID, balance, blinding, low, high *big.Int) (twistededwards.Point, error) {
+	edwardsID, err := edwardsCurveOf(id)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	curve, err := newEdwardsCurve(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	generators, err := NewPedersenGenerators(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+
+	// The circuit only accepts scalars that are canonical in the commitment group
+	for _, scalar := range []*big.Int{blinding, low, high} {
+		if scalar.Sign() < 0 || scalar.Cmp(curve.params.Order) >= 0 {
+			return twistededwards.Point{}, errors.New("scalar is not below the order of the commitment group")
+		}
+	}
+
+	// The circuit sees the balance as a scalar field element
+	commitment := curve.scalarMul(generators.Balance, new(big.Int).Mod(balance, curve.field))
+	commitment = curve.add(commitment, curve.scalarMul(generators.Blinding, blinding))
+	commitment = curve.add(commitment, curve.scalarMul(generators.AccountHash, low))
+	commitment = curve.add(commitment, curve.scalarMul(generators.AccountHashHigh, high))
+
+	return twistededwards.Point{X: commitment.X, Y: commitment.Y}, nil
+}
+
+// fixedBaseScalarMul computes scalar*base in-circuit for a constant base
+// point, from the bits of the scalar. The bits are consumed two at a time
+// against precomputed multiples of the base. This is much cheaper than the
+// variable-base ScalarMul.
+func (c *edwardsCurve) fixedBaseScalarMul(api frontend.API, curve twistededwards.Curve, base edwardsPoint, bits []frontend.Variable) twistededwards.Point {
+	bits = append([]frontend.Variable{}, bits...)
+	if len(bits)%2 == 1 {
+		bits = append(bits, 0)
+	}
+
+	var result twistededwards.Point
+	window := base // 4^(i/2) * base
+	for i := 0; i < len(bits); i += 2 {
+		double := c.add(window, window)
+		triple := c.add(double, window)
+		term := twistededwards.Point{
+			X: api.Lookup2(bits[i], bits[i+1], 0, window.X, double.X, triple.X),
+			Y: api.Lookup2(bits[i], bits[i+1], 1, window.Y, double.Y, triple.Y),
+		}
+		if i == 0 {
+			result = term
+		} else {
+			result = curve.Add(result, term)
+		}
+		window = c.add(double, double)
+	}
+	return result
+}
+
+// scalarBits decomposes a scalar of the commitment group into bits and
+// asserts it is below the group order ℓ, so that no other scalar gives the
+// same multiple of a generator
+func (c *edwardsCurve) scalarBits(api frontend.API, scalar frontend.Variable) []frontend.Variable {
+	api.AssertIsLessOrEqual(scalar, new(big.Int).Sub(c.params.Order, big.NewInt(1)))
+	return api.ToBinary(scalar, c.params.Order.BitLen())
+}
+
+// pedersenCommit computes in-circuit C = balance*G + blinding*H + low*J + high*K.
+// The balance is range-checked to balanceBits bits and the blinding to the
+// order of the commitment group. The account hash is decomposed over the
+// whole field, which gnark checks is canonical, and each half of its bits is
+// committed to separately.
+func pedersenCommit(api frontend.API, balance, blinding, accountHash frontend.Variable, balanceBits int) (twistededwards.Point, error) {
+	accountHashBits := api.ToBinary(accountHash, api.Compiler().FieldBitLen())
+	limbBits := accountHashLimbBits(len(accountHashBits))
+	return pedersenCommitBits(api, balance, blinding, accountHashBits[:limbBits], accountHashBits[limbBits:], balanceBits)
+}
+
+// pedersenCommitLimbs computes in-circuit C = balance*G + blinding*H + low*J + high*K
+// for a balance of balanceBits bits and other scalars below the order of the
+// commitment group, such as the opening of a sum of commitments
+func pedersenCommitLimbs(api frontend.API, balance, blinding, low, high frontend.Variable, balanceBits int) (twistededwards.Point, error) {
+	edwardsID, err := circuitEdwardsCurve(api)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	native, err := newEdwardsCurve(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	return pedersenCommitBits(api, balance, blinding, native.scalarBits(api, low), native.scalarBits(api, high), balanceBits)
+}
+
+// pedersenCommitBits computes the commitment from the bits of the halves of
+// the account hash
+func pedersenCommitBits(api frontend.API, balance, blinding frontend.Variable, lowBits, highBits []frontend.Variable, balanceBits int) (twistededwards.Point, error) {
+	if balanceBits <= 0 || balanceBits >= api.Compiler().FieldBitLen() {
+		return twistededwards.Point{}, errors.New("invalid balance bit width")
+	}
+	edwardsID, err := circuitEdwardsCurve(api)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	curve, err := twistededwards.NewEdCurve(api, edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	native, err := newEdwardsCurve(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	generators, err := NewPedersenGenerators(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+
+	commitment := native.fixedBaseScalarMul(api, curve, generators.Balance, api.ToBinary(balance, balanceBits))
+	commitment = curve.Add(commitment, native.fixedBaseScalarMul(api, curve, generators.Blinding, native.scalarBits(api, blinding)))
+	commitment = curve.Add(commitment, native.fixedBaseScalarMul(api, curve, generators.AccountHash, lowBits))
+	commitment = curve.Add(commitment, native.fixedBaseScalarMul(api, curve, generators.AccountHashHigh, highBits))
+	return commitment, nil
+}
+
+// AggregatePedersenCommitments natively adds up commitments. The sum is a
+// commitment to the total balance, matching AggregatedBalanceCircuit.
+func AggregatePedersenCommitments(commitments []twistededwards.Point) (twistededwards.Point, error) {
+	return AggregatePedersenCommitmentsOnCurve(ecc.BLS12_381, commitments)
+}
+
+// AggregatePedersenCommitmentsOnCurve natively adds up the Pedersen
+// commitments of a circuit compiled on curve
+func AggregatePedersenCommitmentsOnCurve(id ecc.ID, commitments []twistededwards.Point) (twistededwards.Point, error) {
+	edwardsID, err := edwardsCurveOf(id)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+	curve, err := newEdwardsCurve(edwardsID)
+	if err != nil {
+		return twistededwards.Point{}, err
+	}
+
+	total := curve.identity()
+	for _, commitment := range commitments {
+		total = curve.add(total, edwardsPoint{X: variableToBigInt(commitment.X), Y: variableToBigInt(commitment.Y)})
+	}
+	return twistededwards.Point{X: total.X, Y: total.Y}, nil
+}
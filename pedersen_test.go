@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
+)
+
+func TestPedersenGenerators(t *testing.T) {
+
+	curve, err := newEdwardsCurve(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to load the embedded curve: %v", err)
+	}
+	generators, err := NewPedersenGenerators(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to derive the generators: %v", err)
+	}
+
+	points := map[string]edwardsPoint{
+		"balance":           generators.Balance,
+		"blinding":          generators.Blinding,
+		"account_hash":      generators.AccountHash,
+		"account_hash_high": generators.AccountHashHigh,
+		"base":              {X: curve.params.Base[0], Y: curve.params.Base[1]},
+	}
+	seen := make(map[string]string)
+	for name, point := range points {
+		if !curve.isOnCurve(point) {
+			t.Fatalf("Generator %s is not on the curve", name)
+		}
+		if curve.isIdentity(point) {
+			t.Fatalf("Generator %s is the identity", name)
+		}
+		if !curve.isIdentity(curve.scalarMul(point, curve.params.Order)) {
+			t.Fatalf("Generator %s is not in the prime-order subgroup", name)
+		}
+		key := point.X.String() + "," + point.Y.String()
+		if other, ok := seen[key]; ok {
+			t.Fatalf("Generators %s and %s are equal", name, other)
+		}
+		seen[key] = name
+	}
+
+	// The derivation must be deterministic so that anybody can recompute it
+	again, err := NewPedersenGenerators(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to derive the generators: %v", err)
+	}
+	if again.Blinding.X.Cmp(generators.Blinding.X) != 0 || again.Blinding.Y.Cmp(generators.Blinding.Y) != 0 {
+		t.Fatal("Generator derivation is not deterministic")
+	}
+}
+
+func TestPedersenCommitmentMatchesCircuit(t *testing.T) {
+
+	balance := big.NewInt(int64(rand.Int64()))
+	blinding, err := RandomBlinding()
+	if err != nil {
+		t.Fatalf("Failed to draw blinding: %v", err)
+	}
+	accountHash := AccountHash("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	commitment, err := PrecomputePedersenCommitment(balance, blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	otherCommitment, err := PrecomputePedersenCommitment(new(big.Int).Add(balance, big.NewInt(1)), blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	tooLargeBalance := new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS)
+	tooLargeCommitment, err := PrecomputePedersenCommitment(tooLargeBalance, blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+
+	// Scalars shifted by the order ℓ of the commitment group are still field
+	// elements and would give the same commitment, opening it to another account
+	curve, err := newEdwardsCurve(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to load the embedded curve: %v", err)
+	}
+	order := curve.params.Order
+	shiftedAccountHash := new(big.Int).Add(accountHash, order)
+	if shiftedAccountHash.Cmp(ecc.BLS12_381.ScalarField()) >= 0 {
+		t.Fatal("Expected the account hash shifted by the group order to be a field element")
+	}
+	shiftedBlinding := new(big.Int).Add(blinding, order)
+
+	testCases := []struct {
+		name        string
+		balance     *big.Int
+		blinding    *big.Int
+		accountHash *big.Int
+		commitment  twistededwards.Point
+		valid       bool
+	}{
+		{"NativeCommitment", balance, blinding, accountHash, commitment, true},
+		{"CommitmentToAnotherBalance", balance, blinding, accountHash, otherCommitment, false},
+		{"BalanceAboveBitWidth", tooLargeBalance, blinding, accountHash, tooLargeCommitment, false},
+		{"AccountHashShiftedByGroupOrder", balance, blinding, shiftedAccountHash, commitment, false},
+		{"BlindingShiftedByGroupOrder", balance, shiftedBlinding, accountHash, commitment, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := IndividualBalanceCircuit{
+				Balance:        tc.balance,
+				Blinding:       tc.blinding,
+				AccountHash:    tc.accountHash,
+				Commitment:     []frontend.Variable{tc.commitment.X, tc.commitment.Y},
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+			err := test.IsSolved(NewIndividualBalanceCircuit(PedersenScheme), &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestPedersenCommitmentIsHomomorphic(t *testing.T) {
+
+	curve, err := newEdwardsCurve(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to load the embedded curve: %v", err)
+	}
+	generators, err := NewPedersenGenerators(PEDERSEN_CURVE)
+	if err != nil {
+		t.Fatalf("Failed to derive the generators: %v", err)
+	}
+
+	// The opening of the aggregated commitment is the sum of the openings as
+	// integers: the embedded curve group order differs from the scalar field
+	totalBalance := big.NewInt(0)
+	totalBlinding := big.NewInt(0)
+	totalLow, totalHigh := big.NewInt(0), big.NewInt(0)
+	commitments := make([]twistededwards.Point, 4)
+	for i := range commitments {
+		balance := big.NewInt(int64(rand.Int64()))
+		blinding, err := RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to draw blinding: %v", err)
+		}
+		accountHash := AccountHash(randomEthereumAddress())
+
+		commitments[i], err = PrecomputePedersenCommitment(balance, blinding, accountHash)
+		if err != nil {
+			t.Fatalf("Failed to compute commitment: %v", err)
+		}
+		totalBalance.Add(totalBalance, balance)
+		totalBlinding.Add(totalBlinding, blinding)
+		low, high := accountHashLimbs(ecc.BLS12_381, accountHash)
+		totalLow.Add(totalLow, low)
+		totalHigh.Add(totalHigh, high)
+	}
+
+	aggregated, err := AggregatePedersenCommitments(commitments)
+	if err != nil {
+		t.Fatalf("Failed to aggregate commitments: %v", err)
+	}
+	expected := curve.scalarMul(generators.Balance, totalBalance)
+	expected = curve.add(expected, curve.scalarMul(generators.Blinding, totalBlinding))
+	expected = curve.add(expected, curve.scalarMul(generators.AccountHash, totalLow))
+	expected = curve.add(expected, curve.scalarMul(generators.AccountHashHigh, totalHigh))
+	if variableToBigInt(aggregated.X).Cmp(expected.X) != 0 || variableToBigInt(aggregated.Y).Cmp(expected.Y) != 0 {
+		t.Fatal("The sum of the commitments does not commit to the total balance")
+	}
+
+	assignment := AggregatedBalanceCircuit{
+		Commitments:     commitments,
+		Active:          activeSlots(len(commitments), len(commitments)),
+		TotalCommitment: aggregated,
+		Epoch:           testEpoch,
+		SnapshotDigest:  testSnapshotDigest,
+	}
+	circuit := AggregatedBalanceCircuit{
+		Commitments: make([]twistededwards.Point, len(commitments)),
+		Active:      make([]frontend.Variable, len(commitments)),
+	}
+	if err := test.IsSolved(&circuit, &assignment, ecc.BLS12_381.ScalarField()); err != nil {
+		t.Fatalf("Expected the aggregated circuit to be solved: %v", err)
+	}
+}
+
+func randomEthereumAddress() string {
+	address := make([]byte, 20)
+	for i := range address {
+		address[i] = byte(rand.IntN(256))
+	}
+	return "0x" + hex.EncodeToString(address)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strings"
+
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/frontend"
+)
+
+func init() {
+	solver.RegisterHint(fixedPointDivisionHint)
+}
+
+// RoundingMode is the rule by which the weighted total is rounded to a whole
+// number of reference currency units
+type RoundingMode int
+
+const (
+	// RoundUp rounds towards +infinity, so liabilities are never understated
+	RoundUp RoundingMode = iota
+	// RoundDown rounds towards zero
+	RoundDown
+	// RoundHalfUp rounds to the nearest unit, halves away from zero
+	RoundHalfUp
+)
+
+func (rounding RoundingMode) String() string {
+	switch rounding {
+	case RoundUp:
+		return "RoundUp"
+	case RoundDown:
+		return "RoundDown"
+	case RoundHalfUp:
+		return "RoundHalfUp"
+	default:
+		return fmt.Sprintf("RoundingMode(%d)", int(rounding))
+	}
+}
+
+// roundingOffset returns what is added to a value scaled by scale before the
+// floor division that rounds it with the given rule
+func roundingOffset(rounding RoundingMode, scale *big.Int) (*big.Int, error) {
+	switch rounding {
+	case RoundUp:
+		return new(big.Int).Sub(scale, big.NewInt(1)), nil
+	case RoundDown:
+		return big.NewInt(0), nil
+	case RoundHalfUp:
+		return new(big.Int).Rsh(scale, 1), nil
+	default:
+		return nil, fmt.Errorf("unknown rounding mode %v", rounding)
+	}
+}
+
+// fixedPointScale returns 10^decimals
+func fixedPointScale(decimals int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+}
+
+// ParseFixedPoint parses a decimal number such as "64250.12" into a
+// fixed-point integer with the given number of decimals. Numbers with more
+// decimals are rejected rather than silently rounded.
+func ParseFixedPoint(value string, decimals int) (*big.Int, error) {
+	integer, fraction, _ := strings.Cut(value, ".")
+	if integer == "" && fraction == "" {
+		return nil, fmt.Errorf("invalid fixed-point number %q", value)
+	}
+	if len(fraction) > decimals {
+		return nil, fmt.Errorf("%s has more than %d decimals", value, decimals)
+	}
+	digits := integer + fraction + strings.Repeat("0", decimals-len(fraction))
+	result, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.ContainsAny(digits, "+-") {
+		return nil, fmt.Errorf("invalid fixed-point number %s", value)
+	}
+	return result, nil
+}
+
+// WeightedValue computes natively the value of the per-asset totals at the
+// given fixed-point prices, rounded the way PriceWeightedAggregationCircuit
+// enforces it
+func WeightedValue(totalSums, prices []*big.Int, decimals int, rounding RoundingMode) (*big.Int, error) {
+	if len(totalSums) != len(prices) {
+		return nil, errors.New("expected one price per asset")
+	}
+	scale := fixedPointScale(decimals)
+	value, err := roundingOffset(rounding, scale)
+	if err != nil {
+		return nil, err
+	}
+	for j := range totalSums {
+		value.Add(value, new(big.Int).Mul(totalSums[j], prices[j]))
+	}
+	return value.Div(value, scale), nil
+}
+
+// PriceWeightedAggregationCircuit extends MultiAssetSumAggregationCircuit with
+// a public fixed-point price per asset and proves the total value of the
+// balances in a reference currency:
+//
+//	TotalValue = round(sum_j TotalSums[j] * Prices[j] / 10^PriceDecimals)
+//
+// A price is the value of one smallest unit of the asset in the smallest unit
+// of the reference currency, scaled by 10^PriceDecimals. The exact weighted
+// sum is computed in-circuit and rounded with the configured rule.
+type PriceWeightedAggregationCircuit struct {
+	MultiAssetSumAggregationCircuit
+	Prices        []frontend.Variable `gnark:"prices,public"`      // Fixed-point price of every asset
+	TotalValue    frontend.Variable   `gnark:"total_value,public"` // Rounded value in the reference currency
+	PriceBits     int                 `gnark:"-"`                  // Bit width of every price (PRICE_BITS if unset)
+	PriceDecimals int                 `gnark:"-"`                  // Decimals of the prices, 0 for integer prices
+	Rounding      RoundingMode        `gnark:"-"`                  // Rounding of the total value (RoundUp by default)
+}
+
+// NewPriceWeightedAggregationCircuit returns the circuit for nbAccounts
+// accounts holding nbAssets assets, with prices of PRICE_DECIMALS decimals
+func NewPriceWeightedAggregationCircuit(nbAccounts, nbAssets int, rounding RoundingMode) *PriceWeightedAggregationCircuit {
+	return &PriceWeightedAggregationCircuit{
+		MultiAssetSumAggregationCircuit: *NewMultiAssetSumAggregationCircuit(nbAccounts, nbAssets),
+		Prices:                          make([]frontend.Variable, nbAssets),
+		PriceDecimals:                   PRICE_DECIMALS,
+		Rounding:                        rounding,
+	}
+}
+
+// NewPriceWeightedAssignment builds the witness assignment from asset-tagged
+// entries, like NewMultiAssetAssignment, and the price of every asset of the
+// list with the given number of decimals, which must be the PriceDecimals of
+// the circuit
+func NewPriceWeightedAssignment(assets []Asset, entries []AssetBalance, nbAccounts int, prices []*big.Int, decimals int, rounding RoundingMode) (*PriceWeightedAggregationCircuit, error) {
+	if len(prices) != len(assets) {
+		return nil, errors.New("expected one price per asset")
+	}
+	for j, price := range prices {
+		if price.Sign() < 0 || price.BitLen() > PRICE_BITS {
+			return nil, fmt.Errorf("price of %s does not fit in %d bits", assets[j], PRICE_BITS)
+		}
+	}
+	multiAsset, err := NewMultiAssetAssignment(assets, entries, nbAccounts)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSums := make([]*big.Int, len(assets))
+	assignment := &PriceWeightedAggregationCircuit{
+		MultiAssetSumAggregationCircuit: *multiAsset,
+		Prices:                          make([]frontend.Variable, len(assets)),
+	}
+	for j := range assets {
+		totalSums[j] = variableToBigInt(multiAsset.TotalSums[j])
+		assignment.Prices[j] = prices[j]
+	}
+	assignment.TotalValue, err = WeightedValue(totalSums, prices, decimals, rounding)
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+func (circuit *PriceWeightedAggregationCircuit) Define(api frontend.API) error {
+	// Prove the per-asset totals first
+	if err := circuit.MultiAssetSumAggregationCircuit.Define(api); err != nil {
+		return err
+	}
+	nbAssets := len(circuit.TotalSums)
+	if len(circuit.Prices) != nbAssets {
+		return errors.New("expected one price per asset")
+	}
+
+	priceBits := PRICE_BITS
+	if circuit.PriceBits != 0 {
+		priceBits = circuit.PriceBits
+	}
+	decimals := circuit.PriceDecimals
+	// Bound the exact weighted sum, and the total value scaled back by
+	// 10^decimals, well below the field size, so that the division below is
+	// the same over the integers and in the field
+	scale := fixedPointScale(decimals)
+	valueBits := balanceBitsOrDefault(circuit.BalanceBits) + bits.Len(uint(len(circuit.Balances))) + priceBits + bits.Len(uint(nbAssets))
+	if priceBits <= 0 || decimals < 0 || valueBits+scale.BitLen()+1 >= api.Compiler().FieldBitLen() {
+		return fmt.Errorf("the weighted sum of %d-bit prices with %d decimals may overflow the scalar field", priceBits, decimals)
+	}
+
+	weightedSum := frontend.Variable(0)
+	for j := 0; j < nbAssets; j++ {
+		api.ToBinary(circuit.Prices[j], priceBits)
+		weightedSum = api.Add(weightedSum, api.Mul(circuit.TotalSums[j], circuit.Prices[j]))
+	}
+
+	// Round with a floor division of the offset weighted sum:
+	// weightedSum + offset = TotalValue * 10^decimals + remainder, where the
+	// remainder is in [0, 10^decimals)
+	offset, err := roundingOffset(circuit.Rounding, scale)
+	if err != nil {
+		return err
+	}
+	dividend := api.Add(weightedSum, offset)
+	outputs, err := api.Compiler().NewHint(fixedPointDivisionHint, 2, dividend, scale)
+	if err != nil {
+		return err
+	}
+	remainder := outputs[1]
+	api.AssertIsLessOrEqual(remainder, new(big.Int).Sub(scale, big.NewInt(1)))
+	api.ToBinary(circuit.TotalValue, valueBits)
+	api.AssertIsEqual(api.Add(api.Mul(circuit.TotalValue, scale), remainder), dividend)
+	return nil
+}
+
+// fixedPointDivisionHint computes the quotient and remainder of the floor
+// division of inputs[0] by inputs[1]
+func fixedPointDivisionHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	if len(inputs) != 2 || len(outputs) != 2 {
+		return errors.New("expected a dividend and a divisor")
+	}
+	if inputs[1].Sign() == 0 {
+		return errors.New("division by zero")
+	}
+	outputs[0].DivMod(inputs[0], inputs[1], outputs[1])
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/test"
+)
+
+// testPrices are the values in USD cents of one satoshi, one gwei and one
+// micro-USDC, with PRICE_DECIMALS decimals
+func testPrices(t *testing.T) []*big.Int {
+	prices := make([]*big.Int, len(testAssets))
+	for j, price := range []string{"0.06425012", "0.00031", "0.0001"} {
+		p, err := ParseFixedPoint(price, PRICE_DECIMALS)
+		if err != nil {
+			t.Fatalf("Failed to parse price: %v", err)
+		}
+		prices[j] = p
+	}
+	return prices
+}
+
+func TestParseFixedPoint(t *testing.T) {
+
+	valid := map[string]int64{"64250.12": 6425012000000, "0.5": 50000000, "3": 300000000, ".25": 25000000, "1.00000001": 100000001}
+	for value, expected := range valid {
+		result, err := ParseFixedPoint(value, PRICE_DECIMALS)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", value, err)
+		}
+		if result.Int64() != expected {
+			t.Fatalf("Parsed %s as %v instead of %d", value, result, expected)
+		}
+	}
+	for _, value := range []string{"", "1.000000001", "-1", "1e8", "1.2.3", "+1"} {
+		if _, err := ParseFixedPoint(value, PRICE_DECIMALS); err == nil {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestWeightedValueRounding(t *testing.T) {
+
+	// 3 * 0.5 + 1 * 0.25 = 1.75 with 2 decimals
+	totalSums := []*big.Int{big.NewInt(3), big.NewInt(1)}
+	prices := []*big.Int{big.NewInt(50), big.NewInt(25)}
+	// 1 * 0.5 = 0.5, exactly half a unit
+	halfSums := []*big.Int{big.NewInt(1), big.NewInt(0)}
+
+	testCases := []struct {
+		rounding  RoundingMode
+		totalSums []*big.Int
+		expected  int64
+	}{
+		{RoundUp, totalSums, 2},
+		{RoundDown, totalSums, 1},
+		{RoundHalfUp, totalSums, 2},
+		{RoundUp, halfSums, 1},
+		{RoundDown, halfSums, 0},
+		{RoundHalfUp, halfSums, 1},
+		{RoundUp, []*big.Int{big.NewInt(2), big.NewInt(0)}, 1},
+		{RoundDown, []*big.Int{big.NewInt(2), big.NewInt(0)}, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rounding.String(), func(t *testing.T) {
+			value, err := WeightedValue(tc.totalSums, prices, 2, tc.rounding)
+			if err != nil {
+				t.Fatalf("Failed to compute value: %v", err)
+			}
+			if value.Int64() != tc.expected {
+				t.Fatalf("Expected %d, got %v", tc.expected, value)
+			}
+
+			// The circuit enforces the same rounding
+			circuit := NewPriceWeightedAggregationCircuit(1, 2, tc.rounding)
+			circuit.PriceDecimals = 2
+			assignment := NewPriceWeightedAggregationCircuit(1, 2, tc.rounding)
+			for j := range tc.totalSums {
+				assignment.Balances[0][j] = tc.totalSums[j]
+				assignment.TotalSums[j] = tc.totalSums[j]
+				assignment.Prices[j] = prices[j]
+			}
+			for _, candidate := range []int64{tc.expected - 1, tc.expected, tc.expected + 1} {
+				assignment.TotalValue = candidate
+				err := test.IsSolved(circuit, assignment, ecc.BLS12_381.ScalarField())
+				if candidate == tc.expected && err != nil {
+					t.Fatalf("Expected the circuit to be solved: %v", err)
+				}
+				if candidate != tc.expected && err == nil {
+					t.Fatalf("Expected the circuit not to be solved with total value %d", candidate)
+				}
+			}
+		})
+	}
+}
+
+func TestPriceWeightedProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	prices := testPrices(t)
+	assignment, err := NewPriceWeightedAssignment(testAssets, createAssetBalances(nbAccounts), nbAccounts, prices, PRICE_DECIMALS, RoundUp)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	cs, err := testProvingBackend(t).Compile(NewPriceWeightedAggregationCircuit(nbAccounts, len(testAssets), RoundUp))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := Verify(proof, vk, pw); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	t.Logf("Proved a total value of %v cents", assignment.TotalValue)
+
+	// The proof does not hold at other prices
+	otherPrices := NewPriceWeightedAggregationCircuit(nbAccounts, len(testAssets), RoundUp)
+	otherPrices.TotalSums = assignment.TotalSums
+	otherPrices.TotalValue = assignment.TotalValue
+	for j := range prices {
+		otherPrices.Prices[j] = new(big.Int).Add(prices[j], big.NewInt(1))
+	}
+	opw, err := frontend.NewWitness(otherPrices, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	if err := Verify(proof, vk, opw); err == nil {
+		t.Fatal("Expected the proof not to verify at other prices")
+	}
+}
+
+func TestPriceWeightedRejectsOutOfRangePrices(t *testing.T) {
+
+	// With a single account holding 2 units of the first asset, a "negative"
+	// price p-1 would bring the value down to -2
+	negativePrice := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(1))
+	negativeValue := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(2))
+
+	testCases := []struct {
+		name       string
+		price      *big.Int
+		totalValue *big.Int
+		valid      bool
+	}{
+		{"ValidPrice", big.NewInt(3), big.NewInt(6), true},
+		{"PriceAboveBitWidth", new(big.Int).Lsh(big.NewInt(1), PRICE_BITS), new(big.Int).Lsh(big.NewInt(2), PRICE_BITS), false},
+		{"WrappedNegativePrice", negativePrice, negativeValue, false},
+	}
+
+	circuit := NewPriceWeightedAggregationCircuit(1, 1, RoundDown)
+	circuit.PriceDecimals = 1
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := NewPriceWeightedAggregationCircuit(1, 1, RoundDown)
+			assignment.Balances[0][0], assignment.TotalSums[0] = 20, 20
+			assignment.Prices[0] = tc.price
+			assignment.TotalValue = tc.totalValue
+			err := test.IsSolved(circuit, assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+
+	if _, err := NewPriceWeightedAssignment(testAssets, nil, 1, []*big.Int{big.NewInt(1), big.NewInt(-1), big.NewInt(1)}, PRICE_DECIMALS, RoundUp); err == nil {
+		t.Fatal("Expected a negative price to be rejected")
+	}
+
+	// The total value scaled back by 10^40 may wrap around the field even
+	// though the weighted sum does not
+	tooManyDecimals := NewPriceWeightedAggregationCircuit(1, 1, RoundDown)
+	tooManyDecimals.PriceDecimals = 40
+	if _, err := frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, tooManyDecimals); err == nil {
+		t.Fatal("Expected the circuit not to compile with 40 decimals")
+	}
+}
+
+func TestPriceWeightedAssignmentUsesTheGivenDecimals(t *testing.T) {
+
+	prices := []*big.Int{big.NewInt(150), big.NewInt(0), big.NewInt(0)}
+	entries := []AssetBalance{{Address: randomEthereumAddress(), Asset: testAssets[0], Balance: big.NewInt(3)}}
+	for _, decimals := range []int{0, 1, 2} {
+		assignment, err := NewPriceWeightedAssignment(testAssets, entries, 1, prices, decimals, RoundDown)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		circuit := NewPriceWeightedAggregationCircuit(1, len(testAssets), RoundDown)
+		circuit.PriceDecimals = decimals
+		if err := test.IsSolved(circuit, assignment, ecc.BLS12_381.ScalarField()); err != nil {
+			t.Fatalf("Expected the circuit to be solved with %d decimals: %v", decimals, err)
+		}
+	}
+}
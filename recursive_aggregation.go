@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/sw_bls12377"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/std/math/emulated"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+// RECURSION_INNER_CURVE and RECURSION_OUTER_CURVE form the 2-chain on which
+// IndividualBalanceCircuit proofs are aggregated recursively: the scalar
+// field of BW6-761 is the base field of BLS12-377, so BLS12-377 proofs are
+// verified in a BW6-761 circuit with native arithmetic.
+const RECURSION_INNER_CURVE = ecc.BLS12_377
+const RECURSION_OUTER_CURVE = ecc.BW6_761
+
+type (
+	innerScalarField  = sw_bls12377.ScalarField
+	innerProof        = stdgroth16.Proof[sw_bls12377.G1Affine, sw_bls12377.G2Affine]
+	innerVerifyingKey = stdgroth16.VerifyingKey[sw_bls12377.G1Affine, sw_bls12377.G2Affine, sw_bls12377.GT]
+)
+
+// RecursiveAggregatedBalanceCircuit verifies in-circuit a batch of
+// IndividualBalanceCircuit Groth16 proofs made on RECURSION_INNER_CURVE and is
+// itself proven on RECURSION_OUTER_CURVE. Its public inputs are the
+// commitments the batch was proven against, their aggregate and the snapshot
+// every proof of the batch is bound to, so a verifier checks a single proof
+// instead of one per account.
+type RecursiveAggregatedBalanceCircuit struct {
+	Proofs          []innerProof           `gnark:"proofs,secret"`
+	Commitments     []twistededwards.Point `gnark:"commitments,public"`
+	TotalCommitment twistededwards.Point   `gnark:"total_commitment,public"`
+	Epoch           frontend.Variable      `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest  frontend.Variable      `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	VerifyingKey    innerVerifyingKey      `gnark:"-"`                      // Verifying key of IndividualBalanceCircuit, fixed in the circuit
+	Scheme          CommitmentScheme       `gnark:"-"`                      // Scheme of the individual commitments
+}
+
+// NewRecursiveAggregatedBalanceCircuit returns the circuit aggregating
+// nbProofs proofs of the compiled IndividualBalanceCircuit innerCcs, to be
+// verified with innerVk. Only Pedersen commitments can be aggregated.
+func NewRecursiveAggregatedBalanceCircuit(innerCcs constraint.ConstraintSystem, innerVk groth16.VerifyingKey, nbProofs int, scheme CommitmentScheme) (*RecursiveAggregatedBalanceCircuit, error) {
+	if err := checkHomomorphic(scheme); err != nil {
+		return nil, err
+	}
+	verifyingKey, err := stdgroth16.ValueOfVerifyingKeyFixed[sw_bls12377.G1Affine, sw_bls12377.G2Affine, sw_bls12377.GT](innerVk)
+	if err != nil {
+		return nil, err
+	}
+	circuit := &RecursiveAggregatedBalanceCircuit{
+		Proofs:       make([]innerProof, nbProofs),
+		Commitments:  make([]twistededwards.Point, nbProofs),
+		VerifyingKey: verifyingKey,
+		Scheme:       scheme,
+	}
+	for i := range circuit.Proofs {
+		circuit.Proofs[i] = stdgroth16.PlaceholderProof[sw_bls12377.G1Affine, sw_bls12377.G2Affine](innerCcs)
+	}
+	return circuit, nil
+}
+
+// NewRecursiveAggregatedBalanceAssignment returns the witness assignment for
+// the proofs of the individual commitments, which must be given in the same
+// order, made for the given snapshot
+func NewRecursiveAggregatedBalanceAssignment(proofs []groth16.Proof, commitments []twistededwards.Point, epoch uint64, snapshotDigest *big.Int, scheme CommitmentScheme) (*RecursiveAggregatedBalanceCircuit, error) {
+	if len(proofs) != len(commitments) {
+		return nil, errors.New("expected one commitment per proof")
+	}
+	totalCommitment, err := AggregateCommitmentsOnCurve(RECURSION_INNER_CURVE, scheme, commitments)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := &RecursiveAggregatedBalanceCircuit{
+		Proofs:          make([]innerProof, len(proofs)),
+		Commitments:     commitments,
+		TotalCommitment: totalCommitment,
+		Epoch:           epoch,
+		SnapshotDigest:  snapshotDigest,
+	}
+	for i, proof := range proofs {
+		assignment.Proofs[i], err = stdgroth16.ValueOfProof[sw_bls12377.G1Affine, sw_bls12377.G2Affine](proof)
+		if err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+	}
+	return assignment, nil
+}
+
+func (circuit *RecursiveAggregatedBalanceCircuit) Define(api frontend.API) error {
+	if len(circuit.Proofs) != len(circuit.Commitments) {
+		return errors.New("expected one commitment per proof")
+	}
+	verifier, err := stdgroth16.NewVerifier[innerScalarField, sw_bls12377.G1Affine, sw_bls12377.G2Affine, sw_bls12377.GT](api)
+	if err != nil {
+		return err
+	}
+	scalars, err := emulated.NewField[innerScalarField](api)
+	if err != nil {
+		return err
+	}
+
+	// Every proof of the batch is bound to the same snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+	epoch := toInnerScalar(api, scalars, circuit.Epoch)
+	snapshotDigest := toInnerScalar(api, scalars, circuit.SnapshotDigest)
+
+	commitments := make([]emulatedPoint, len(circuit.Commitments))
+	for i, commitment := range circuit.Commitments {
+		commitments[i] = emulatedPoint{
+			X: toInnerScalar(api, scalars, commitment.X),
+			Y: toInnerScalar(api, scalars, commitment.Y),
+		}
+
+		// The public inputs of IndividualBalanceCircuit are the commitment and
+		// the snapshot. Complete arithmetic is needed as an input may be zero.
+		witness := stdgroth16.Witness[innerScalarField]{
+			Public: []emulated.Element[innerScalarField]{*commitments[i].X, *commitments[i].Y, *epoch, *snapshotDigest},
+		}
+		if err := verifier.AssertProof(circuit.VerifyingKey, circuit.Proofs[i], witness, stdgroth16.WithCompleteArithmetic()); err != nil {
+			return err
+		}
+	}
+
+	// Sum the commitments in the scalar field of the inner curve, the way
+	// AggregatedBalanceCircuit does on its own curve
+	total, err := aggregateEmulatedCommitments(scalars, circuit.Scheme, commitments)
+	if err != nil {
+		return err
+	}
+	scalars.AssertIsEqual(total.X, toInnerScalar(api, scalars, circuit.TotalCommitment.X))
+	scalars.AssertIsEqual(total.Y, toInnerScalar(api, scalars, circuit.TotalCommitment.Y))
+	return nil
+}
+
+// emulatedPoint is a point of the twisted Edwards curve embedded in the inner
+// scalar field, whose coordinates are emulated in the outer circuit
+type emulatedPoint struct {
+	X, Y *emulated.Element[innerScalarField]
+}
+
+// toInnerScalar converts a native variable holding an inner scalar field
+// element into its emulated form. The value must be reduced, so that a public
+// input cannot be replaced by another representative of the same element.
+func toInnerScalar(api frontend.API, scalars *emulated.Field[innerScalarField], v frontend.Variable) *emulated.Element[innerScalarField] {
+	element := scalars.FromBits(api.ToBinary(v, RECURSION_INNER_CURVE.ScalarField().BitLen())...)
+	scalars.AssertIsInRange(element)
+	return element
+}
+
+// aggregateEmulatedCommitments sums emulated Pedersen commitments. Other
+// schemes cannot be aggregated.
+func aggregateEmulatedCommitments(scalars *emulated.Field[innerScalarField], scheme CommitmentScheme, commitments []emulatedPoint) (emulatedPoint, error) {
+	if err := checkHomomorphic(scheme); err != nil {
+		return emulatedPoint{}, err
+	}
+	edwardsID, err := edwardsCurveOf(RECURSION_INNER_CURVE)
+	if err != nil {
+		return emulatedPoint{}, err
+	}
+	params, err := twistededwards.GetCurveParams(edwardsID)
+	if err != nil {
+		return emulatedPoint{}, err
+	}
+	total := emulatedPoint{X: scalars.Zero(), Y: scalars.One()}
+	for _, commitment := range commitments {
+		total = addEmulatedEdwards(scalars, params, total, commitment)
+	}
+	return total, nil
+}
+
+// addEmulatedEdwards adds two points with the affine twisted Edwards law
+//
+//	x3 = (x1*y2 + y1*x2) / (1 + d*x1*x2*y1*y2)
+//	y3 = (y1*y2 - a*x1*x2) / (1 - d*x1*x2*y1*y2)
+//
+// which is complete as d is not a square.
+func addEmulatedEdwards(scalars *emulated.Field[innerScalarField], params *twistededwards.CurveParams, p, q emulatedPoint) emulatedPoint {
+	x1x2 := scalars.Mul(p.X, q.X)
+	y1y2 := scalars.Mul(p.Y, q.Y)
+	x1y2 := scalars.Mul(p.X, q.Y)
+	y1x2 := scalars.Mul(p.Y, q.X)
+	field := RECURSION_INNER_CURVE.ScalarField()
+	dxy := scalars.MulConst(scalars.Mul(x1x2, y1y2), new(big.Int).Mod(params.D, field))
+	ax := scalars.MulConst(x1x2, new(big.Int).Mod(params.A, field))
+
+	return emulatedPoint{
+		X: scalars.Div(scalars.Add(x1y2, y1x2), scalars.Add(scalars.One(), dxy)),
+		Y: scalars.Div(scalars.Sub(y1y2, ax), scalars.Sub(scalars.One(), dxy)),
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+	"github.com/consensys/gnark/test"
+)
+
+// createInnerProofs proves nbProofs random balances with IndividualBalanceCircuit
+// and Pedersen commitments on RECURSION_INNER_CURVE, the way they are proven
+// for recursive aggregation
+func createInnerProofs(nbProofs int) (constraint.ConstraintSystem, groth16.VerifyingKey, []groth16.Proof, []twistededwards.Point, error) {
+
+	innerField := RECURSION_INNER_CURVE.ScalarField()
+	cs, err := frontend.Compile(innerField, r1cs.NewBuilder, NewIndividualBalanceCircuit(PedersenScheme))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	proofs := make([]groth16.Proof, nbProofs)
+	commitments := make([]twistededwards.Point, nbProofs)
+	for i := 0; i < nbProofs; i++ {
+		balance := big.NewInt(int64(rand.Int64()))
+		blinding, err := RandomBlindingOnCurve(RECURSION_INNER_CURVE)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		accountHash := AccountHashOnCurve(RECURSION_INNER_CURVE, randomEthereumAddress())
+		commitments[i], err = PrecomputePedersenCommitmentOnCurve(RECURSION_INNER_CURVE, balance, blinding, accountHash)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		assignment := &IndividualBalanceCircuit{
+			Balance:        balance,
+			Blinding:       blinding,
+			AccountHash:    accountHash,
+			Commitment:     []frontend.Variable{commitments[i].X, commitments[i].Y},
+			Epoch:          testEpoch,
+			SnapshotDigest: testSnapshotDigest,
+		}
+		fw, err := frontend.NewWitness(assignment, innerField)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		// The inner proof must be made with the hash the outer circuit can
+		// recompute
+		proofs[i], err = groth16.Prove(cs, pk, fw, stdgroth16.GetNativeProverOptions(RECURSION_OUTER_CURVE.ScalarField(), innerField))
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	return cs, vk, proofs, commitments, nil
+}
+
+func TestRecursiveAggregationProofAndVerification(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("Skipping the BW6-761 setup in short mode")
+	}
+
+	var err error
+	var innerCs constraint.ConstraintSystem
+	var innerVk groth16.VerifyingKey
+	var innerProofs []groth16.Proof
+	var commitments []twistededwards.Point
+	var cs constraint.ConstraintSystem
+	var pk groth16.ProvingKey
+	var vk groth16.VerifyingKey
+	var fw witness.Witness
+	var pw witness.Witness
+	var proof groth16.Proof
+
+	t.Run("GenerateInnerProofs", func(t *testing.T) {
+
+		innerCs, innerVk, innerProofs, commitments, err = createInnerProofs(NB_RECURSIVE_PROOFS)
+		if err != nil {
+			t.Fatalf("Failed to generate inner proofs: %v", err)
+		}
+		t.Logf("Generated %d inner proofs on %v", len(innerProofs), RECURSION_INNER_CURVE)
+	})
+
+	t.Run("CompileCircuitAndCompleteSetup", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because the inner proofs could not be generated")
+		}
+
+		circuit, err := NewRecursiveAggregatedBalanceCircuit(innerCs, innerVk, NB_RECURSIVE_PROOFS, PedersenScheme)
+		if err != nil {
+			t.Fatalf("Failed to define circuit: %v", err)
+		}
+
+		cs, err = frontend.Compile(RECURSION_OUTER_CURVE.ScalarField(), r1cs.NewBuilder, circuit)
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		t.Logf("Recursive circuit has %d constraints", cs.GetNbConstraints())
+
+		pk, vk, err = groth16.Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		t.Log("Circuit compiled and keys generated successfully!")
+	})
+
+	t.Run("CreateWitnessAndGenerateProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization failed")
+		}
+
+		assignment, err := NewRecursiveAggregatedBalanceAssignment(innerProofs, commitments, testEpoch, testSnapshotDigest, PedersenScheme)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		fw, err = frontend.NewWitness(assignment, RECURSION_OUTER_CURVE.ScalarField())
+		if err != nil {
+			t.Fatalf("Failed to create witness: %v", err)
+		}
+		pw, err = fw.Public()
+		if err != nil {
+			t.Fatalf("Failed to create public witness: %v", err)
+		}
+
+		proof, err = groth16.Prove(cs, pk, fw)
+		if err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		t.Log("Proof generated successfully!")
+	})
+
+	t.Run("VerifyProof", func(t *testing.T) {
+
+		if t.Failed() {
+			t.Skip("Skipping because initialization or proof generation failed")
+		}
+
+		err = groth16.Verify(proof, vk, pw)
+		if err != nil {
+			t.Fatalf("Failed to verify proof: %v", err)
+		}
+		t.Log("Proof verified successfully!")
+	})
+}
+
+func TestRecursiveAggregationRejectsInvalidBatches(t *testing.T) {
+
+	innerCs, innerVk, innerProofs, commitments, err := createInnerProofs(NB_RECURSIVE_PROOFS)
+	if err != nil {
+		t.Fatalf("Failed to generate inner proofs: %v", err)
+	}
+	circuit, err := NewRecursiveAggregatedBalanceCircuit(innerCs, innerVk, NB_RECURSIVE_PROOFS, PedersenScheme)
+	if err != nil {
+		t.Fatalf("Failed to define circuit: %v", err)
+	}
+
+	newAssignment := func(t *testing.T, commitments []twistededwards.Point) *RecursiveAggregatedBalanceCircuit {
+		assignment, err := NewRecursiveAggregatedBalanceAssignment(innerProofs, commitments, testEpoch, testSnapshotDigest, PedersenScheme)
+		if err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+		return assignment
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(t *testing.T) *RecursiveAggregatedBalanceCircuit
+		valid  bool
+	}{
+		{"ValidBatch", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			return newAssignment(t, commitments)
+		}, true},
+		{"WrongTotalCommitment", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			assignment := newAssignment(t, commitments)
+			assignment.TotalCommitment.X = new(big.Int).Add(variableToBigInt(assignment.TotalCommitment.X), big.NewInt(1))
+			return assignment
+		}, false},
+		{"CommitmentsOfOtherProofs", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			// The total still matches, only the order of the commitments changed
+			return newAssignment(t, []twistededwards.Point{commitments[1], commitments[0]})
+		}, false},
+		{"ProofsOfAnotherEpoch", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			assignment := newAssignment(t, commitments)
+			assignment.Epoch = testEpoch + 1
+			return assignment
+		}, false},
+		{"ProofsOfAnotherSnapshot", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			assignment := newAssignment(t, commitments)
+			assignment.SnapshotDigest = SnapshotDigest([]byte("another dataset"))
+			return assignment
+		}, false},
+		{"UnreducedCommitment", func(t *testing.T) *RecursiveAggregatedBalanceCircuit {
+			assignment := newAssignment(t, commitments)
+			assignment.Commitments = append([]twistededwards.Point{}, commitments...)
+			assignment.Commitments[0].X = new(big.Int).Add(variableToBigInt(commitments[0].X), RECURSION_INNER_CURVE.ScalarField())
+			return assignment
+		}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := test.IsSolved(circuit, tc.modify(t), RECURSION_OUTER_CURVE.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
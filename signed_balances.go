@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sort"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+// SignedBalance is the balance of a margin or lending account, negative when
+// the customer owes the exchange
+type SignedBalance struct {
+	Address string   `json:"address"`
+	Balance *big.Int `json:"balance"`
+	Netting bool     `json:"netting"` // Whether a debt of the account may offset the liabilities
+}
+
+// SignedSumAggregationCircuit aggregates balances carried as a sign bit and a
+// magnitude. Positive balances add up to the liabilities and negative ones to
+// the customer debts, published separately so that a debt never offsets the
+// assets of other customers. Netting is enabled per account with a public
+// flag, chosen by the verifier: the debt of a netting account is deducted
+// from the liabilities instead.
+//
+// Every slot is bound to an account: the account hashes are public, in
+// strictly increasing order, so the netting flag of a slot is the one of a
+// known account and no account is counted twice. The signed balance of every
+// slot is bound by the public commitment MiMC(balance, blinding, accountHash),
+// a debt hashed as the field element r - |balance|, which the customer checks
+// with PrecomputeHashCommitment: flipping a balance into a debt changes the
+// commitment. Unlike the other sum circuits, the published account hashes
+// reveal the number of accounts.
+type SignedSumAggregationCircuit struct {
+	Magnitudes       []frontend.Variable `gnark:"magnitudes,secret"`        // Absolute values of the balances
+	Negative         []frontend.Variable `gnark:"negative,secret"`          // Sign bit of every balance, 1 for a debt
+	Blindings        []frontend.Variable `gnark:"blindings,secret"`         // Blinding of the commitment of every balance
+	Active           []frontend.Variable `gnark:"active,secret"`            // Whether every slot holds an account
+	AccountHashes    []frontend.Variable `gnark:"account_hashes,public"`    // Account of every balance, in strictly increasing order
+	Commitments      []frontend.Variable `gnark:"commitments,public"`       // Commitment to the signed balance of every account
+	Netting          []frontend.Variable `gnark:"netting,public"`           // Whether every account nets its debt
+	TotalLiabilities frontend.Variable   `gnark:"total_liabilities,public"` // Positive balances, less the netted debts
+	TotalDebts       frontend.Variable   `gnark:"total_debts,public"`       // Debts that are not netted
+	Epoch            frontend.Variable   `gnark:"epoch,public"`             // Epoch the snapshot was taken at
+	SnapshotDigest   frontend.Variable   `gnark:"snapshot_digest,public"`   // Digest of the input dataset
+	BalanceBits      int                 `gnark:"-"`                        // Bit width of every magnitude (BALANCE_BITS if unset)
+}
+
+// NewSignedSumAggregationCircuit returns the circuit with capacity slots
+func NewSignedSumAggregationCircuit(capacity int) *SignedSumAggregationCircuit {
+	return &SignedSumAggregationCircuit{
+		Magnitudes:    make([]frontend.Variable, capacity),
+		Negative:      make([]frontend.Variable, capacity),
+		Blindings:     make([]frontend.Variable, capacity),
+		Active:        make([]frontend.Variable, capacity),
+		AccountHashes: make([]frontend.Variable, capacity),
+		Commitments:   make([]frontend.Variable, capacity),
+		Netting:       make([]frontend.Variable, capacity),
+	}
+}
+
+// SignedTotals natively computes the liabilities and debts of the balances
+// the way SignedSumAggregationCircuit does
+func SignedTotals(balances []SignedBalance) (liabilities, debts *big.Int, err error) {
+	liabilities, debts = big.NewInt(0), big.NewInt(0)
+	for _, balance := range balances {
+		if balance.Balance == nil || balance.Balance.BitLen() > BALANCE_BITS {
+			return nil, nil, fmt.Errorf("balance of account %s does not fit in %d bits", balance.Address, BALANCE_BITS)
+		}
+		switch {
+		case balance.Balance.Sign() >= 0:
+			liabilities.Add(liabilities, balance.Balance)
+		case balance.Netting:
+			liabilities.Add(liabilities, balance.Balance)
+		default:
+			debts.Sub(debts, balance.Balance)
+		}
+	}
+	if liabilities.Sign() < 0 {
+		return nil, nil, errors.New("the netted debts exceed the positive balances")
+	}
+	return liabilities, debts, nil
+}
+
+// NewSignedSumAggregationAssignment builds the witness assignment of a circuit
+// of capacity slots for the given snapshot, with a fresh random blinding per
+// account. The accounts are sorted by account hash and an account listed
+// twice is rejected with ErrDuplicateAccount, like in
+// NewSumAggregationAssignment. The remaining slots are inactive.
+func NewSignedSumAggregationAssignment(balances []SignedBalance, capacity int, epoch uint64, snapshotDigest *big.Int) (*SignedSumAggregationCircuit, error) {
+	if len(balances) > capacity {
+		return nil, fmt.Errorf("got %d balances for a circuit of %d slots", len(balances), capacity)
+	}
+	liabilities, debts, err := SignedTotals(balances)
+	if err != nil {
+		return nil, err
+	}
+	type hashedBalance struct {
+		SignedBalance
+		accountHash *big.Int
+	}
+
+	hashed := make([]hashedBalance, len(balances))
+	for i, balance := range balances {
+		accountHash, err := accountHashOfAddressOnCurve(ecc.BLS12_381, balance.Address)
+		if err != nil {
+			return nil, err
+		}
+		hashed[i] = hashedBalance{balance, accountHash}
+	}
+	sort.SliceStable(hashed, func(i, j int) bool {
+		return hashed[i].accountHash.Cmp(hashed[j].accountHash) < 0
+	})
+
+	assignment := NewSignedSumAggregationCircuit(capacity)
+	assignment.Epoch, assignment.SnapshotDigest = epoch, snapshotDigest
+	lastAccountHash := big.NewInt(0)
+	for i, balance := range hashed {
+		if i > 0 {
+			previous := hashed[i-1]
+			if err := checkAccountOrder(previous.Address, previous.accountHash, balance.Address, balance.accountHash); err != nil {
+				return nil, err
+			}
+		}
+		blinding, err := RandomBlinding()
+		if err != nil {
+			return nil, err
+		}
+		commitment, err := PrecomputeHashCommitment(balance.Balance, blinding, balance.accountHash)
+		if err != nil {
+			return nil, err
+		}
+		assignment.Magnitudes[i], assignment.Negative[i], assignment.Netting[i] = new(big.Int).Abs(balance.Balance), 0, 0
+		if balance.Balance.Sign() < 0 {
+			assignment.Negative[i] = 1
+		}
+		if balance.Netting {
+			assignment.Netting[i] = 1
+		}
+		assignment.Blindings[i] = blinding
+		assignment.Active[i] = 1
+		assignment.AccountHashes[i] = balance.accountHash
+		assignment.Commitments[i] = new(big.Int).SetBytes(commitment)
+		lastAccountHash = balance.accountHash
+	}
+
+	// Inactive slots repeat the last account hash and commit to a zero
+	// balance with a zero blinding
+	emptyCommitment, err := PrecomputeHashCommitment(big.NewInt(0), big.NewInt(0), lastAccountHash)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(hashed); i < capacity; i++ {
+		assignment.Magnitudes[i], assignment.Negative[i], assignment.Netting[i] = 0, 0, 0
+		assignment.Blindings[i] = 0
+		assignment.Active[i] = 0
+		assignment.AccountHashes[i] = lastAccountHash
+		assignment.Commitments[i] = new(big.Int).SetBytes(emptyCommitment)
+	}
+	assignment.TotalLiabilities, assignment.TotalDebts = liabilities, debts
+	return assignment, nil
+}
+
+func (circuit *SignedSumAggregationCircuit) Define(api frontend.API) error {
+	nbAccounts := len(circuit.Magnitudes)
+	if len(circuit.Negative) != nbAccounts || len(circuit.Blindings) != nbAccounts || len(circuit.Active) != nbAccounts ||
+		len(circuit.AccountHashes) != nbAccounts || len(circuit.Commitments) != nbAccounts || len(circuit.Netting) != nbAccounts {
+		return errors.New("expected one sign bit, blinding, active flag, account hash, commitment and netting flag per balance")
+	}
+
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	// Inactive slots must hold a zero balance
+	assertActiveFlags(api, circuit.Active)
+	for i, magnitude := range circuit.Magnitudes {
+		assertInactiveSlotEmpty(api, circuit.Active[i], magnitude, 0)
+	}
+
+	// Strictly increasing account hashes prove every account is counted once
+	if err := assertStrictlyIncreasing(api, circuit.AccountHashes, circuit.Active); err != nil {
+		return err
+	}
+
+	balanceBits := balanceBitsOrDefault(circuit.BalanceBits)
+	if err := assertBalancesInRange(api, circuit.Magnitudes, balanceBits); err != nil {
+		return err
+	}
+
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	liabilities, debts := frontend.Variable(0), frontend.Variable(0)
+	for i, magnitude := range circuit.Magnitudes {
+		api.AssertIsBoolean(circuit.Negative[i])
+		api.AssertIsBoolean(circuit.Netting[i])
+
+		// The commitment opens to the signed balance, the magnitude or its
+		// opposite in the field
+		debt := api.Mul(circuit.Negative[i], magnitude)
+		hash.Reset()
+		hash.Write(api.Sub(magnitude, api.Mul(2, debt)), circuit.Blindings[i], circuit.AccountHashes[i])
+		api.AssertIsEqual(hash.Sum(), circuit.Commitments[i])
+
+		nettedDebt := api.Mul(circuit.Netting[i], debt)
+		liabilities = api.Add(liabilities, api.Sub(magnitude, debt), api.Neg(nettedDebt))
+		debts = api.Add(debts, api.Sub(debt, nettedDebt))
+	}
+
+	// The netted debts must not exceed the positive balances, which would wrap
+	// the liabilities around the scalar field
+	rangecheck.New(api).Check(circuit.TotalLiabilities, balanceBits+bits.Len(uint(nbAccounts)))
+	api.AssertIsEqual(liabilities, circuit.TotalLiabilities)
+	api.AssertIsEqual(debts, circuit.TotalDebts)
+	return nil
+}
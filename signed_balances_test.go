@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+func TestSignedTotals(t *testing.T) {
+
+	balances := []SignedBalance{
+		{Address: randomEthereumAddress(), Balance: big.NewInt(100)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-30)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-20), Netting: true},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(50), Netting: true},
+	}
+	liabilities, debts, err := SignedTotals(balances)
+	if err != nil {
+		t.Fatalf("Failed to compute totals: %v", err)
+	}
+	if liabilities.Cmp(big.NewInt(130)) != 0 || debts.Cmp(big.NewInt(30)) != 0 {
+		t.Fatalf("Expected liabilities 130 and debts 30, got %v and %v", liabilities, debts)
+	}
+
+	overNetted := []SignedBalance{
+		{Address: randomEthereumAddress(), Balance: big.NewInt(10)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-20), Netting: true},
+	}
+	if _, _, err := SignedTotals(overNetted); err == nil {
+		t.Fatal("Expected an error for netted debts above the positive balances")
+	}
+}
+
+// signedCommitments natively commits to the signed balances of the slots
+// with a single blinding
+func signedCommitments(magnitudes, negative []frontend.Variable, blinding *big.Int, accountHashes []frontend.Variable) ([]frontend.Variable, error) {
+	commitments := make([]frontend.Variable, len(magnitudes))
+	for i := range magnitudes {
+		magnitude := variableToBigInt(magnitudes[i])
+		debt := new(big.Int).Mul(variableToBigInt(negative[i]), magnitude)
+		balance := new(big.Int).Sub(magnitude, debt.Lsh(debt, 1))
+		commitment, err := PrecomputeHashCommitment(balance, blinding, variableToBigInt(accountHashes[i]))
+		if err != nil {
+			return nil, err
+		}
+		commitments[i] = new(big.Int).SetBytes(commitment)
+	}
+	return commitments, nil
+}
+
+// signedTotals recomputes the totals of an assignment from its slots
+func signedTotals(assignment *SignedSumAggregationCircuit) {
+	liabilities, debts := big.NewInt(0), big.NewInt(0)
+	for i := range assignment.Magnitudes {
+		magnitude := variableToBigInt(assignment.Magnitudes[i])
+		debt := new(big.Int).Mul(variableToBigInt(assignment.Negative[i]), magnitude)
+		nettedDebt := new(big.Int).Mul(variableToBigInt(assignment.Netting[i]), debt)
+		liabilities.Add(liabilities, magnitude).Sub(liabilities, debt).Sub(liabilities, nettedDebt)
+		debts.Add(debts, debt).Sub(debts, nettedDebt)
+	}
+	assignment.TotalLiabilities, assignment.TotalDebts = liabilities, debts
+}
+
+func TestSignedSumAggregationProofAndVerification(t *testing.T) {
+
+	const capacity = 8
+	cs, err := testProvingBackend(t).Compile(NewSignedSumAggregationCircuit(capacity))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	balances := []SignedBalance{
+		{Address: randomEthereumAddress(), Balance: big.NewInt(1000)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-300)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-200), Netting: true},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(500)},
+	}
+	assignment, err := NewSignedSumAggregationAssignment(balances, capacity, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	fw, err := frontend.NewWitness(assignment, ecc.BLS12_381.ScalarField())
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+
+	// Every customer finds the commitment to their signed balance under their
+	// account hash
+	for _, balance := range balances {
+		accountHash := AccountHash(balance.Address)
+		found := false
+		for i := range balances {
+			if variableToBigInt(assignment.AccountHashes[i]).Cmp(accountHash) != 0 {
+				continue
+			}
+			commitment, err := PrecomputeHashCommitment(balance.Balance, variableToBigInt(assignment.Blindings[i]), accountHash)
+			if err != nil {
+				t.Fatalf("Failed to compute commitment: %v", err)
+			}
+			found = variableToBigInt(assignment.Commitments[i]).Cmp(new(big.Int).SetBytes(commitment)) == 0
+			break
+		}
+		if !found {
+			t.Fatalf("No commitment to the balance of %s", balance.Address)
+		}
+	}
+
+	nettingSlot := -1
+	for i, netting := range assignment.Netting {
+		if netting == 1 {
+			nettingSlot = i
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(public *SignedSumAggregationCircuit)
+		valid  bool
+	}{
+		{"PublishedTotals", func(public *SignedSumAggregationCircuit) {}, true},
+		{"DebtOffsettingLiabilities", func(public *SignedSumAggregationCircuit) {
+			public.TotalLiabilities = 1000
+		}, false},
+		{"HiddenDebt", func(public *SignedSumAggregationCircuit) {
+			public.TotalDebts = 0
+		}, false},
+		{"NettingDisabled", func(public *SignedSumAggregationCircuit) {
+			public.Netting[nettingSlot] = 0
+		}, false},
+		{"NextEpoch", func(public *SignedSumAggregationCircuit) {
+			public.Epoch = testEpoch + 1
+		}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			public := *assignment
+			public.Netting = append([]frontend.Variable{}, assignment.Netting...)
+			tc.modify(&public)
+			pw, err := frontend.NewWitness(&public, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+			if err != nil {
+				t.Fatalf("Failed to create public witness: %v", err)
+			}
+			err = Verify(proof, vk, pw)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the proof to verify: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the proof not to verify")
+			}
+		})
+	}
+}
+
+func TestSignedSumAggregationBindsSlotsToAccounts(t *testing.T) {
+
+	balances := []SignedBalance{
+		{Address: randomEthereumAddress(), Balance: big.NewInt(1000)},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(-300), Netting: true},
+		{Address: randomEthereumAddress(), Balance: big.NewInt(500), Netting: true},
+	}
+	const capacity = 4
+	valid, err := NewSignedSumAggregationAssignment(balances, capacity, testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	positiveNettingSlot := -1
+	for i := range balances {
+		if valid.Netting[i] == 1 && valid.Negative[i] == 0 {
+			positiveNettingSlot = i
+		}
+	}
+
+	// Each tampering keeps the totals consistent with the slots, so that only
+	// the binding of the slots to the accounts can reject it
+	testCases := []struct {
+		name   string
+		tamper func(assignment *SignedSumAggregationCircuit)
+		valid  bool
+	}{
+		{"Untampered", func(assignment *SignedSumAggregationCircuit) {}, true},
+		{"LiabilityTurnedIntoNettedDebt", func(assignment *SignedSumAggregationCircuit) {
+			assignment.Negative[positiveNettingSlot] = 1
+		}, false},
+		{"LoweredBalance", func(assignment *SignedSumAggregationCircuit) {
+			assignment.Magnitudes[positiveNettingSlot] = 1
+		}, false},
+		{"AccountLeftOut", func(assignment *SignedSumAggregationCircuit) {
+			assignment.Magnitudes[0], assignment.Negative[0], assignment.Active[0] = 0, 0, 0
+		}, false},
+		{"AccountCountedTwice", func(assignment *SignedSumAggregationCircuit) {
+			last := len(balances)
+			assignment.Magnitudes[last], assignment.Negative[last], assignment.Blindings[last] = assignment.Magnitudes[last-1], assignment.Negative[last-1], assignment.Blindings[last-1]
+			assignment.Commitments[last], assignment.Netting[last], assignment.Active[last] = assignment.Commitments[last-1], assignment.Netting[last-1], 1
+		}, false},
+	}
+
+	circuit := NewSignedSumAggregationCircuit(capacity)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := *valid
+			assignment.Magnitudes = append([]frontend.Variable{}, valid.Magnitudes...)
+			assignment.Negative = append([]frontend.Variable{}, valid.Negative...)
+			assignment.Blindings = append([]frontend.Variable{}, valid.Blindings...)
+			assignment.Active = append([]frontend.Variable{}, valid.Active...)
+			assignment.Commitments = append([]frontend.Variable{}, valid.Commitments...)
+			assignment.Netting = append([]frontend.Variable{}, valid.Netting...)
+			tc.tamper(&assignment)
+			signedTotals(&assignment)
+			err := test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
+
+func TestSignedSumAggregationAssignmentRejectsInvalidAccounts(t *testing.T) {
+
+	address := randomEthereumAddress()
+	duplicate := []SignedBalance{
+		{Address: address, Balance: big.NewInt(100)},
+		{Address: address[:2] + strings.ToUpper(address[2:]), Balance: big.NewInt(-10)},
+	}
+	if _, err := NewSignedSumAggregationAssignment(duplicate, 4, testEpoch, testSnapshotDigest); !errors.Is(err, ErrDuplicateAccount) {
+		t.Fatalf("Expected ErrDuplicateAccount, got %v", err)
+	}
+	malformed := []SignedBalance{{Address: "0x1234", Balance: big.NewInt(100)}}
+	if _, err := NewSignedSumAggregationAssignment(malformed, 4, testEpoch, testSnapshotDigest); err == nil || !strings.Contains(err.Error(), "0x1234") {
+		t.Fatalf("Expected an error naming the malformed address, got %v", err)
+	}
+}
+
+func TestSignedSumAggregationRejectsInvalidBalances(t *testing.T) {
+
+	// A "negative" magnitude wraps around the scalar field to p - x
+	wrappedMagnitude := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(30))
+
+	testCases := []struct {
+		name        string
+		magnitudes  []frontend.Variable
+		negative    []frontend.Variable
+		netting     []frontend.Variable
+		liabilities frontend.Variable
+		debts       frontend.Variable
+		valid       bool
+	}{
+		{"SeparateTotals", []frontend.Variable{100, 30, 20}, []frontend.Variable{0, 1, 0}, []frontend.Variable{0, 0, 0}, 120, 30, true},
+		{"NettedDebt", []frontend.Variable{100, 30, 20}, []frontend.Variable{0, 1, 0}, []frontend.Variable{0, 1, 0}, 90, 0, true},
+		{"DebtOffsetWithoutNetting", []frontend.Variable{100, 30, 20}, []frontend.Variable{0, 1, 0}, []frontend.Variable{0, 0, 0}, 90, 0, false},
+		{"NettingOnAPositiveBalance", []frontend.Variable{100, 30, 20}, []frontend.Variable{0, 1, 0}, []frontend.Variable{1, 0, 1}, 120, 30, true},
+		{"NettedDebtAboveAssets", []frontend.Variable{10, 30, 0}, []frontend.Variable{0, 1, 0}, []frontend.Variable{0, 1, 0}, new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(20)), 0, false},
+		{"NonBooleanSign", []frontend.Variable{100, 30, 20}, []frontend.Variable{0, 2, 0}, []frontend.Variable{0, 0, 0}, 90, 60, false},
+		{"WrappedMagnitude", []frontend.Variable{100, wrappedMagnitude, 20}, []frontend.Variable{0, 0, 0}, []frontend.Variable{0, 0, 0}, 90, 0, false},
+	}
+
+	blinding := big.NewInt(42)
+	accountHashes := testAccountHashes(3)
+	circuit := NewSignedSumAggregationCircuit(3)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The commitments open to the signed balances of the witness
+			commitments, err := signedCommitments(tc.magnitudes, tc.negative, blinding, accountHashes)
+			if err != nil {
+				t.Fatalf("Failed to compute commitments: %v", err)
+			}
+			assignment := SignedSumAggregationCircuit{
+				Magnitudes:       tc.magnitudes,
+				Negative:         tc.negative,
+				Blindings:        []frontend.Variable{blinding, blinding, blinding},
+				Active:           activeSlots(3, 3),
+				AccountHashes:    accountHashes,
+				Commitments:      commitments,
+				Netting:          tc.netting,
+				TotalLiabilities: tc.liabilities,
+				TotalDebts:       tc.debts,
+				Epoch:            testEpoch,
+				SnapshotDigest:   testSnapshotDigest,
+			}
+			err = test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
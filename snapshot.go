@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// EPOCH_BITS is the bit width of the epoch identifier every proof is bound to
+const EPOCH_BITS = 64
+
+// SNAPSHOT_DIGEST_BYTES is the number of bytes of the SHA-256 of the input
+// dataset kept in the snapshot digest, so that it fits in the scalar field of
+// every supported curve
+const SNAPSHOT_DIGEST_BYTES = 31
+
+// SnapshotDigest hashes the input dataset of a snapshot, the balances file as
+// it was read, into the snapshot digest the proofs of the snapshot are bound to
+func SnapshotDigest(dataset []byte) *big.Int {
+	hash := sha256.Sum256(dataset)
+	return new(big.Int).SetBytes(hash[:SNAPSHOT_DIGEST_BYTES])
+}
+
+// assertSnapshotBinding constrains the public epoch and snapshot digest that
+// bind a proof to a point in time. Being public inputs, they are checked by
+// the verifier: a proof made for epoch N does not verify for epoch N+1.
+func assertSnapshotBinding(api frontend.API, epoch, snapshotDigest frontend.Variable) {
+	api.ToBinary(epoch, EPOCH_BITS)
+	api.ToBinary(snapshotDigest, 8*SNAPSHOT_DIGEST_BYTES)
+	api.AssertIsDifferent(snapshotDigest, 0)
+}
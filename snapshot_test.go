@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/test"
+)
+
+// The snapshot the test proofs are bound to
+const testEpoch uint64 = 42
+
+var testSnapshotDigest = SnapshotDigest([]byte(`[{"address":"0x0","balance":0}]`))
+
+func TestSnapshotDigest(t *testing.T) {
+	dataset := []byte("balances")
+	digest := SnapshotDigest(dataset)
+	if digest.Cmp(SnapshotDigest(dataset)) != 0 {
+		t.Fatal("Expected the digest to be deterministic")
+	}
+	if digest.BitLen() > 8*SNAPSHOT_DIGEST_BYTES {
+		t.Fatalf("Expected the digest to fit in %d bits", 8*SNAPSHOT_DIGEST_BYTES)
+	}
+	if digest.Cmp(SnapshotDigest([]byte("balances "))) == 0 {
+		t.Fatal("Expected another dataset to have another digest")
+	}
+}
+
+// TestProofsCannotBeReplayed checks that a proof made for a snapshot does not
+// verify against the public witness of another epoch or dataset
+func TestProofsCannotBeReplayed(t *testing.T) {
+
+	blinding, err := RandomBlinding()
+	if err != nil {
+		t.Fatalf("Failed to generate blinding: %v", err)
+	}
+	accountHash := AccountHash(randomEthereumAddress())
+	commitment, err := PrecomputePedersenCommitment(big.NewInt(1000), blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	hashCommitment, err := PrecomputeCommitment(MiMCScheme, big.NewInt(1000), blinding, accountHash)
+	if err != nil {
+		t.Fatalf("Failed to compute commitment: %v", err)
+	}
+	_, totalBlinding, totalAccountHash, err := OpenAggregatedPedersenCommitment([]*big.Int{big.NewInt(1000)}, []*big.Int{blinding}, []*big.Int{accountHash})
+	if err != nil {
+		t.Fatalf("Failed to open commitment: %v", err)
+	}
+
+	// Each circuit is given with an assignment for the test snapshot and a
+	// function rebinding the assignment to another snapshot
+	testCases := []struct {
+		name       string
+		circuit    frontend.Circuit
+		assignment func(epoch uint64, digest *big.Int) frontend.Circuit
+	}{
+		{"SumAggregationCircuit", NewSumAggregationCircuit(2),
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &SumAggregationCircuit{
+					Balances:       []frontend.Variable{10, 20},
+					AccountHashes:  testAccountHashes(2),
+					Active:         activeSlots(2, 2),
+					TotalSum:       30,
+					Epoch:          epoch,
+					SnapshotDigest: digest,
+				}
+			}},
+		{"IndividualBalanceCircuit", NewIndividualBalanceCircuit(PedersenScheme),
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &IndividualBalanceCircuit{
+					Balance:        1000,
+					Blinding:       blinding,
+					AccountHash:    accountHash,
+					Commitment:     []frontend.Variable{commitment.X, commitment.Y},
+					Epoch:          epoch,
+					SnapshotDigest: digest,
+				}
+			}},
+		{"AggregatedBalanceCircuit", &AggregatedBalanceCircuit{Commitments: make([]twistededwards.Point, 1), Active: make([]frontend.Variable, 1)},
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &AggregatedBalanceCircuit{
+					Commitments:     []twistededwards.Point{commitment},
+					Active:          activeSlots(1, 1),
+					TotalCommitment: commitment,
+					Epoch:           epoch,
+					SnapshotDigest:  digest,
+				}
+			}},
+		{"CommittedAggregatedBalanceCircuit", NewCommittedAggregatedBalanceCircuit(1, MiMCScheme),
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &CommittedAggregatedBalanceCircuit{
+					Balances:       []frontend.Variable{1000},
+					Blindings:      []frontend.Variable{blinding},
+					AccountHashes:  []frontend.Variable{accountHash},
+					Commitments:    [][]frontend.Variable{hashCommitment},
+					TotalBalance:   1000,
+					Epoch:          epoch,
+					SnapshotDigest: digest,
+				}
+			}},
+		{"ThresholdSumAggregationCircuit", NewThresholdSumAggregationCircuit(2),
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &ThresholdSumAggregationCircuit{
+					Balances:       []frontend.Variable{10, 20},
+					AccountHashes:  testAccountHashes(2),
+					Active:         activeSlots(2, 2),
+					LowerBound:     25,
+					Epoch:          epoch,
+					SnapshotDigest: digest,
+				}
+			}},
+		{"ThresholdAggregatedBalanceCircuit", &ThresholdAggregatedBalanceCircuit{Commitments: make([]twistededwards.Point, 1)},
+			func(epoch uint64, digest *big.Int) frontend.Circuit {
+				return &ThresholdAggregatedBalanceCircuit{
+					Commitments:      []twistededwards.Point{commitment},
+					TotalBalance:     1000,
+					TotalBlinding:    totalBlinding,
+					TotalAccountHash: [2]frontend.Variable{totalAccountHash[0], totalAccountHash[1]},
+					LowerBound:       500,
+					Epoch:            epoch,
+					SnapshotDigest:   digest,
+				}
+			}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, err := testProvingBackend(t).Compile(tc.circuit)
+			if err != nil {
+				t.Fatalf("Failed to compile circuit: %v", err)
+			}
+			pk, vk, err := testProvingBackend(t).Setup(cs)
+			if err != nil {
+				t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+			}
+			fw, err := frontend.NewWitness(tc.assignment(testEpoch, testSnapshotDigest), ecc.BLS12_381.ScalarField())
+			if err != nil {
+				t.Fatalf("Failed to create witness: %v", err)
+			}
+			proof, err := Prove(cs, pk, fw)
+			if err != nil {
+				t.Fatalf("Failed to generate proof: %v", err)
+			}
+
+			snapshots := []struct {
+				name   string
+				epoch  uint64
+				digest *big.Int
+				valid  bool
+			}{
+				{"SameSnapshot", testEpoch, testSnapshotDigest, true},
+				{"NextEpoch", testEpoch + 1, testSnapshotDigest, false},
+				{"OtherDataset", testEpoch, SnapshotDigest([]byte("another dataset")), false},
+			}
+			for _, snapshot := range snapshots {
+				t.Run(snapshot.name, func(t *testing.T) {
+					pw, err := frontend.NewWitness(tc.assignment(snapshot.epoch, snapshot.digest), ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+					if err != nil {
+						t.Fatalf("Failed to create public witness: %v", err)
+					}
+					err = Verify(proof, vk, pw)
+					if snapshot.valid && err != nil {
+						t.Fatalf("Expected the proof to verify: %v", err)
+					}
+					if !snapshot.valid && err == nil {
+						t.Fatal("Expected the proof not to verify for another snapshot")
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSnapshotBindingRejectsInvalidSnapshots(t *testing.T) {
+
+	testCases := []struct {
+		name   string
+		epoch  frontend.Variable
+		digest frontend.Variable
+		valid  bool
+	}{
+		{"ValidSnapshot", testEpoch, testSnapshotDigest, true},
+		{"EpochAboveBitWidth", new(big.Int).Lsh(big.NewInt(1), EPOCH_BITS), testSnapshotDigest, false},
+		{"ZeroDigest", testEpoch, 0, false},
+		{"DigestAboveBitWidth", testEpoch, new(big.Int).Lsh(big.NewInt(1), 8*SNAPSHOT_DIGEST_BYTES), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := SumAggregationCircuit{
+				Balances:       []frontend.Variable{10, 20},
+				AccountHashes:  testAccountHashes(2),
+				Active:         activeSlots(2, 2),
+				TotalSum:       30,
+				Epoch:          tc.epoch,
+				SnapshotDigest: tc.digest,
+			}
+			err := test.IsSolved(NewSumAggregationCircuit(2), &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	fr_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	groth16_bls12381 "github.com/consensys/gnark/backend/groth16/bls12-381"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// SnarkPack (https://eprint.iacr.org/2021/529) compresses n Groth16 proofs
+// sharing a verifying key into one proof of O(log n) size, verified with
+// O(log n) pairings besides reading the public inputs. With a random r, the
+// aggregate proves that
+//
+//	Π e(A_i, B_i)^(r^i) = e(α, β)^(Σ r^i) · e(Σ r^i·S_i, γ) · e(Σ r^i·C_i, δ)
+//
+// where S_i is the public input term of proof i. The A, B and C of the
+// proofs are committed to with pairing commitments under keys derived from
+// two powers of tau, and an inner product argument (TIPP and MIPP) shows that
+// both sides of the equation are the inner products of the committed vectors.
+// The keys the argument ends with are checked with KZG openings.
+
+// SNARKPACK_CURVE is the curve of the proofs SnarkPack aggregates, the
+// default curve of IndividualBalanceCircuit
+const SNARKPACK_CURVE = ecc.BLS12_381
+
+// snarkPackDST separates the hashes of the SnarkPack transcript from others
+var snarkPackDST = []byte("ZKBA-SNARKPACK-BLS12-381")
+
+// SnarkPackSRS holds the powers of two secrets α and β, which in production
+// come from two independent powers of tau ceremonies. It aggregates up to
+// Size proofs.
+type SnarkPackSRS struct {
+	Size    int
+	g1Alpha []bls12381.G1Affine // g^(α^i) for i < 2·Size
+	g1Beta  []bls12381.G1Affine // g^(β^i) for i < 2·Size
+	g2Alpha []bls12381.G2Affine // h^(α^i) for i < Size
+	g2Beta  []bls12381.G2Affine // h^(β^i) for i < Size
+}
+
+// SnarkPackVerifyingKey is the part of the SRS that verifies aggregate
+// proofs, whatever their number
+type SnarkPackVerifyingKey struct {
+	G1, G1Alpha, G1Beta bls12381.G1Affine
+	G2, G2Alpha, G2Beta bls12381.G2Affine
+}
+
+// NewSnarkPackSRS computes the SRS aggregating up to nbProofs proofs, rounded
+// up to a power of two, from the secrets α and β
+func NewSnarkPackSRS(nbProofs int, alpha, beta *big.Int) (*SnarkPackSRS, error) {
+	if nbProofs < 1 {
+		return nil, fmt.Errorf("invalid number of proofs %d", nbProofs)
+	}
+	size := snarkPackSize(nbProofs)
+
+	_, _, g1, g2 := bls12381.Generators()
+	srs := &SnarkPackSRS{Size: size}
+	powers := func(secret *big.Int, n int) []fr_bls12381.Element {
+		var x fr_bls12381.Element
+		x.SetBigInt(secret)
+		result := make([]fr_bls12381.Element, n)
+		result[0].SetOne()
+		for i := 1; i < n; i++ {
+			result[i].Mul(&result[i-1], &x)
+		}
+		return result
+	}
+	alphaPowers, betaPowers := powers(alpha, 2*size), powers(beta, 2*size)
+	srs.g1Alpha = bls12381.BatchScalarMultiplicationG1(&g1, alphaPowers)
+	srs.g1Beta = bls12381.BatchScalarMultiplicationG1(&g1, betaPowers)
+	srs.g2Alpha = bls12381.BatchScalarMultiplicationG2(&g2, alphaPowers[:size])
+	srs.g2Beta = bls12381.BatchScalarMultiplicationG2(&g2, betaPowers[:size])
+	return srs, nil
+}
+
+// VerifyingKey returns the key with which the aggregate proofs are verified
+func (srs *SnarkPackSRS) VerifyingKey() SnarkPackVerifyingKey {
+	return SnarkPackVerifyingKey{
+		G1: srs.g1Alpha[0], G1Alpha: srs.g1Alpha[1], G1Beta: srs.g1Beta[1],
+		G2: srs.g2Alpha[0], G2Alpha: srs.g2Alpha[1], G2Beta: srs.g2Beta[1],
+	}
+}
+
+// snarkPackSize is the number of proofs actually aggregated, a power of two
+// of at least 2, the last proof being repeated up to it
+func snarkPackSize(nbProofs int) int {
+	if nbProofs <= 2 {
+		return 2
+	}
+	return 1 << bits.Len(uint(nbProofs-1))
+}
+
+// PairingCommitment is a commitment to vectors of group elements, one
+// element of GT per half of the commitment key
+type PairingCommitment [2]bls12381.GT
+
+// snarkPackKeys are the commitment keys of the vectors of G1 (v, in G2) and
+// of G2 (w, in G1), each made of the powers of α and of β
+type snarkPackKeys struct {
+	v [2][]bls12381.G2Affine
+	w [2][]bls12381.G1Affine
+}
+
+func (keys snarkPackKeys) split() (left, right snarkPackKeys) {
+	half := len(keys.v[0]) / 2
+	for k := 0; k < 2; k++ {
+		left.v[k], right.v[k] = keys.v[k][:half], keys.v[k][half:]
+		left.w[k], right.w[k] = keys.w[k][:half], keys.w[k][half:]
+	}
+	return left, right
+}
+
+// commitPairs commits to a vector of G1 and one of G2: Π e(a_i, v_i)·e(w_i, b_i)
+func commitPairs(vKeys snarkPackKeys, wKeys snarkPackKeys, a []bls12381.G1Affine, b []bls12381.G2Affine) (PairingCommitment, error) {
+	var commitment PairingCommitment
+	for k := 0; k < 2; k++ {
+		var err error
+		g1 := append(append([]bls12381.G1Affine{}, a...), wKeys.w[k]...)
+		g2 := append(append([]bls12381.G2Affine{}, vKeys.v[k]...), b...)
+		if commitment[k], err = bls12381.Pair(g1, g2); err != nil {
+			return PairingCommitment{}, err
+		}
+	}
+	return commitment, nil
+}
+
+// commitG1 commits to a vector of G1: Π e(c_i, v_i)
+func commitG1(keys snarkPackKeys, c []bls12381.G1Affine) (PairingCommitment, error) {
+	var commitment PairingCommitment
+	for k := 0; k < 2; k++ {
+		var err error
+		if commitment[k], err = bls12381.Pair(c, keys.v[k]); err != nil {
+			return PairingCommitment{}, err
+		}
+	}
+	return commitment, nil
+}
+
+// SnarkPackRound holds the cross terms of a round of the inner product
+// argument, which halves the committed vectors
+type SnarkPackRound struct {
+	TabL, TabR PairingCommitment // Commitments to the cross terms of A and B
+	TcL, TcR   PairingCommitment // Commitments to the cross terms of C
+	ZabL, ZabR bls12381.GT       // Pairing products of the cross terms of A and B
+	ZcL, ZcR   bls12381.G1Affine
+}
+
+// SnarkPackProof aggregates Groth16 proofs of one verifying key
+type SnarkPackProof struct {
+	NbProofs int
+	ComAB    PairingCommitment // Commitment to the A and B of the proofs
+	ComC     PairingCommitment // Commitment to the C of the proofs
+	ZAB      bls12381.GT       // Π e(A_i, B_i)^(r^i)
+	ZC       bls12381.G1Affine // Σ r^i·C_i
+	Rounds   []SnarkPackRound
+	FinalA   bls12381.G1Affine
+	FinalB   bls12381.G2Affine
+	FinalC   bls12381.G1Affine
+	FinalV   [2]bls12381.G2Affine // Folded commitment key of A and C
+	FinalW   [2]bls12381.G1Affine // Folded commitment key of B
+	OpenV    [2]bls12381.G2Affine // KZG openings of FinalV at the powers of α and β
+	OpenW    [2]bls12381.G1Affine // KZG openings of FinalW at the powers of α and β
+}
+
+// snarkPackTranscript derives the challenges of the aggregation from
+// everything the prover sent before them
+type snarkPackTranscript struct {
+	state []byte
+}
+
+func (transcript *snarkPackTranscript) append(values ...any) {
+	for _, value := range values {
+		switch value := value.(type) {
+		case int:
+			transcript.state = append(transcript.state, fmt.Sprintf("%d", value)...)
+		case PairingCommitment:
+			for k := range value {
+				bytes := value[k].Bytes()
+				transcript.state = append(transcript.state, bytes[:]...)
+			}
+		case bls12381.GT:
+			bytes := value.Bytes()
+			transcript.state = append(transcript.state, bytes[:]...)
+		case bls12381.G1Affine:
+			transcript.state = append(transcript.state, value.Marshal()...)
+		case []bls12381.G1Affine:
+			for i := range value {
+				transcript.state = append(transcript.state, value[i].Marshal()...)
+			}
+		case bls12381.G2Affine:
+			transcript.state = append(transcript.state, value.Marshal()...)
+		case fr_bls12381.Vector:
+			for i := range value {
+				transcript.state = append(transcript.state, value[i].Marshal()...)
+			}
+		default:
+			panic(fmt.Sprintf("cannot append %T to the transcript", value))
+		}
+	}
+}
+
+// newSnarkPackTranscript starts the transcript of an aggregate of proofs of
+// vk with the keys of srsVk. Both keys come first, so that the challenges
+// are bound to the statement being proven and not only to the prover's
+// messages.
+func newSnarkPackTranscript(srsVk SnarkPackVerifyingKey, vk *groth16_bls12381.VerifyingKey) *snarkPackTranscript {
+	transcript := &snarkPackTranscript{}
+	transcript.append(vk.G1.Alpha, vk.G1.Beta, vk.G1.Delta, vk.G1.K, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta)
+	transcript.append(srsVk.G1, srsVk.G1Alpha, srsVk.G1Beta, srsVk.G2, srsVk.G2Alpha, srsVk.G2Beta)
+	return transcript
+}
+
+// challenge returns a non-zero challenge and restarts the transcript from it
+func (transcript *snarkPackTranscript) challenge() (fr_bls12381.Element, error) {
+	challenges, err := fr_bls12381.Hash(transcript.state, snarkPackDST, 1)
+	if err != nil {
+		return fr_bls12381.Element{}, err
+	}
+	if challenges[0].IsZero() {
+		return fr_bls12381.Element{}, errors.New("zero challenge")
+	}
+	transcript.state = challenges[0].Marshal()
+	return challenges[0], nil
+}
+
+// snarkPackStatements pads the public witnesses to the number of aggregated
+// proofs, repeating the last one
+func snarkPackStatements(vk *groth16_bls12381.VerifyingKey, publicWitnesses []witness.Witness) ([]fr_bls12381.Vector, error) {
+	if len(publicWitnesses) == 0 {
+		return nil, errors.New("no proof to aggregate")
+	}
+	statements := make([]fr_bls12381.Vector, snarkPackSize(len(publicWitnesses)))
+	for i := range statements {
+		publicWitness := publicWitnesses[min(i, len(publicWitnesses)-1)]
+		vector, ok := publicWitness.Vector().(fr_bls12381.Vector)
+		if !ok {
+			return nil, fmt.Errorf("%w: public witness %d is not on %v", ErrArtifactMismatch, i, SNARKPACK_CURVE)
+		}
+		if len(vector) != len(vk.G1.K)-1 {
+			return nil, fmt.Errorf("public witness %d has %d inputs, expected %d", i, len(vector), len(vk.G1.K)-1)
+		}
+		statements[i] = vector
+	}
+	return statements, nil
+}
+
+// snarkPackVerifyingKey returns the Groth16 verifying key of proofs SnarkPack
+// can aggregate
+func snarkPackVerifyingKey(vk VerifyingKey) (*groth16_bls12381.VerifyingKey, error) {
+	groth16Vk, ok := vk.(*groth16_bls12381.VerifyingKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: SnarkPack aggregates Groth16 proofs on %v, got a %T verifying key", ErrUnsupportedBackend, SNARKPACK_CURVE, vk)
+	}
+	// The commitments of range checks come with a proof of knowledge that
+	// is not aggregated
+	if len(groth16Vk.CommitmentKeys) > 0 {
+		return nil, errors.New("SnarkPack does not aggregate proofs with commitments")
+	}
+	return groth16Vk, nil
+}
+
+// AggregateGroth16Proofs aggregates the Groth16 proofs of a verifying key
+// into one SnarkPack proof, which is verified against the public witnesses of
+// all the proofs
+func AggregateGroth16Proofs(srs *SnarkPackSRS, vk VerifyingKey, proofs []Proof, publicWitnesses []witness.Witness) (*SnarkPackProof, error) {
+	groth16Vk, err := snarkPackVerifyingKey(vk)
+	if err != nil {
+		return nil, err
+	}
+	if len(proofs) != len(publicWitnesses) {
+		return nil, fmt.Errorf("got %d proofs and %d public witnesses", len(proofs), len(publicWitnesses))
+	}
+	statements, err := snarkPackStatements(groth16Vk, publicWitnesses)
+	if err != nil {
+		return nil, err
+	}
+	n := len(statements)
+	if n > srs.Size {
+		return nil, fmt.Errorf("the SRS aggregates up to %d proofs, got %d", srs.Size, len(proofs))
+	}
+
+	a, b, c := make([]bls12381.G1Affine, n), make([]bls12381.G2Affine, n), make([]bls12381.G1Affine, n)
+	for i := range a {
+		index := min(i, len(proofs)-1)
+		proof, ok := proofs[index].(*groth16_bls12381.Proof)
+		if !ok {
+			return nil, fmt.Errorf("%w: proof %d is a %T", ErrUnsupportedBackend, index, proofs[index])
+		}
+		a[i], b[i], c[i] = proof.Ar, proof.Bs, proof.Krs
+	}
+
+	keys := snarkPackKeys{
+		v: [2][]bls12381.G2Affine{srs.g2Alpha[:n], srs.g2Beta[:n]},
+		w: [2][]bls12381.G1Affine{srs.g1Alpha[n : 2*n], srs.g1Beta[n : 2*n]},
+	}
+	aggregate := &SnarkPackProof{NbProofs: len(proofs)}
+	if aggregate.ComAB, err = commitPairs(keys, keys, a, b); err != nil {
+		return nil, err
+	}
+	if aggregate.ComC, err = commitG1(keys, c); err != nil {
+		return nil, err
+	}
+
+	transcript := newSnarkPackTranscript(srs.VerifyingKey(), groth16Vk)
+	transcript.append(aggregate.NbProofs, aggregate.ComAB, aggregate.ComC)
+	for i := range statements {
+		transcript.append(statements[i])
+	}
+	r, err := transcript.challenge()
+	if err != nil {
+		return nil, err
+	}
+
+	// Weigh B with the powers of r and its key with the inverse powers, which
+	// leaves the commitment unchanged
+	var rInverse fr_bls12381.Element
+	rInverse.Inverse(&r)
+	rPowers, rInversePowers := elementPowers(r, n), elementPowers(rInverse, n)
+	for i := range b {
+		b[i].ScalarMultiplication(&b[i], rPowers[i].BigInt(new(big.Int)))
+	}
+	for k := 0; k < 2; k++ {
+		keys.w[k] = append([]bls12381.G1Affine{}, keys.w[k]...)
+		for i := range keys.w[k] {
+			keys.w[k][i].ScalarMultiplication(&keys.w[k][i], rInversePowers[i].BigInt(new(big.Int)))
+		}
+	}
+	if aggregate.ZAB, err = bls12381.Pair(a, b); err != nil {
+		return nil, err
+	}
+	if _, err := aggregate.ZC.MultiExp(c, rPowers, ecc.MultiExpConfig{}); err != nil {
+		return nil, err
+	}
+	transcript.append(aggregate.ZAB, aggregate.ZC)
+
+	// Halve the vectors until a single element is left, the challenge x of
+	// every round folding A, C and w with x and B, r and v with 1/x
+	weights := rPowers
+	challenges := make([]fr_bls12381.Element, 0, bits.Len(uint(n))-1)
+	for len(a) > 1 {
+		half := len(a) / 2
+		aL, aR, bL, bR, cL, cR := a[:half], a[half:], b[:half], b[half:], c[:half], c[half:]
+		left, right := keys.split()
+
+		var round SnarkPackRound
+		if round.TabL, err = commitPairs(left, right, aR, bL); err != nil {
+			return nil, err
+		}
+		if round.TabR, err = commitPairs(right, left, aL, bR); err != nil {
+			return nil, err
+		}
+		if round.TcL, err = commitG1(left, cR); err != nil {
+			return nil, err
+		}
+		if round.TcR, err = commitG1(right, cL); err != nil {
+			return nil, err
+		}
+		if round.ZabL, err = bls12381.Pair(aR, bL); err != nil {
+			return nil, err
+		}
+		if round.ZabR, err = bls12381.Pair(aL, bR); err != nil {
+			return nil, err
+		}
+		if _, err := round.ZcL.MultiExp(cR, weights[:half], ecc.MultiExpConfig{}); err != nil {
+			return nil, err
+		}
+		if _, err := round.ZcR.MultiExp(cL, weights[half:], ecc.MultiExpConfig{}); err != nil {
+			return nil, err
+		}
+		aggregate.Rounds = append(aggregate.Rounds, round)
+
+		transcript.append(round.TabL, round.TabR, round.TcL, round.TcR, round.ZabL, round.ZabR, round.ZcL, round.ZcR)
+		x, err := transcript.challenge()
+		if err != nil {
+			return nil, err
+		}
+		var xInverse fr_bls12381.Element
+		xInverse.Inverse(&x)
+		challenges = append(challenges, x)
+
+		a, c = foldG1(aL, aR, x), foldG1(cL, cR, x)
+		b = foldG2(bL, bR, xInverse)
+		weights = foldElements(weights[:half], weights[half:], xInverse)
+		for k := 0; k < 2; k++ {
+			keys.v[k] = foldG2(left.v[k], right.v[k], xInverse)
+			keys.w[k] = foldG1(left.w[k], right.w[k], x)
+		}
+	}
+	aggregate.FinalA, aggregate.FinalB, aggregate.FinalC = a[0], b[0], c[0]
+	for k := 0; k < 2; k++ {
+		aggregate.FinalV[k], aggregate.FinalW[k] = keys.v[k][0], keys.w[k][0]
+	}
+
+	// Open the final keys, which are the commitments to polynomials the
+	// verifier evaluates from the challenges, at a random point
+	transcript.append(aggregate.FinalA, aggregate.FinalB, aggregate.FinalC, aggregate.FinalV[0], aggregate.FinalV[1], aggregate.FinalW[0], aggregate.FinalW[1])
+	z, err := transcript.challenge()
+	if err != nil {
+		return nil, err
+	}
+	vPolynomial, wPolynomial := snarkPackKeyPolynomials(challenges, rInverse)
+	vQuotient, wQuotient := divideByLinear(vPolynomial, z), divideByLinear(wPolynomial, z)
+	for k, powers := range [2][]bls12381.G2Affine{srs.g2Alpha, srs.g2Beta} {
+		if _, err := aggregate.OpenV[k].MultiExp(powers[:len(vQuotient)], vQuotient, ecc.MultiExpConfig{}); err != nil {
+			return nil, err
+		}
+	}
+	for k, powers := range [2][]bls12381.G1Affine{srs.g1Alpha, srs.g1Beta} {
+		if _, err := aggregate.OpenW[k].MultiExp(powers[:len(wQuotient)], wQuotient, ecc.MultiExpConfig{}); err != nil {
+			return nil, err
+		}
+	}
+	return aggregate, nil
+}
+
+// VerifyAggregateGroth16Proof verifies a SnarkPack proof aggregating Groth16
+// proofs of vk against their public witnesses
+func VerifyAggregateGroth16Proof(srsVk SnarkPackVerifyingKey, vk VerifyingKey, aggregate *SnarkPackProof, publicWitnesses []witness.Witness) error {
+	groth16Vk, err := snarkPackVerifyingKey(vk)
+	if err != nil {
+		return err
+	}
+	if aggregate.NbProofs != len(publicWitnesses) {
+		return fmt.Errorf("the proof aggregates %d proofs, got %d public witnesses", aggregate.NbProofs, len(publicWitnesses))
+	}
+	statements, err := snarkPackStatements(groth16Vk, publicWitnesses)
+	if err != nil {
+		return err
+	}
+	n := len(statements)
+	if len(aggregate.Rounds) != bits.Len(uint(n))-1 {
+		return fmt.Errorf("expected %d rounds, got %d", bits.Len(uint(n))-1, len(aggregate.Rounds))
+	}
+	if err := aggregate.checkSubgroups(); err != nil {
+		return err
+	}
+
+	transcript := newSnarkPackTranscript(srsVk, groth16Vk)
+	transcript.append(aggregate.NbProofs, aggregate.ComAB, aggregate.ComC)
+	for i := range statements {
+		transcript.append(statements[i])
+	}
+	r, err := transcript.challenge()
+	if err != nil {
+		return err
+	}
+	transcript.append(aggregate.ZAB, aggregate.ZC)
+
+	// The Groth16 equations of all the proofs, combined with the powers of r
+	if err := verifySnarkPackEquation(groth16Vk, aggregate, statements, r); err != nil {
+		return err
+	}
+
+	// Replay the rounds on the commitments and the inner products
+	comAB, comC, zAB, zC := aggregate.ComAB, aggregate.ComC, aggregate.ZAB, aggregate.ZC
+	challenges := make([]fr_bls12381.Element, len(aggregate.Rounds))
+	for i, round := range aggregate.Rounds {
+		transcript.append(round.TabL, round.TabR, round.TcL, round.TcR, round.ZabL, round.ZabR, round.ZcL, round.ZcR)
+		if challenges[i], err = transcript.challenge(); err != nil {
+			return err
+		}
+		var xInverse fr_bls12381.Element
+		xInverse.Inverse(&challenges[i])
+		x, xInv := challenges[i].BigInt(new(big.Int)), xInverse.BigInt(new(big.Int))
+
+		for k := 0; k < 2; k++ {
+			comAB[k] = foldGT(comAB[k], round.TabL[k], round.TabR[k], x, xInv)
+			comC[k] = foldGT(comC[k], round.TcL[k], round.TcR[k], x, xInv)
+		}
+		zAB = foldGT(zAB, round.ZabL, round.ZabR, x, xInv)
+		var left, right bls12381.G1Affine
+		left.ScalarMultiplication(&round.ZcL, x)
+		right.ScalarMultiplication(&round.ZcR, xInv)
+		zC.Add(&zC, &left)
+		zC.Add(&zC, &right)
+	}
+
+	// The folded commitments and inner products are those of the final
+	// elements under the final keys
+	for k := 0; k < 2; k++ {
+		expected, err := bls12381.Pair([]bls12381.G1Affine{aggregate.FinalA, aggregate.FinalW[k]}, []bls12381.G2Affine{aggregate.FinalV[k], aggregate.FinalB})
+		if err != nil {
+			return err
+		}
+		if !expected.Equal(&comAB[k]) {
+			return errors.New("the commitment to A and B does not open to the final elements")
+		}
+		if expected, err = bls12381.Pair([]bls12381.G1Affine{aggregate.FinalC}, []bls12381.G2Affine{aggregate.FinalV[k]}); err != nil {
+			return err
+		}
+		if !expected.Equal(&comC[k]) {
+			return errors.New("the commitment to C does not open to the final elements")
+		}
+	}
+	expected, err := bls12381.Pair([]bls12381.G1Affine{aggregate.FinalA}, []bls12381.G2Affine{aggregate.FinalB})
+	if err != nil {
+		return err
+	}
+	if !expected.Equal(&zAB) {
+		return errors.New("the pairing product of A and B does not fold to the final elements")
+	}
+	finalWeight := snarkPackWeight(challenges, r)
+	var expectedC bls12381.G1Affine
+	expectedC.ScalarMultiplication(&aggregate.FinalC, finalWeight.BigInt(new(big.Int)))
+	if !expectedC.Equal(&zC) {
+		return errors.New("the combination of C does not fold to the final elements")
+	}
+
+	// The final keys are the keys folded with the challenges
+	transcript.append(aggregate.FinalA, aggregate.FinalB, aggregate.FinalC, aggregate.FinalV[0], aggregate.FinalV[1], aggregate.FinalW[0], aggregate.FinalW[1])
+	z, err := transcript.challenge()
+	if err != nil {
+		return err
+	}
+	return verifySnarkPackKeys(srsVk, aggregate, challenges, r, z, n)
+}
+
+// verifySnarkPackEquation checks
+// Z_AB = e(α, β)^(Σ r^i) · e(Σ r^i·S_i, γ) · e(Z_C, δ)
+func verifySnarkPackEquation(vk *groth16_bls12381.VerifyingKey, aggregate *SnarkPackProof, statements []fr_bls12381.Vector, r fr_bls12381.Element) error {
+	rPowers := elementPowers(r, len(statements))
+	// Σ r^i·S_i = (Σ r^i)·K_0 + Σ_j (Σ_i r^i·x_ij)·K_(j+1)
+	scalars := make([]fr_bls12381.Element, len(vk.G1.K))
+	for i, statement := range statements {
+		scalars[0].Add(&scalars[0], &rPowers[i])
+		for j := range statement {
+			var term fr_bls12381.Element
+			term.Mul(&rPowers[i], &statement[j])
+			scalars[j+1].Add(&scalars[j+1], &term)
+		}
+	}
+	var publicTerm bls12381.G1Affine
+	if _, err := publicTerm.MultiExp(vk.G1.K, scalars, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+
+	alphaBeta, err := bls12381.Pair([]bls12381.G1Affine{vk.G1.Alpha}, []bls12381.G2Affine{vk.G2.Beta})
+	if err != nil {
+		return err
+	}
+	alphaBeta.Exp(alphaBeta, scalars[0].BigInt(new(big.Int)))
+	expected, err := bls12381.Pair([]bls12381.G1Affine{publicTerm, aggregate.ZC}, []bls12381.G2Affine{vk.G2.Gamma, vk.G2.Delta})
+	if err != nil {
+		return err
+	}
+	expected.Mul(&expected, &alphaBeta)
+	if !expected.Equal(&aggregate.ZAB) {
+		return errors.New("the aggregated Groth16 equation does not hold")
+	}
+	return nil
+}
+
+// verifySnarkPackKeys checks the KZG openings at z of the final keys, the
+// commitments under the powers of α and β to the polynomials of
+// snarkPackKeyPolynomials
+func verifySnarkPackKeys(srsVk SnarkPackVerifyingKey, aggregate *SnarkPackProof, challenges []fr_bls12381.Element, r, z fr_bls12381.Element, n int) error {
+	var rInverse fr_bls12381.Element
+	rInverse.Inverse(&r)
+	var zN fr_bls12381.Element
+	zN.Exp(z, big.NewInt(int64(n)))
+	// v(z) = Π (1 + z^(n/2^(j+1))/x_j) and w(z) = z^n·Π (1 + x_j·(z/r)^(n/2^(j+1)))
+	var vz, wz, zOverR fr_bls12381.Element
+	zOverR.Mul(&z, &rInverse)
+	vz.SetOne()
+	wz.Set(&zN)
+	for j := range challenges {
+		exponent := big.NewInt(int64(n >> (j + 1)))
+		var xInverse, vTerm, wTerm fr_bls12381.Element
+		xInverse.Inverse(&challenges[j])
+		vTerm.Exp(z, exponent)
+		vTerm.Mul(&vTerm, &xInverse)
+		vTerm.Add(&vTerm, new(fr_bls12381.Element).SetOne())
+		vz.Mul(&vz, &vTerm)
+		wTerm.Exp(zOverR, exponent)
+		wTerm.Mul(&wTerm, &challenges[j])
+		wTerm.Add(&wTerm, new(fr_bls12381.Element).SetOne())
+		wz.Mul(&wz, &wTerm)
+	}
+
+	var zG1, wzG1 bls12381.G1Affine
+	var zG2, vzH bls12381.G2Affine
+	zBig := z.BigInt(new(big.Int))
+	zG1.ScalarMultiplication(&srsVk.G1, zBig)
+	zG2.ScalarMultiplication(&srsVk.G2, zBig)
+	vzH.ScalarMultiplication(&srsVk.G2, vz.BigInt(new(big.Int)))
+	wzG1.ScalarMultiplication(&srsVk.G1, wz.BigInt(new(big.Int)))
+
+	secretsG1 := [2]bls12381.G1Affine{srsVk.G1Alpha, srsVk.G1Beta}
+	secretsG2 := [2]bls12381.G2Affine{srsVk.G2Alpha, srsVk.G2Beta}
+	for k := 0; k < 2; k++ {
+		// e(g^(s - z), π_v) = e(g, V - h^v(z))
+		var shifted, negG1 bls12381.G1Affine
+		var opened bls12381.G2Affine
+		shifted.Sub(&secretsG1[k], &zG1)
+		negG1.Neg(&srsVk.G1)
+		opened.Sub(&aggregate.FinalV[k], &vzH)
+		ok, err := bls12381.PairingCheck([]bls12381.G1Affine{shifted, negG1}, []bls12381.G2Affine{aggregate.OpenV[k], opened})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("the final key of A and C is not the folded key")
+		}
+
+		// e(π_w, h^(s - z)) = e(W - g^w(z), h)
+		var shiftedG2 bls12381.G2Affine
+		var openedG1 bls12381.G1Affine
+		shiftedG2.Sub(&secretsG2[k], &zG2)
+		openedG1.Sub(&aggregate.FinalW[k], &wzG1)
+		openedG1.Neg(&openedG1)
+		if ok, err = bls12381.PairingCheck([]bls12381.G1Affine{aggregate.OpenW[k], openedG1}, []bls12381.G2Affine{shiftedG2, srsVk.G2}); err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("the final key of B is not the folded key")
+		}
+	}
+	return nil
+}
+
+// checkSubgroups checks that the group elements of the proof are in the
+// subgroups the pairing is defined on
+func (aggregate *SnarkPackProof) checkSubgroups() error {
+	g1 := []bls12381.G1Affine{aggregate.ZC, aggregate.FinalA, aggregate.FinalC, aggregate.FinalW[0], aggregate.FinalW[1], aggregate.OpenW[0], aggregate.OpenW[1]}
+	g2 := []bls12381.G2Affine{aggregate.FinalB, aggregate.FinalV[0], aggregate.FinalV[1], aggregate.OpenV[0], aggregate.OpenV[1]}
+	gt := []bls12381.GT{aggregate.ComAB[0], aggregate.ComAB[1], aggregate.ComC[0], aggregate.ComC[1], aggregate.ZAB}
+	for _, round := range aggregate.Rounds {
+		g1 = append(g1, round.ZcL, round.ZcR)
+		gt = append(gt, round.TabL[0], round.TabL[1], round.TabR[0], round.TabR[1], round.TcL[0], round.TcL[1], round.TcR[0], round.TcR[1], round.ZabL, round.ZabR)
+	}
+	for i := range g1 {
+		if !g1[i].IsInSubGroup() {
+			return errors.New("a G1 element of the proof is not in the subgroup")
+		}
+	}
+	for i := range g2 {
+		if !g2[i].IsInSubGroup() {
+			return errors.New("a G2 element of the proof is not in the subgroup")
+		}
+	}
+	for i := range gt {
+		if !gt[i].IsInSubGroup() {
+			return errors.New("a GT element of the proof is not in the subgroup")
+		}
+	}
+	return nil
+}
+
+// snarkPackKeyPolynomials returns the coefficients of the polynomials whose
+// commitments under the powers of α (or β) are the final keys: v, the key of
+// A and C, and w, the key of B starting at the n-th power and weighted with
+// the inverse powers of r
+func snarkPackKeyPolynomials(challenges []fr_bls12381.Element, rInverse fr_bls12381.Element) (v, w []fr_bls12381.Element) {
+	inverses := make([]fr_bls12381.Element, len(challenges))
+	for j := range challenges {
+		inverses[j].Inverse(&challenges[j])
+	}
+	v, w = foldedCoefficients(inverses), foldedCoefficients(challenges)
+	rInversePowers := elementPowers(rInverse, len(w))
+	for i := range w {
+		w[i].Mul(&w[i], &rInversePowers[i])
+	}
+	return v, append(make([]fr_bls12381.Element, len(w)), w...)
+}
+
+// foldedCoefficients returns the weight of every element of a vector folded
+// with the given factors: that of element i is the product of the factors of
+// the rounds in which it was in the right half, the first round splitting on
+// the most significant bit of i
+func foldedCoefficients(factors []fr_bls12381.Element) []fr_bls12381.Element {
+	n := 1 << len(factors)
+	coefficients := make([]fr_bls12381.Element, n)
+	coefficients[0].SetOne()
+	for i := 1; i < n; i++ {
+		highest := bits.Len(uint(i)) - 1
+		coefficients[i].Mul(&coefficients[i^(1<<highest)], &factors[len(factors)-1-highest])
+	}
+	return coefficients
+}
+
+// snarkPackWeight returns the final element of the powers of r folded with
+// the inverses of the challenges, Π (1 + r^(n/2^(j+1))/x_j)
+func snarkPackWeight(challenges []fr_bls12381.Element, r fr_bls12381.Element) fr_bls12381.Element {
+	n := 1 << len(challenges)
+	var weight fr_bls12381.Element
+	weight.SetOne()
+	for j := range challenges {
+		var term fr_bls12381.Element
+		term.Exp(r, big.NewInt(int64(n>>(j+1))))
+		term.Div(&term, &challenges[j])
+		term.Add(&term, new(fr_bls12381.Element).SetOne())
+		weight.Mul(&weight, &term)
+	}
+	return weight
+}
+
+// divideByLinear returns the quotient of a polynomial by X - z
+func divideByLinear(coefficients []fr_bls12381.Element, z fr_bls12381.Element) []fr_bls12381.Element {
+	quotient := make([]fr_bls12381.Element, len(coefficients)-1)
+	quotient[len(quotient)-1] = coefficients[len(coefficients)-1]
+	for i := len(quotient) - 1; i > 0; i-- {
+		quotient[i-1].Mul(&quotient[i], &z)
+		quotient[i-1].Add(&quotient[i-1], &coefficients[i])
+	}
+	return quotient
+}
+
+// elementPowers returns 1, x, ..., x^(n-1)
+func elementPowers(x fr_bls12381.Element, n int) []fr_bls12381.Element {
+	powers := make([]fr_bls12381.Element, n)
+	powers[0].SetOne()
+	for i := 1; i < n; i++ {
+		powers[i].Mul(&powers[i-1], &x)
+	}
+	return powers
+}
+
+func foldG1(left, right []bls12381.G1Affine, x fr_bls12381.Element) []bls12381.G1Affine {
+	scalar := x.BigInt(new(big.Int))
+	folded := make([]bls12381.G1Affine, len(left))
+	for i := range folded {
+		folded[i].ScalarMultiplication(&right[i], scalar)
+		folded[i].Add(&folded[i], &left[i])
+	}
+	return folded
+}
+
+func foldG2(left, right []bls12381.G2Affine, x fr_bls12381.Element) []bls12381.G2Affine {
+	scalar := x.BigInt(new(big.Int))
+	folded := make([]bls12381.G2Affine, len(left))
+	for i := range folded {
+		folded[i].ScalarMultiplication(&right[i], scalar)
+		folded[i].Add(&folded[i], &left[i])
+	}
+	return folded
+}
+
+func foldElements(left, right []fr_bls12381.Element, x fr_bls12381.Element) []fr_bls12381.Element {
+	folded := make([]fr_bls12381.Element, len(left))
+	for i := range folded {
+		folded[i].Mul(&right[i], &x)
+		folded[i].Add(&folded[i], &left[i])
+	}
+	return folded
+}
+
+// foldGT returns value·left^x·right^(1/x)
+func foldGT(value, left, right bls12381.GT, x, xInverse *big.Int) bls12381.GT {
+	left.Exp(left, x)
+	right.Exp(right, xInverse)
+	value.Mul(&value, &left)
+	value.Mul(&value, &right)
+	return value
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	fr_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	groth16_bls12381 "github.com/consensys/gnark/backend/groth16/bls12-381"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// createSnarkPackSRS draws the secrets of a SnarkPack SRS for tests
+func createSnarkPackSRS(nbProofs int) (*SnarkPackSRS, error) {
+	alpha, err := rand.Int(rand.Reader, SNARKPACK_CURVE.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	beta, err := rand.Int(rand.Reader, SNARKPACK_CURVE.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	return NewSnarkPackSRS(nbProofs, alpha, beta)
+}
+
+func TestSnarkPackRejectsInvalidAggregates(t *testing.T) {
+
+	provingBackend := Groth16Backend.OnCurve(SNARKPACK_CURVE)
+	cs, err := provingBackend.Compile(NewIndividualBalanceCircuit(MiMCScheme))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+	_, fullWitnesses, publicWitnesses, err := createIndividualBalanceWitnesses(MiMCScheme)
+	if err != nil {
+		t.Fatalf("Failed to create witnesses: %v", err)
+	}
+
+	// Five proofs are padded to eight
+	const nbProofs = 5
+	proofs := make([]Proof, nbProofs)
+	for i := range proofs {
+		if proofs[i], err = Prove(cs, pk, (*fullWitnesses)[i]); err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+	}
+	publics := (*publicWitnesses)[:nbProofs]
+	srs, err := createSnarkPackSRS(nbProofs)
+	if err != nil {
+		t.Fatalf("Failed to create SRS: %v", err)
+	}
+	aggregate, err := AggregateGroth16Proofs(srs, vk, proofs, publics)
+	if err != nil {
+		t.Fatalf("Failed to aggregate proofs: %v", err)
+	}
+	if err := VerifyAggregateGroth16Proof(srs.VerifyingKey(), vk, aggregate, publics); err != nil {
+		t.Fatalf("Failed to verify aggregate proof: %v", err)
+	}
+
+	swapped := append([]witness.Witness{}, publics...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	otherStatement := append([]witness.Witness{}, publics...)
+	otherStatement[nbProofs-1] = (*publicWitnesses)[nbProofs]
+
+	testCases := []struct {
+		name    string
+		publics []witness.Witness
+	}{
+		{"SwappedPublicWitnesses", swapped},
+		{"AnotherStatement", otherStatement},
+		{"MissingProof", publics[:nbProofs-1]},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := VerifyAggregateGroth16Proof(srs.VerifyingKey(), vk, aggregate, tc.publics); err == nil {
+				t.Fatal("Expected the aggregate proof not to verify")
+			}
+		})
+	}
+
+	t.Run("ProofOfAnotherStatement", func(t *testing.T) {
+		forged := append([]Proof{}, proofs...)
+		if forged[0], err = Prove(cs, pk, (*fullWitnesses)[nbProofs]); err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		aggregate, err := AggregateGroth16Proofs(srs, vk, forged, publics)
+		if err != nil {
+			t.Fatalf("Failed to aggregate proofs: %v", err)
+		}
+		if err := VerifyAggregateGroth16Proof(srs.VerifyingKey(), vk, aggregate, publics); err == nil {
+			t.Fatal("Expected the aggregate proof not to verify")
+		}
+	})
+
+	t.Run("TamperedRound", func(t *testing.T) {
+		tampered := *aggregate
+		tampered.Rounds = append([]SnarkPackRound{}, aggregate.Rounds...)
+		tampered.Rounds[1].ZcL, tampered.Rounds[1].ZcR = tampered.Rounds[1].ZcR, tampered.Rounds[1].ZcL
+		if err := VerifyAggregateGroth16Proof(srs.VerifyingKey(), vk, &tampered, publics); err == nil {
+			t.Fatal("Expected the aggregate proof not to verify")
+		}
+	})
+
+	t.Run("AnotherSRS", func(t *testing.T) {
+		other, err := createSnarkPackSRS(nbProofs)
+		if err != nil {
+			t.Fatalf("Failed to create SRS: %v", err)
+		}
+		if err := VerifyAggregateGroth16Proof(other.VerifyingKey(), vk, aggregate, publics); err == nil {
+			t.Fatal("Expected the aggregate proof not to verify")
+		}
+	})
+
+	t.Run("ChallengesBoundToTheKeys", func(t *testing.T) {
+		otherSRS, err := createSnarkPackSRS(nbProofs)
+		if err != nil {
+			t.Fatalf("Failed to create SRS: %v", err)
+		}
+		_, otherVk, err := provingBackend.Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		challenge := func(srsVk SnarkPackVerifyingKey, vk VerifyingKey) fr_bls12381.Element {
+			transcript := newSnarkPackTranscript(srsVk, vk.(*groth16_bls12381.VerifyingKey))
+			transcript.append(aggregate.NbProofs, aggregate.ComAB, aggregate.ComC)
+			r, err := transcript.challenge()
+			if err != nil {
+				t.Fatalf("Failed to derive challenge: %v", err)
+			}
+			return r
+		}
+		r := challenge(srs.VerifyingKey(), vk)
+		if other := challenge(otherSRS.VerifyingKey(), vk); other.Equal(&r) {
+			t.Fatal("Expected another SnarkPack key to change the challenge")
+		}
+		if other := challenge(srs.VerifyingKey(), otherVk); other.Equal(&r) {
+			t.Fatal("Expected another Groth16 key to change the challenge")
+		}
+	})
+
+	t.Run("TooManyProofs", func(t *testing.T) {
+		small, err := createSnarkPackSRS(2)
+		if err != nil {
+			t.Fatalf("Failed to create SRS: %v", err)
+		}
+		if _, err := AggregateGroth16Proofs(small, vk, proofs, publics); err == nil {
+			t.Fatal("Expected the SRS to be too small")
+		}
+	})
+
+	t.Run("OtherCurves", func(t *testing.T) {
+		otherBackend := Groth16Backend.OnCurve(ecc.BN254)
+		cs, err := otherBackend.Compile(NewIndividualBalanceCircuit(MiMCScheme))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		_, otherVk, err := otherBackend.Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		if _, err := AggregateGroth16Proofs(srs, otherVk, proofs, publics); !errors.Is(err, ErrUnsupportedBackend) {
+			t.Fatalf("Expected ErrUnsupportedBackend, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/solidity"
+	"github.com/consensys/gnark/backend/witness"
+	"golang.org/x/crypto/sha3"
+)
+
+// Proofs on BN254, the only curve with pairing precompiles on Ethereum
+// (EIP-196 and EIP-197), are verified on chain by the contract exported from
+// their verifying key. The Groth16 contract derives the challenge of the
+// commitment of the range checks with Keccak-256 rather than gnark's default
+// hash to field, so such proofs are generated with SolidityProverOption.
+
+// ExportSolidityVerifier writes the Solidity contract verifying the proofs of
+// a BN254 verifying key of either backend
+func ExportSolidityVerifier(w io.Writer, vk VerifyingKey, opts ...solidity.ExportOption) error {
+	if curve, err := curveOf(vk); err != nil || curve != ecc.BN254 {
+		return fmt.Errorf("%w: Solidity verifiers need a BN254 verifying key", ErrUnsupportedCurve)
+	}
+	exportable, ok := vk.(solidity.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("%w: verifying key %T", ErrUnsupportedBackend, vk)
+	}
+	return exportable.ExportSolidity(w, opts...)
+}
+
+// SolidityProverOption is the option of Prove for proofs checked by the
+// exported contract
+func (b ProvingBackend) SolidityProverOption() backend.ProverOption {
+	return solidity.WithProverTargetSolidityVerifier(b.ID)
+}
+
+// SolidityVerifierOption is the option of Verify for proofs generated with
+// SolidityProverOption
+func (b ProvingBackend) SolidityVerifierOption() backend.VerifierOption {
+	return solidity.WithVerifierTargetSolidityVerifier(b.ID)
+}
+
+// SolidityCalldata encodes the call to the exported contract verifying a
+// proof against a public witness: verifyProof of the Groth16 contract, which
+// reverts unless the proof holds, or Verify of the PLONK one, which returns
+// whether it holds
+func SolidityCalldata(proof Proof, publicWitness witness.Witness) ([]byte, error) {
+	for _, value := range []any{proof, publicWitness} {
+		if curve, err := curveOf(value); err != nil || curve != ecc.BN254 {
+			return nil, fmt.Errorf("%w: %T is not on BN254", ErrUnsupportedCurve, value)
+		}
+	}
+	publicInputs := publicWitness.Vector().(fr_bn254.Vector)
+
+	switch proof := proof.(type) {
+	case *groth16_bn254.Proof:
+		return groth16Calldata(proof, publicInputs), nil
+	case *plonk_bn254.Proof:
+		return plonkCalldata(proof, publicInputs), nil
+	default:
+		return nil, fmt.Errorf("%w: proof %T", ErrUnsupportedBackend, proof)
+	}
+}
+
+// groth16Calldata encodes verifyProof(proof, [commitments, commitmentPok,]
+// input), whose arguments are all fixed-size arrays of uint256
+func groth16Calldata(proof *groth16_bn254.Proof, publicInputs fr_bn254.Vector) []byte {
+	signature := "verifyProof(uint256[8],"
+	if len(proof.Commitments) > 0 {
+		signature += fmt.Sprintf("uint256[%d],uint256[2],", 2*len(proof.Commitments))
+	}
+	signature += fmt.Sprintf("uint256[%d])", len(publicInputs))
+
+	// Ar, Bs and Krs, the coordinates of Bs in the order of EIP-197
+	calldata := append(abiSelector(signature), proof.MarshalSolidity()[:8*fr_bn254.Bytes]...)
+	if len(proof.Commitments) > 0 {
+		for i := range proof.Commitments {
+			commitment := proof.Commitments[i].RawBytes()
+			calldata = append(calldata, commitment[:]...)
+		}
+		pok := proof.CommitmentPok.RawBytes()
+		calldata = append(calldata, pok[:]...)
+	}
+	for i := range publicInputs {
+		input := publicInputs[i].Bytes()
+		calldata = append(calldata, input[:]...)
+	}
+	return calldata
+}
+
+// plonkCalldata encodes Verify(proof, public_inputs), a bytes argument and a
+// dynamic array of uint256 whose contents follow the offsets of both
+func plonkCalldata(proof *plonk_bn254.Proof, publicInputs fr_bn254.Vector) []byte {
+	encoded := proof.MarshalSolidity()
+	padding := (32 - len(encoded)%32) % 32
+
+	calldata := abiSelector("Verify(bytes,uint256[])")
+	calldata = append(calldata, abiWord(2*32)...)
+	calldata = append(calldata, abiWord(3*32+len(encoded)+padding)...)
+	calldata = append(calldata, abiWord(len(encoded))...)
+	calldata = append(calldata, encoded...)
+	calldata = append(calldata, make([]byte, padding)...)
+	calldata = append(calldata, abiWord(len(publicInputs))...)
+	for i := range publicInputs {
+		input := publicInputs[i].Bytes()
+		calldata = append(calldata, input[:]...)
+	}
+	return calldata
+}
+
+// abiSelector returns the first 4 bytes of the Keccak-256 digest of a
+// function signature, which select the function called
+func abiSelector(signature string) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(signature))
+	return hash.Sum(nil)[:4]
+}
+
+// abiWord encodes a length or an offset as a 32-byte word
+func abiWord(value int) []byte {
+	word := make([]byte, 32)
+	binary.BigEndian.PutUint64(word[24:], uint64(value))
+	return word
+}
@@ -0,0 +1,123 @@
+//go:build solc
+
+// The exported verifiers are run on the EVM only in builds tagged solc, which
+// need solc in PATH: CI runs go test -tags solc ./... so that the test cannot
+// pass without being run.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+// compileSolidity compiles a contract with the solc found in PATH and returns
+// its ABI and creation bytecode, failing the test without solc
+func compileSolidity(t *testing.T, contract []byte) (abi.ABI, []byte) {
+	t.Helper()
+	solc, err := exec.LookPath("solc")
+	if err != nil {
+		t.Fatalf("solc is required with -tags solc: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "Verifier.sol")
+	if err := os.WriteFile(path, contract, 0o644); err != nil {
+		t.Fatalf("Failed to write contract: %v", err)
+	}
+	output, err := exec.Command(solc, "--optimize", "--combined-json", "abi,bin", path).Output()
+	if err != nil {
+		t.Fatalf("Failed to compile contract: %v", err)
+	}
+
+	var compiled struct {
+		Contracts map[string]struct {
+			ABI json.RawMessage `json:"abi"`
+			Bin string          `json:"bin"`
+		} `json:"contracts"`
+	}
+	if err := json.Unmarshal(output, &compiled); err != nil {
+		t.Fatalf("Failed to decode solc output: %v", err)
+	}
+	// Skip the libraries the file may declare besides the verifier
+	for name, contract := range compiled.Contracts {
+		if !strings.HasSuffix(name, ":Verifier") && !strings.HasSuffix(name, ":PlonkVerifier") {
+			continue
+		}
+		parsed, err := abi.JSON(bytes.NewReader(contract.ABI))
+		if err != nil {
+			t.Fatalf("Failed to parse ABI: %v", err)
+		}
+		return parsed, common.FromHex(contract.Bin)
+	}
+	t.Fatal("solc output has no verifier contract")
+	return abi.ABI{}, nil
+}
+
+func TestSolidityVerifierOnTheEVM(t *testing.T) {
+
+	for _, provingBackend := range solidityTestBackends() {
+		t.Run(provingBackend.String(), func(t *testing.T) {
+			contract, calldata := createSolidityProof(t, provingBackend)
+			contractABI, bytecode := compileSolidity(t, contract)
+
+			key, err := crypto.GenerateKey()
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			deployer := crypto.PubkeyToAddress(key.PublicKey)
+			backend := simulated.NewBackend(types.GenesisAlloc{deployer: {Balance: new(big.Int).Lsh(big.NewInt(1), 100)}})
+			defer backend.Close()
+			client := backend.Client()
+
+			chainID, err := client.ChainID(context.Background())
+			if err != nil {
+				t.Fatalf("Failed to get chain ID: %v", err)
+			}
+			auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+			if err != nil {
+				t.Fatalf("Failed to create transactor: %v", err)
+			}
+			address, _, _, err := bind.DeployContract(auth, contractABI, bytecode, client)
+			if err != nil {
+				t.Fatalf("Failed to deploy verifier: %v", err)
+			}
+			backend.Commit()
+
+			// The Groth16 verifier reverts on an invalid proof and the
+			// PLONK one returns false
+			call := func(calldata []byte) error {
+				output, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &address, Data: calldata}, nil)
+				if err != nil {
+					return err
+				}
+				if len(output) == 32 && output[31] != 1 {
+					return errors.New("the verifier returned false")
+				}
+				return nil
+			}
+			if err := call(calldata); err != nil {
+				t.Fatalf("The contract rejected the proof: %v", err)
+			}
+			// Change the snapshot digest, the last public input
+			calldata[len(calldata)-1] ^= 1
+			if err := call(calldata); err == nil {
+				t.Fatal("Expected the contract to reject another snapshot digest")
+			}
+		})
+	}
+}
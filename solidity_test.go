@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/kzg"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/test/unsafekzg"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// uint256Array converts field elements to a Go array of *big.Int, which the
+// ABI encoder expects for uint256[n]
+func uint256Array(elements ...fr_bn254.Element) any {
+	array := reflect.New(reflect.ArrayOf(len(elements), reflect.TypeOf(&big.Int{}))).Elem()
+	for i := range elements {
+		array.Index(i).Set(reflect.ValueOf(elements[i].BigInt(new(big.Int))))
+	}
+	return array.Interface()
+}
+
+// packCalldata encodes the call to the exported contract with the ABI encoder
+// of go-ethereum, against which SolidityCalldata is checked
+func packCalldata(t *testing.T, proof Proof, publicWitness witness.Witness) []byte {
+	t.Helper()
+	publicInputs := publicWitness.Vector().(fr_bn254.Vector)
+	uint256 := func(n int) abi.Argument {
+		typeName := "uint256[]"
+		if n > 0 {
+			typeName = fmt.Sprintf("uint256[%d]", n)
+		}
+		argumentType, err := abi.NewType(typeName, "", nil)
+		if err != nil {
+			t.Fatalf("Failed to create ABI type: %v", err)
+		}
+		return abi.Argument{Type: argumentType}
+	}
+
+	var name string
+	var arguments abi.Arguments
+	var values []any
+	switch proof := proof.(type) {
+	case *groth16_bn254.Proof:
+		var elements [8]fr_bn254.Element
+		raw := proof.MarshalSolidity()
+		for i := range elements {
+			elements[i].SetBytes(raw[i*fr_bn254.Bytes : (i+1)*fr_bn254.Bytes])
+		}
+		name = "verifyProof"
+		arguments = abi.Arguments{uint256(8)}
+		values = []any{uint256Array(elements[:]...)}
+		if len(proof.Commitments) > 0 {
+			commitments := make([]fr_bn254.Element, 0, 2*len(proof.Commitments))
+			for i := range proof.Commitments {
+				var x, y fr_bn254.Element
+				raw := proof.Commitments[i].RawBytes()
+				x.SetBytes(raw[:fr_bn254.Bytes])
+				y.SetBytes(raw[fr_bn254.Bytes:])
+				commitments = append(commitments, x, y)
+			}
+			var x, y fr_bn254.Element
+			raw := proof.CommitmentPok.RawBytes()
+			x.SetBytes(raw[:fr_bn254.Bytes])
+			y.SetBytes(raw[fr_bn254.Bytes:])
+			arguments = append(arguments, uint256(len(commitments)), uint256(2))
+			values = append(values, uint256Array(commitments...), uint256Array(x, y))
+		}
+		arguments = append(arguments, uint256(len(publicInputs)))
+		values = append(values, uint256Array(publicInputs...))
+	case *plonk_bn254.Proof:
+		bytesType, err := abi.NewType("bytes", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to create ABI type: %v", err)
+		}
+		inputs := make([]*big.Int, len(publicInputs))
+		for i := range publicInputs {
+			inputs[i] = publicInputs[i].BigInt(new(big.Int))
+		}
+		name = "Verify"
+		arguments = abi.Arguments{{Type: bytesType}, uint256(0)}
+		values = []any{proof.MarshalSolidity(), inputs}
+	default:
+		t.Fatalf("Unexpected proof %T", proof)
+	}
+
+	method := abi.NewMethod(name, name, abi.Function, "view", false, false, arguments, nil)
+	packed, err := arguments.Pack(values...)
+	if err != nil {
+		t.Fatalf("Failed to pack calldata: %v", err)
+	}
+	return append(method.ID, packed...)
+}
+
+// solidityTestBackends returns the backends whose verifiers are exported to
+// Solidity
+func solidityTestBackends() []ProvingBackend {
+	unsafeSRS := func(cs constraint.ConstraintSystem) (kzg.SRS, kzg.SRS, error) {
+		return unsafekzg.NewSRS(cs)
+	}
+	return []ProvingBackend{Groth16Backend.OnCurve(ecc.BN254), PlonkBackend(unsafeSRS).OnCurve(ecc.BN254)}
+}
+
+// createSolidityProof proves the total of a few accounts on BN254 and returns
+// the exported Solidity verifier and the calldata of the proof
+func createSolidityProof(t *testing.T, provingBackend ProvingBackend) ([]byte, []byte) {
+	t.Helper()
+	accounts := createAccountBalances(4)
+	cs, err := provingBackend.Compile(NewSumAggregationCircuit(len(accounts)))
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+	assignment, err := NewSumAggregationAssignmentOnCurve(ecc.BN254, accounts, len(accounts), testEpoch, testSnapshotDigest)
+	if err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+	fw, err := provingBackend.NewWitness(assignment)
+	if err != nil {
+		t.Fatalf("Failed to create witness: %v", err)
+	}
+	proof, err := Prove(cs, pk, fw, provingBackend.SolidityProverOption())
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	pw, err := fw.Public()
+	if err != nil {
+		t.Fatalf("Failed to create public witness: %v", err)
+	}
+	if err := Verify(proof, vk, pw, provingBackend.SolidityVerifierOption()); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+
+	var contract bytes.Buffer
+	if err := ExportSolidityVerifier(&contract, vk); err != nil {
+		t.Fatalf("Failed to export Solidity verifier: %v", err)
+	}
+	calldata, err := SolidityCalldata(proof, pw)
+	if err != nil {
+		t.Fatalf("Failed to encode calldata: %v", err)
+	}
+	if !bytes.Equal(calldata, packCalldata(t, proof, pw)) {
+		t.Fatal("The calldata differs from its ABI encoding")
+	}
+	return contract.Bytes(), calldata
+}
+
+// TestSolidityVerifierOfTheAggregateProof checks the exported verifiers and
+// calldata natively. TestSolidityVerifierOnTheEVM runs them on the EVM with
+// -tags solc.
+func TestSolidityVerifierOfTheAggregateProof(t *testing.T) {
+
+	for _, provingBackend := range solidityTestBackends() {
+		t.Run(provingBackend.String(), func(t *testing.T) {
+			createSolidityProof(t, provingBackend)
+		})
+	}
+
+	t.Run("OtherCurves", func(t *testing.T) {
+		cs, err := Groth16Backend.Compile(NewSumAggregationCircuit(4))
+		if err != nil {
+			t.Fatalf("Failed to compile circuit: %v", err)
+		}
+		_, vk, err := Groth16Backend.Setup(cs)
+		if err != nil {
+			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+		}
+		if err := ExportSolidityVerifier(&bytes.Buffer{}, vk); !errors.Is(err, ErrUnsupportedCurve) {
+			t.Fatalf("Expected ErrUnsupportedCurve exporting a %v verifier, got %v", Groth16Backend.CurveID(), err)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// ErrInsolvent is returned when asked to prove solvency while the liabilities
+// exceed the reserves
+var ErrInsolvent = errors.New("liabilities exceed reserves")
+
+// SolvencyCircuit proves that the liabilities do not exceed the public
+// reserves. The liabilities are the root sum of the published Merkle sum tree:
+// the leaves are recomputed up to the public root hash, so no account of the
+// tree can be left out or lowered. The root sum itself stays private.
+type SolvencyCircuit struct {
+	AccountHashes []frontend.Variable `gnark:"account_hashes,secret"`
+	Salts         []frontend.Variable `gnark:"salts,secret"`
+	Balances      []frontend.Variable `gnark:"balances,secret"`  // User balances (private inputs)
+	RootHash      frontend.Variable   `gnark:"root_hash,public"` // Root hash of the published Merkle sum tree
+	Reserves      frontend.Variable   `gnark:"reserves,public"`  // Reserves held by the exchange
+	BalanceBits   int                 `gnark:"-"`                // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+// NewSolvencyCircuit returns the circuit proving solvency over a Merkle sum
+// tree of nbLeaves leaves, a power of two
+func NewSolvencyCircuit(nbLeaves int) *SolvencyCircuit {
+	return &SolvencyCircuit{
+		AccountHashes: make([]frontend.Variable, nbLeaves),
+		Salts:         make([]frontend.Variable, nbLeaves),
+		Balances:      make([]frontend.Variable, nbLeaves),
+	}
+}
+
+func (circuit *SolvencyCircuit) Define(api frontend.API) error {
+	_, err := circuit.assertSolvent(api)
+	return err
+}
+
+// assertSolvent recomputes the Merkle sum tree, asserts its root is the public
+// one and its sum is at most the reserves, and returns the sum
+func (circuit *SolvencyCircuit) assertSolvent(api frontend.API) (frontend.Variable, error) {
+	rootHash, liabilities, err := merkleSumRoot(api, circuit.AccountHashes, circuit.Salts, circuit.Balances, balanceBitsOrDefault(circuit.BalanceBits))
+	if err != nil {
+		return nil, err
+	}
+	api.AssertIsEqual(rootHash, circuit.RootHash)
+
+	// The comparison decomposes both sides over the whole field, so reserves
+	// of any size can be published
+	api.AssertIsLessOrEqual(liabilities, circuit.Reserves)
+	return liabilities, nil
+}
+
+// CommittedSolvencyCircuit proves solvency like SolvencyCircuit and publishes
+// the hiding commitment MiMC(liabilities, blinding) to the liability total, so
+// that the same total can later be opened to an auditor or reused in another
+// proof.
+type CommittedSolvencyCircuit struct {
+	SolvencyCircuit
+	Blinding              frontend.Variable `gnark:"blinding,secret"`
+	LiabilitiesCommitment frontend.Variable `gnark:"liabilities_commitment,public"`
+}
+
+func (circuit *CommittedSolvencyCircuit) Define(api frontend.API) error {
+	liabilities, err := circuit.assertSolvent(api)
+	if err != nil {
+		return err
+	}
+
+	hash, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	hash.Write(liabilities, circuit.Blinding)
+	api.AssertIsEqual(hash.Sum(), circuit.LiabilitiesCommitment)
+	return nil
+}
+
+// SolvencyProof is a proof that the liabilities do not exceed some reserves
+type SolvencyProof struct {
+	Proof                 Proof
+	LiabilitiesCommitment *big.Int // MiMC(liabilities, blinding), nil unless the prover is committed
+	Blinding              *big.Int `json:"-"` // Opening of the commitment, kept by the prover and never published
+}
+
+// SolvencyProver proves solvency over Merkle sum trees of a fixed number of
+// leaves
+type SolvencyProver struct {
+	NbLeaves       int  // Number of leaves of the trees, a power of two
+	Committed      bool // Whether proofs publish a commitment to the liabilities
+	provingBackend ProvingBackend
+	cs             constraint.ConstraintSystem
+	pk             ProvingKey
+	vk             VerifyingKey
+}
+
+// NewSolvencyProver compiles the solvency circuit for the Merkle sum trees of
+// nbAccounts accounts, padded to a power of two like NewMerkleSumTree, and
+// runs its setup. With committed set, proofs also publish a commitment to the
+// liability total.
+func NewSolvencyProver(provingBackend ProvingBackend, nbAccounts int, committed bool) (*SolvencyProver, error) {
+	if nbAccounts <= 0 {
+		return nil, errors.New("a solvency proof needs at least one account")
+	}
+
+	nbLeaves := 1 << bits.Len(uint(nbAccounts-1))
+	var circuit frontend.Circuit = NewSolvencyCircuit(nbLeaves)
+	if committed {
+		circuit = &CommittedSolvencyCircuit{SolvencyCircuit: *NewSolvencyCircuit(nbLeaves)}
+	}
+	cs, err := provingBackend.Compile(circuit)
+	if err != nil {
+		return nil, err
+	}
+	pk, vk, err := provingBackend.Setup(cs)
+	if err != nil {
+		return nil, err
+	}
+	return &SolvencyProver{NbLeaves: nbLeaves, Committed: committed, provingBackend: provingBackend, cs: cs, pk: pk, vk: vk}, nil
+}
+
+// VerifyingKey returns the key with which the proofs are verified
+func (prover *SolvencyProver) VerifyingKey() VerifyingKey {
+	return prover.vk
+}
+
+// Prove proves that the balances of the tree, whose root hash is published,
+// add up to at most reserves. It returns ErrInsolvent rather than a proof that
+// would not verify when the liabilities exceed the reserves.
+func (prover *SolvencyProver) Prove(tree *MerkleSumTree, reserves *big.Int) (*SolvencyProof, error) {
+	if len(tree.Leaves) != prover.NbLeaves {
+		return nil, fmt.Errorf("got a tree of %d leaves for a circuit of %d leaves", len(tree.Leaves), prover.NbLeaves)
+	}
+	curve := prover.provingBackend.CurveID()
+	if tree.Curve != curve {
+		return nil, fmt.Errorf("the tree is hashed on %v, the circuit is compiled on %v", tree.Curve, curve)
+	}
+	liabilities := tree.Root().Sum
+	if reserves.Sign() < 0 || liabilities.Cmp(reserves) > 0 {
+		return nil, ErrInsolvent
+	}
+
+	leaves := tree.Assignment()
+	solvency := SolvencyCircuit{
+		AccountHashes: leaves.AccountHashes,
+		Salts:         leaves.Salts,
+		Balances:      leaves.Balances,
+		RootHash:      leaves.RootHash,
+		Reserves:      reserves,
+	}
+	proof := &SolvencyProof{}
+	var assignment frontend.Circuit = &solvency
+	if prover.Committed {
+		blinding, err := RandomBlindingOnCurve(curve)
+		if err != nil {
+			return nil, err
+		}
+		commitment, err := mimcHashOnCurve(curve, liabilities, blinding)
+		if err != nil {
+			return nil, err
+		}
+		proof.LiabilitiesCommitment, proof.Blinding = commitment, blinding
+		assignment = &CommittedSolvencyCircuit{SolvencyCircuit: solvency, Blinding: blinding, LiabilitiesCommitment: commitment}
+	}
+
+	fw, err := prover.provingBackend.NewWitness(assignment)
+	if err != nil {
+		return nil, err
+	}
+	proof.Proof, err = Prove(prover.cs, prover.pk, fw)
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifySolvency checks a solvency proof against the root hash of the
+// published Merkle sum tree and the public reserves
+func VerifySolvency(vk VerifyingKey, proof *SolvencyProof, rootHash, reserves *big.Int) error {
+	var assignment frontend.Circuit = &SolvencyCircuit{RootHash: rootHash, Reserves: reserves}
+	if proof.LiabilitiesCommitment != nil {
+		assignment = &CommittedSolvencyCircuit{
+			SolvencyCircuit:       SolvencyCircuit{RootHash: rootHash, Reserves: reserves},
+			LiabilitiesCommitment: proof.LiabilitiesCommitment,
+		}
+	}
+	curve, err := curveOf(vk)
+	if err != nil {
+		return err
+	}
+	pw, err := frontend.NewWitness(assignment, curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	return Verify(proof.Proof, vk, pw)
+}
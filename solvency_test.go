@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+)
+
+func TestSolvencyProofAndVerification(t *testing.T) {
+
+	nbAccounts := testNbCommittedAccounts()
+	for _, committed := range []bool{false, true} {
+		name := "PrivateLiabilities"
+		if committed {
+			name = "CommittedLiabilities"
+		}
+		t.Run(name, func(t *testing.T) {
+
+			prover, err := NewSolvencyProver(testProvingBackend(t), nbAccounts, committed)
+			if err != nil {
+				t.Fatalf("Failed to set up the solvency prover: %v", err)
+			}
+
+			// Leave some accounts out so that the tree is padded with empty leaves
+			tree, err := createMerkleSumTree(nbAccounts - 2)
+			if err != nil {
+				t.Fatalf("Failed to build tree: %v", err)
+			}
+			otherTree, err := createMerkleSumTree(nbAccounts - 2)
+			if err != nil {
+				t.Fatalf("Failed to build tree: %v", err)
+			}
+			rootHash, liabilities := tree.Root().Hash, tree.Root().Sum
+
+			for _, reserves := range []*big.Int{liabilities, new(big.Int).Lsh(liabilities, 1)} {
+				proof, err := prover.Prove(tree, reserves)
+				if err != nil {
+					t.Fatalf("Failed to generate proof: %v", err)
+				}
+				if committed != (proof.LiabilitiesCommitment != nil) {
+					t.Fatal("Expected a liability commitment exactly when the prover is committed")
+				}
+				if committed {
+					commitment, err := mimcHash(liabilities, proof.Blinding)
+					if err != nil {
+						t.Fatalf("Failed to compute commitment: %v", err)
+					}
+					if commitment.Cmp(proof.LiabilitiesCommitment) != 0 {
+						t.Fatal("The commitment does not open to the liabilities")
+					}
+				}
+
+				if err := VerifySolvency(prover.VerifyingKey(), proof, rootHash, reserves); err != nil {
+					t.Fatalf("Failed to verify proof: %v", err)
+				}
+				// The proof does not hold for lower reserves than proven
+				lowerReserves := new(big.Int).Sub(liabilities, big.NewInt(1))
+				if err := VerifySolvency(prover.VerifyingKey(), proof, rootHash, lowerReserves); err == nil {
+					t.Fatal("Expected the proof not to verify against lower reserves")
+				}
+				// Nor for the liabilities of another tree
+				if err := VerifySolvency(prover.VerifyingKey(), proof, otherTree.Root().Hash, reserves); err == nil {
+					t.Fatal("Expected the proof not to verify against another root")
+				}
+			}
+			t.Log("Solvency proofs verified successfully!")
+		})
+	}
+}
+
+func TestSolvencyRejectsInsolventExchanges(t *testing.T) {
+
+	accounts := createAccountBalances(4)
+	leaves := make([]MerkleSumLeaf, len(accounts))
+	for i := range accounts {
+		accounts[i].Balance = big.NewInt(int64(10 * (i + 1)))
+		leaf, err := NewMerkleSumLeaf(accounts[i].Address, accounts[i].Balance)
+		if err != nil {
+			t.Fatalf("Failed to build leaf: %v", err)
+		}
+		leaves[i] = leaf
+	}
+	tree, err := NewMerkleSumTree(leaves)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	liabilities := big.NewInt(100)
+
+	t.Run("ProverRefusesToProve", func(t *testing.T) {
+		prover, err := NewSolvencyProver(testProvingBackend(t), len(leaves), false)
+		if err != nil {
+			t.Fatalf("Failed to set up the solvency prover: %v", err)
+		}
+		_, err = prover.Prove(tree, new(big.Int).Sub(liabilities, big.NewInt(1)))
+		if !errors.Is(err, ErrInsolvent) {
+			t.Fatalf("Expected ErrInsolvent, got %v", err)
+		}
+	})
+
+	newAssignment := func(reserves *big.Int, tree *MerkleSumTree) *SolvencyCircuit {
+		leaves := tree.Assignment()
+		return &SolvencyCircuit{
+			AccountHashes: leaves.AccountHashes,
+			Salts:         leaves.Salts,
+			Balances:      leaves.Balances,
+			RootHash:      leaves.RootHash,
+			Reserves:      reserves,
+		}
+	}
+
+	// A tree whose root was honestly computed over a wrapped-around "negative"
+	// balance, which would bring the liabilities below the reserves
+	negativeLeaves := append([]MerkleSumLeaf{}, leaves...)
+	negativeLeaves[3] = MerkleSumLeaf{AccountHash: leaves[3].AccountHash, Salt: leaves[3].Salt, Balance: new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(50))}
+	negativeRoot, err := uncheckedMerkleSumRoot(negativeLeaves)
+	if err != nil {
+		t.Fatalf("Failed to hash tree: %v", err)
+	}
+	negativeAssignment := newAssignment(big.NewInt(60), tree)
+	for i, leaf := range negativeLeaves {
+		negativeAssignment.Balances[i] = leaf.Balance
+	}
+	negativeAssignment.RootHash = negativeRoot.Hash
+
+	// The accounts of the published tree claimed to hold nothing
+	zeroAssignment := newAssignment(big.NewInt(0), tree)
+	for i := range zeroAssignment.Balances {
+		zeroAssignment.Balances[i] = 0
+	}
+	// An empty tree proven against the published root
+	emptyTree, err := NewMerkleSumTreeWithCapacity(nil, len(leaves))
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	emptyAssignment := newAssignment(big.NewInt(0), emptyTree)
+	emptyAssignment.RootHash = tree.Root().Hash
+
+	testCases := []struct {
+		name       string
+		assignment *SolvencyCircuit
+		valid      bool
+	}{
+		{"ReservesAboveLiabilities", newAssignment(big.NewInt(150), tree), true},
+		{"ReservesEqualLiabilities", newAssignment(liabilities, tree), true},
+		{"LiabilitiesExceedReserves", newAssignment(big.NewInt(99), tree), false},
+		{"NoReserves", newAssignment(big.NewInt(0), tree), false},
+		{"WrappedNegativeBalance", negativeAssignment, false},
+		{"ZeroLiabilitiesAgainstTheRoot", zeroAssignment, false},
+		{"EmptyTreeAgainstTheRoot", emptyAssignment, false},
+	}
+
+	circuit := NewSolvencyCircuit(len(leaves))
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := test.IsSolved(circuit, tc.assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+		})
+	}
+
+	t.Run("CommitmentToOtherLiabilities", func(t *testing.T) {
+		blinding := big.NewInt(42)
+		commitment, err := mimcHash(new(big.Int).Sub(liabilities, big.NewInt(1)), blinding)
+		if err != nil {
+			t.Fatalf("Failed to compute commitment: %v", err)
+		}
+		assignment := &CommittedSolvencyCircuit{
+			SolvencyCircuit:       *newAssignment(big.NewInt(150), tree),
+			Blinding:              blinding,
+			LiabilitiesCommitment: commitment,
+		}
+		circuit := CommittedSolvencyCircuit{SolvencyCircuit: *NewSolvencyCircuit(len(leaves))}
+		if err := test.IsSolved(&circuit, assignment, ecc.BLS12_381.ScalarField()); err == nil {
+			t.Fatal("Expected the circuit not to be solved")
+		}
+	})
+}
+
+func TestSolvencyProverRejectsTreesOfAnotherShape(t *testing.T) {
+
+	prover, err := NewSolvencyProver(testProvingBackend(t), 4, false)
+	if err != nil {
+		t.Fatalf("Failed to set up the solvency prover: %v", err)
+	}
+	reserves := new(big.Int).Lsh(big.NewInt(1), 2*BALANCE_BITS)
+
+	largerTree, err := createMerkleSumTree(5)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	if _, err := prover.Prove(largerTree, reserves); err == nil {
+		t.Fatal("Expected an error for a tree of more leaves than the circuit")
+	}
+	bn254Tree, err := createMerkleSumTreeOnCurve(ecc.BN254, 4)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	if _, err := prover.Prove(bn254Tree, reserves); err == nil {
+		t.Fatal("Expected an error for a tree hashed on another curve")
+	}
+}
@@ -3,31 +3,170 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"sort"
 
+	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/frontend/schema"
 )
 
+// SumAggregationCircuit proves the total of the balances of a snapshot. It has
+// a fixed number of slots, its capacity, of which only the active ones hold
+// an account, so that one compiled circuit serves snapshots of any size up to
+// the capacity without revealing it.
+//
+// The account hashes are private and bound to nothing public: their strict
+// ordering proves that no account is counted twice, not that every account
+// exists. A prover may add or leave out accounts. SolvencyCircuit binds the
+// accounts to a published Merkle sum tree root instead.
 type SumAggregationCircuit struct {
-	Balances []frontend.Variable `gnark:"balances,secret"`  // User balances (private inputs)
-	TotalSum frontend.Variable   `gnark:"total_sum,public"` // Aggregated total (public output)
+	Balances       []frontend.Variable `gnark:"balances,secret"`        // User balances (private inputs)
+	AccountHashes  []frontend.Variable `gnark:"account_hashes,secret"`  // Account of every balance, in strictly increasing order
+	Active         []frontend.Variable `gnark:"active,secret"`          // Whether every slot holds an account
+	TotalSum       frontend.Variable   `gnark:"total_sum,public"`       // Aggregated total (public output)
+	Epoch          frontend.Variable   `gnark:"epoch,public"`           // Epoch the snapshot was taken at
+	SnapshotDigest frontend.Variable   `gnark:"snapshot_digest,public"` // Digest of the input dataset
+	BalanceBits    int                 `gnark:"-"`                      // Bit width of every balance (BALANCE_BITS if unset)
+}
+
+// NewSumAggregationCircuit returns the circuit with capacity slots
+func NewSumAggregationCircuit(capacity int) *SumAggregationCircuit {
+	return &SumAggregationCircuit{
+		Balances:      make([]frontend.Variable, capacity),
+		AccountHashes: make([]frontend.Variable, capacity),
+		Active:        make([]frontend.Variable, capacity),
+	}
+}
+
+// AccountBalance is one entry of the input data: the balance of an account
+type AccountBalance struct {
+	Address string   `json:"address"`
+	Balance *big.Int `json:"balance"`
+}
+
+// NewSumAggregationAssignment builds the witness assignment of a circuit of
+// capacity slots for the given snapshot. The accounts are sorted by account
+// hash, as the circuit expects them, and an account listed twice is rejected
+// with ErrDuplicateAccount. A malformed address is rejected with an error
+// naming it. The remaining slots are inactive.
+func NewSumAggregationAssignment(accounts []AccountBalance, capacity int, epoch uint64, snapshotDigest *big.Int) (*SumAggregationCircuit, error) {
+	return NewSumAggregationAssignmentOnCurve(ecc.BLS12_381, accounts, capacity, epoch, snapshotDigest)
+}
+
+// NewSumAggregationAssignmentOnCurve builds the witness assignment of a
+// circuit compiled on curve, whose account hashes are reduced in its scalar
+// field
+func NewSumAggregationAssignmentOnCurve(curve ecc.ID, accounts []AccountBalance, capacity int, epoch uint64, snapshotDigest *big.Int) (*SumAggregationCircuit, error) {
+	if len(accounts) > capacity {
+		return nil, fmt.Errorf("got %d accounts for a circuit of %d slots", len(accounts), capacity)
+	}
+	type hashedAccount struct {
+		AccountBalance
+		accountHash *big.Int
+	}
+
+	// The circuit compares the canonical account hashes
+	hashed := make([]hashedAccount, len(accounts))
+	for i, account := range accounts {
+		if account.Balance == nil || account.Balance.Sign() < 0 || account.Balance.BitLen() > BALANCE_BITS {
+			return nil, fmt.Errorf("balance of account %s does not fit in %d bits", account.Address, BALANCE_BITS)
+		}
+		accountHash, err := accountHashOfAddressOnCurve(curve, account.Address)
+		if err != nil {
+			return nil, err
+		}
+		hashed[i] = hashedAccount{account, accountHash}
+	}
+	sort.SliceStable(hashed, func(i, j int) bool {
+		return hashed[i].accountHash.Cmp(hashed[j].accountHash) < 0
+	})
+
+	assignment := NewSumAggregationCircuit(capacity)
+	assignment.Epoch, assignment.SnapshotDigest = epoch, snapshotDigest
+	totalSum := big.NewInt(0)
+	lastAccountHash := big.NewInt(0)
+	for i, account := range hashed {
+		if i > 0 {
+			previous := hashed[i-1]
+			if err := checkAccountOrder(previous.Address, previous.accountHash, account.Address, account.accountHash); err != nil {
+				return nil, err
+			}
+		}
+		assignment.Balances[i] = account.Balance
+		assignment.AccountHashes[i] = account.accountHash
+		assignment.Active[i] = 1
+		totalSum.Add(totalSum, account.Balance)
+		lastAccountHash = account.accountHash
+	}
+
+	// Inactive slots repeat the last account hash, the ordering only requires
+	// them not to decrease
+	for i := len(hashed); i < capacity; i++ {
+		assignment.Balances[i] = 0
+		assignment.AccountHashes[i] = lastAccountHash
+		assignment.Active[i] = 0
+	}
+	assignment.TotalSum = totalSum
+	return assignment, nil
 }
 
 func (circuit *SumAggregationCircuit) Define(api frontend.API) error {
+	_, err := circuit.assertTotalSum(api)
+	return err
+}
+
+// assertTotalSum proves the total of the active slots and returns their number
+func (circuit *SumAggregationCircuit) assertTotalSum(api frontend.API) (frontend.Variable, error) {
+	// Bind the proof to the snapshot
+	assertSnapshotBinding(api, circuit.Epoch, circuit.SnapshotDigest)
+
+	aggregateSum, nbAccounts, err := sumActiveBalances(api, circuit.Balances, circuit.AccountHashes, circuit.Active, balanceBitsOrDefault(circuit.BalanceBits))
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure aggregateSum matches the declared TotalSum
+	api.AssertIsEqual(aggregateSum, circuit.TotalSum)
+	return nbAccounts, nil
+}
+
+// sumActiveBalances returns the sum of the balances of the active slots and
+// their number, once the slots are checked to hold distinct accounts in
+// strictly increasing order and balances of balanceBits bits
+func sumActiveBalances(api frontend.API, balances, accountHashes, active []frontend.Variable, balanceBits int) (frontend.Variable, frontend.Variable, error) {
+	if len(accountHashes) != len(balances) || len(active) != len(balances) {
+		return nil, nil, errors.New("expected one account hash and active flag per balance")
+	}
+
+	// Inactive slots must hold a zero balance
+	nbAccounts := assertActiveFlags(api, active)
+	for i, balance := range balances {
+		assertInactiveSlotEmpty(api, active[i], balance, 0)
+	}
+
+	// Strictly increasing account hashes prove every account is counted once
+	if err := assertStrictlyIncreasing(api, accountHashes, active); err != nil {
+		return nil, nil, err
+	}
+
+	// Range-check every balance so that none of them can be "negative"
+	if err := assertBalancesInRange(api, balances, balanceBits); err != nil {
+		return nil, nil, err
+	}
+
 	// Initialize aggregate sum as zero
 	aggregateSum := frontend.Variable(0)
 
 	// Loop through each user's balance to compute an aggregate sum
-	for i := 0; i < len(circuit.Balances); i++ {
+	for i := 0; i < len(balances); i++ {
 		// Add each balance to the aggregate sum
-		aggregateSum = api.Add(aggregateSum, circuit.Balances[i])
+		aggregateSum = api.Add(aggregateSum, balances[i])
 	}
-
-	// Ensure aggregateSum matches the declared TotalSum
-	api.AssertIsEqual(aggregateSum, circuit.TotalSum)
-	return nil
+	return aggregateSum, nbAccounts, nil
 }
 
 // Implement io.WriterTo
@@ -49,7 +188,7 @@ func (w *SumAggregationCircuit) ReadFrom(reader io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int64(len(w.Balances) + 1), nil
+	return int64(len(w.Balances) + len(w.AccountHashes) + len(w.Active) + 3), nil
 }
 
 // Implement encoding.BinaryMarshaler
@@ -64,12 +203,13 @@ func (w *SumAggregationCircuit) UnmarshalBinary(data []byte) error {
 
 // Implement Public method
 func (w *SumAggregationCircuit) Public() (witness.Witness, error) {
-	return &SumAggregationCircuit{TotalSum: w.TotalSum}, nil
+	return &SumAggregationCircuit{TotalSum: w.TotalSum, Epoch: w.Epoch, SnapshotDigest: w.SnapshotDigest}, nil
 }
 
 // Implement Vector method
 func (w *SumAggregationCircuit) Vector() any {
-	return append(w.Balances, []frontend.Variable{w.TotalSum}...)
+	vector := append(append(append([]frontend.Variable{}, w.Balances...), w.AccountHashes...), w.Active...)
+	return append(vector, w.TotalSum, w.Epoch, w.SnapshotDigest)
 }
 
 // Implement ToJSON method
@@ -85,18 +225,37 @@ func (w *SumAggregationCircuit) FromJSON(s *schema.Schema, data []byte) error {
 // Implement Fill method
 func (w *SumAggregationCircuit) Fill(nbPublic, nbSecret int, values <-chan any) error {
 
-	if nbPublic != 1 {
-		return errors.New("expected 1 public input")
+	if nbPublic != 3 {
+		return errors.New("expected 3 public inputs")
 	}
 
-	w.Balances = make([]frontend.Variable, nbSecret)
-	for i := 0; i < nbSecret; i++ {
+	if nbSecret%3 != 0 {
+		return errors.New("expected one account hash and active flag per balance")
+	}
+	w.Balances = make([]frontend.Variable, nbSecret/3)
+	for i := range w.Balances {
 		v, ok := <-values
 		if !ok {
 			return errors.New("not enough values for secret inputs")
 		}
 		w.Balances[i] = v.(frontend.Variable)
 	}
+	w.AccountHashes = make([]frontend.Variable, nbSecret/3)
+	for i := range w.AccountHashes {
+		v, ok := <-values
+		if !ok {
+			return errors.New("not enough values for secret input account hashes")
+		}
+		w.AccountHashes[i] = v.(frontend.Variable)
+	}
+	w.Active = make([]frontend.Variable, nbSecret/3)
+	for i := range w.Active {
+		v, ok := <-values
+		if !ok {
+			return errors.New("not enough values for secret input active flags")
+		}
+		w.Active[i] = v.(frontend.Variable)
+	}
 
 	v, ok := <-values
 	if !ok {
@@ -104,5 +263,17 @@ func (w *SumAggregationCircuit) Fill(nbPublic, nbSecret int, values <-chan any)
 	}
 	w.TotalSum = v.(frontend.Variable)
 
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public input epoch")
+	}
+	w.Epoch = v.(frontend.Variable)
+
+	v, ok = <-values
+	if !ok {
+		return errors.New("not enough values for public input snapshot digest")
+	}
+	w.SnapshotDigest = v.(frontend.Variable)
+
 	return nil
 }
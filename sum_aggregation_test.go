@@ -7,45 +7,41 @@ import (
 	"testing"
 
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/test"
 )
 
-func createSumAggregationWitnesses() (*witness.Witness, *witness.Witness, error) {
-	// Example balances for three accounts
-	balances := make([]*big.Int, NB_ACCOUNTS)
-	for i := 0; i < NB_ACCOUNTS; i++ {
-		// Generate random balances
-
-		balances[i] = big.NewInt(int64(rand.Int64()))
+// testNbAccounts returns NB_ACCOUNTS, or a single batch of NB_BATCH_ACCOUNTS
+// with -short since ordering the account hashes dominates the setup
+func testNbAccounts() int {
+	if testing.Short() {
+		return NB_BATCH_ACCOUNTS
 	}
-	// log.Println("Balances: ", balances)
+	return NB_ACCOUNTS
+}
 
-	totalSum := big.NewInt(0)
-	for _, balance := range balances {
-		totalSum.Add(totalSum, balance)
+func createSumAggregationWitnesses(nbAccounts int) (*witness.Witness, *witness.Witness, error) {
+	accounts := make([]AccountBalance, nbAccounts)
+	for i := 0; i < nbAccounts; i++ {
+		// Generate random accounts and balances
+		accounts[i] = AccountBalance{Address: randomEthereumAddress(), Balance: big.NewInt(int64(rand.Int64()))}
 	}
 
 	// Set up circuit instance with values
-	circuit := SumAggregationCircuit{
-		TotalSum: totalSum,
-		Balances: make([]frontend.Variable, len(balances)),
-	}
-
-	for i := 0; i < len(balances); i++ {
-		circuit.Balances[i] = balances[i]
+	circuit, err := NewSumAggregationAssignment(accounts, nbAccounts, testEpoch, testSnapshotDigest)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Create a new witness instance
-	fullWitness, err := frontend.NewWitness(&circuit, ecc.BLS12_381.ScalarField())
+	fullWitness, err := frontend.NewWitness(circuit, ecc.BLS12_381.ScalarField())
 	if err != nil {
 		return nil, nil, err
 	}
 
-	publicWitness, err := frontend.NewWitness(&circuit, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
+	publicWitness, err := frontend.NewWitness(circuit, ecc.BLS12_381.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -55,30 +51,28 @@ func createSumAggregationWitnesses() (*witness.Witness, *witness.Witness, error)
 
 func TestSumAggregationProofAndVerification(t *testing.T) {
 
+	nbAccounts := testNbAccounts()
 	var err error
 	var circuit SumAggregationCircuit
 	var cs constraint.ConstraintSystem
-	var pk groth16.ProvingKey
-	var vk groth16.VerifyingKey
+	var pk ProvingKey
+	var vk VerifyingKey
 	var fw *witness.Witness
 	var pw *witness.Witness
-	var proof groth16.Proof
+	var proof Proof
 
 	t.Run("CompileCircuitAndCompleteSetup", func(t *testing.T) {
 
 		// Define the circuit
-		circuit = SumAggregationCircuit{
-			TotalSum: 0,
-			Balances: make([]frontend.Variable, NB_ACCOUNTS),
-		}
+		circuit = *NewSumAggregationCircuit(nbAccounts)
 
-		cs, err = frontend.Compile(ecc.BLS12_381.ScalarField(), r1cs.NewBuilder, &circuit)
+		cs, err = testProvingBackend(t).Compile(&circuit)
 		if err != nil {
 			t.Fatalf("Failed to compile circuit: %v", err)
 		}
 
 		// Generate the Groth16 keys
-		pk, vk, err = groth16.Setup(cs)
+		pk, vk, err = testProvingBackend(t).Setup(cs)
 		if err != nil {
 			t.Fatalf("Failed to set up proving and verifying keys: %v", err)
 		}
@@ -93,14 +87,14 @@ func TestSumAggregationProofAndVerification(t *testing.T) {
 		}
 
 		// Crete witness
-		fw, pw, err = createSumAggregationWitnesses()
+		fw, pw, err = createSumAggregationWitnesses(nbAccounts)
 		if err != nil {
 			t.Fatalf("Failed to create witness: %v", err)
 		}
 		t.Log("Witnesses created", t)
 
 		// Generate proof
-		proof, err = groth16.Prove(cs, pk, *fw)
+		proof, err = Prove(cs, pk, *fw)
 		if err != nil {
 			t.Fatalf("Failed to generate proof: %v", err)
 		}
@@ -116,7 +110,7 @@ func TestSumAggregationProofAndVerification(t *testing.T) {
 		}
 
 		// Verify proof
-		err = groth16.Verify(proof, vk, *pw)
+		err = Verify(proof, vk, *pw)
 		if err != nil {
 			t.Fatalf("Failed to verify proof: %v", err)
 		}
@@ -124,3 +118,65 @@ func TestSumAggregationProofAndVerification(t *testing.T) {
 
 	})
 }
+
+func TestSumAggregationRejectsOutOfRangeBalances(t *testing.T) {
+
+	// A "negative" balance wraps around the scalar field to p - x
+	negativeBalance := new(big.Int).Sub(ecc.BLS12_381.ScalarField(), big.NewInt(5))
+	tooLargeBalance := new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS)
+
+	circuit := NewSumAggregationCircuit(4)
+
+	cs, err := testProvingBackend(t).Compile(circuit)
+	if err != nil {
+		t.Fatalf("Failed to compile circuit: %v", err)
+	}
+	pk, _, err := testProvingBackend(t).Setup(cs)
+	if err != nil {
+		t.Fatalf("Failed to set up proving and verifying keys: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		balances []frontend.Variable
+		totalSum frontend.Variable
+		valid    bool
+	}{
+		{"ValidBalances", []frontend.Variable{10, 20, 0, 5}, 35, true},
+		{"WrappedNegativeBalance", []frontend.Variable{10, 20, negativeBalance, 5}, 30, false},
+		{"BalanceAboveBitWidth", []frontend.Variable{10, 20, tooLargeBalance, 5}, new(big.Int).Add(tooLargeBalance, big.NewInt(35)), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assignment := SumAggregationCircuit{
+				Balances:       tc.balances,
+				AccountHashes:  testAccountHashes(4),
+				Active:         activeSlots(4, 4),
+				TotalSum:       tc.totalSum,
+				Epoch:          testEpoch,
+				SnapshotDigest: testSnapshotDigest,
+			}
+
+			err := test.IsSolved(circuit, &assignment, ecc.BLS12_381.ScalarField())
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the circuit to be solved: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected the circuit not to be solved")
+			}
+
+			fw, err := frontend.NewWitness(&assignment, ecc.BLS12_381.ScalarField())
+			if err != nil {
+				t.Fatalf("Failed to create witness: %v", err)
+			}
+			_, err = Prove(cs, pk, fw)
+			if tc.valid && err != nil {
+				t.Fatalf("Failed to generate proof: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected proof generation to fail")
+			}
+		})
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/hex"
+	"fmt"
 	"log"
 	"math/big"
+	"math/bits"
 	"strings"
 	"time"
 
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/hash"
 	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/rangecheck"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -41,6 +46,61 @@ func hashToBigInt(hashHex string) *big.Int {
 	return hashInt
 }
 
+// curveOfField returns the curve whose scalar field is field
+func curveOfField(field *big.Int) (ecc.ID, error) {
+	for _, curve := range ecc.Implemented() {
+		if curve.ScalarField().Cmp(field) == 0 {
+			return curve, nil
+		}
+	}
+	return ecc.UNKNOWN, fmt.Errorf("no curve has the scalar field %v", field)
+}
+
+// scalarFieldBytes returns the size of a scalar field element of curve
+func scalarFieldBytes(curve ecc.ID) int {
+	return (curve.ScalarField().BitLen() + 7) / 8
+}
+
+// mimcHash natively hashes values with MiMC the way gnark's std/hash/mimc does
+// in-circuit on BLS12-381, every value being read as a scalar field element
+func mimcHash(values ...*big.Int) (*big.Int, error) {
+	return mimcHashOnCurve(ecc.BLS12_381, values...)
+}
+
+// mimcOfCurve returns the native MiMC instance of the scalar field of curve
+func mimcOfCurve(curve ecc.ID) (hash.Hash, error) {
+	var hashes = map[ecc.ID]hash.Hash{
+		ecc.BN254:     hash.MIMC_BN254,
+		ecc.BLS12_381: hash.MIMC_BLS12_381,
+		ecc.BLS12_377: hash.MIMC_BLS12_377,
+		ecc.BW6_761:   hash.MIMC_BW6_761,
+	}
+	h, ok := hashes[curve]
+	if !ok {
+		return 0, fmt.Errorf("no MiMC instance for %v", curve)
+	}
+	return h, nil
+}
+
+// mimcHashOnCurve natively hashes values with the MiMC instance of the scalar
+// field of curve
+func mimcHashOnCurve(curve ecc.ID, values ...*big.Int) (*big.Int, error) {
+	h, err := mimcOfCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	state := h.New()
+	field := curve.ScalarField()
+	for _, v := range values {
+		element := new(big.Int).Mod(v, field)
+		if _, err := state.Write(element.FillBytes(make([]byte, scalarFieldBytes(curve)))); err != nil {
+			return nil, err
+		}
+	}
+	return new(big.Int).SetBytes(state.Sum(nil)), nil
+}
+
 // Example: Convert frontend.Variable to *big.Int
 func variableToBigInt(variable frontend.Variable) *big.Int {
 	switch v := variable.(type) {
@@ -56,3 +116,38 @@ func variableToBigInt(variable frontend.Variable) *big.Int {
 		panic("Unsupported type for conversion")
 	}
 }
+
+// balanceBitsOrDefault returns nbBits, or BALANCE_BITS when it is left unset
+func balanceBitsOrDefault(nbBits int) int {
+	if nbBits == 0 {
+		return BALANCE_BITS
+	}
+	return nbBits
+}
+
+// checkBalanceBitWidth refuses bit widths for which the sum of nbBalances
+// balances could wrap around the scalar field
+func checkBalanceBitWidth(api frontend.API, nbBalances, nbBits int) error {
+	if nbBits <= 0 {
+		return fmt.Errorf("invalid balance bit width %d", nbBits)
+	}
+	fieldBits := api.Compiler().FieldBitLen()
+	if nbBits+bits.Len(uint(nbBalances)) >= fieldBits {
+		return fmt.Errorf("the sum of %d balances of %d bits may overflow the %d-bit scalar field", nbBalances, nbBits, fieldBits)
+	}
+	return nil
+}
+
+// assertBalancesInRange constrains every balance to [0, 2^nbBits) so that a
+// "negative" balance (p - x in the scalar field) cannot offset the others
+func assertBalancesInRange(api frontend.API, balances []frontend.Variable, nbBits int) error {
+	if err := checkBalanceBitWidth(api, len(balances), nbBits); err != nil {
+		return err
+	}
+
+	rangeChecker := rangecheck.New(api)
+	for _, balance := range balances {
+		rangeChecker.Check(balance, nbBits)
+	}
+	return nil
+}